@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+type MembershipResponse struct {
+	AllNodes     []string `json:"all_nodes"`
+	ClusterNodes []string `json:"cluster_nodes"`
+	// Consistent is true when every cluster node is connected and no other
+	// node is.
+	Consistent bool `json:"consistent"`
+}
+
+type ShardRange struct {
+	Range string   `json:"range"`
+	Nodes []string `json:"nodes"`
+}
+
+type ShardMapResponse struct {
+	Database string              `json:"database"`
+	Ranges   []ShardRange        `json:"ranges"`
+	ByNode   map[string][]string `json:"by_node"`
+}
+
+type NodeMemory struct {
+	Processes int64 `json:"processes"`
+	Binary    int64 `json:"binary"`
+	Code      int64 `json:"code"`
+	ETS       int64 `json:"ets"`
+	Atom      int64 `json:"atom"`
+	Other     int64 `json:"other"`
+}
+
+type NodeSystemResponse struct {
+	Node                    string           `json:"node"`
+	Error                   string           `json:"error,omitempty"`
+	UptimeSeconds           int64            `json:"uptime_seconds"`
+	Memory                  NodeMemory       `json:"memory"`
+	RunQueue                int64            `json:"run_queue"`
+	ProcessCount            int64            `json:"process_count"`
+	ProcessLimit            int64            `json:"process_limit"`
+	ContextSwitches         int64            `json:"context_switches"`
+	Reductions              int64            `json:"reductions"`
+	IOInputBytes            int64            `json:"io_input_bytes"`
+	IOOutputBytes           int64            `json:"io_output_bytes"`
+	OSProcCount             int64            `json:"os_proc_count"`
+	InternalReplicationJobs int64            `json:"internal_replication_jobs"`
+	MessageQueues           map[string]int64 `json:"message_queues,omitempty"`
+}
+
+type ActiveTask struct {
+	Type         string    `json:"type"`
+	Node         string    `json:"node"`
+	PID          string    `json:"pid"`
+	Database     string    `json:"database,omitempty"`
+	DesignDoc    string    `json:"design_document,omitempty"`
+	Source       string    `json:"source,omitempty"`
+	Target       string    `json:"target,omitempty"`
+	DocID        string    `json:"doc_id,omitempty"`
+	Phase        string    `json:"phase,omitempty"`
+	Progress     int       `json:"progress"`
+	ChangesDone  int64     `json:"changes_done"`
+	TotalChanges int64     `json:"total_changes"`
+	StartedOn    time.Time `json:"started_on"`
+	UpdatedOn    time.Time `json:"updated_on"`
+}
+
+// couchSystem is the subset of /_node/{node}/_system that is exposed.
+type couchSystem struct {
+	Uptime                  int64          `json:"uptime"`
+	Memory                  NodeMemory     `json:"memory"`
+	RunQueue                int64          `json:"run_queue"`
+	ProcessCount            int64          `json:"process_count"`
+	ProcessLimit            int64          `json:"process_limit"`
+	ContextSwitches         int64          `json:"context_switches"`
+	Reductions              int64          `json:"reductions"`
+	IOInput                 int64          `json:"io_input"`
+	IOOutput                int64          `json:"io_output"`
+	OSProcCount             int64          `json:"os_proc_count"`
+	InternalReplicationJobs int64          `json:"internal_replication_jobs"`
+	MessageQueues           map[string]any `json:"message_queues"`
+}
+
+type couchActiveTask struct {
+	Type         string `json:"type"`
+	Node         string `json:"node"`
+	PID          string `json:"pid"`
+	Database     string `json:"database"`
+	DesignDoc    string `json:"design_document"`
+	Source       string `json:"source"`
+	Target       string `json:"target"`
+	DocID        string `json:"doc_id"`
+	Phase        string `json:"phase"`
+	Progress     *int   `json:"progress"`
+	ChangesDone  int64  `json:"changes_done"`
+	TotalChanges int64  `json:"total_changes"`
+	StartedOn    int64  `json:"started_on"`
+	UpdatedOn    int64  `json:"updated_on"`
+}
+
+// registerAdminRoutes mounts the cluster admin API on r behind adminAuth.
+// Writes can be retried safely with an Idempotency-Key; on routes working on
+// a tenant, idempotency follows the tenant scope so that keys are scoped to
+// the tenant.
+func registerAdminRoutes(r *gin.Engine) {
+	admin := r.Group("/admin", adminAuth())
+	admin.GET("/membership", membershipHandler)
+	admin.GET("/shards", shardsHandler)
+	admin.GET("/nodes", nodesHandler)
+	admin.GET("/nodes/:node", nodeHandler)
+	admin.GET("/tasks", activeTasksHandler)
+	registerReplicationRoutes(admin)
+	registerWebhookRoutes(admin)
+	registerSchemaRoutes(admin)
+	admin.GET("/export", adminTenantScope(false), exportHandler)
+	admin.GET("/export/attachments", adminTenantScope(false), exportAttachmentsHandler)
+	admin.POST("/import", adminTenantScope(true), idempotency, importHandler)
+}
+
+// membershipHandler godoc
+// @Summary Cluster membership
+// @Description Lists the nodes of the cluster and the nodes currently connected
+// @Tags admin
+// @Produce json
+// @Security BasicAuth
+// @Success 200 {object} MembershipResponse
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Admin routes are disabled"
+// @Failure 500 {object} Problem "Failed to retrieve membership"
+// @Router /admin/membership [get]
+func membershipHandler(c *gin.Context) {
+	m, err := client.Membership(c.Request.Context())
+	if err != nil {
+		respondCouchError(c, "Failed to retrieve membership", err)
+		return
+	}
+
+	all, cluster := slices.Clone(m.AllNodes), slices.Clone(m.ClusterNodes)
+	slices.Sort(all)
+	slices.Sort(cluster)
+	c.JSON(http.StatusOK, MembershipResponse{
+		AllNodes:     all,
+		ClusterNodes: cluster,
+		Consistent:   slices.Equal(all, cluster),
+	})
+}
+
+// shardsHandler godoc
+// @Summary Shard map
+// @Description Returns the shard map of a database from the _dbs database, by range and by node
+// @Tags admin
+// @Produce json
+// @Security BasicAuth
+// @Param db query string false "Database" default(student)
+// @Success 200 {object} ShardMapResponse
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Admin routes are disabled"
+// @Failure 404 {object} Problem "Database not found"
+// @Failure 500 {object} Problem "Failed to retrieve shard map"
+// @Router /admin/shards [get]
+func shardsHandler(c *gin.Context) {
+	dbName := c.DefaultQuery("db", "student")
+
+	var doc struct {
+		ByNode  map[string][]string `json:"by_node"`
+		ByRange map[string][]string `json:"by_range"`
+	}
+	err := couchDo(c.Request.Context(), couchHTTP, http.MethodGet,
+		couchEndpoint("_node/_local/_dbs/"+url.PathEscape(dbName)), nil, &doc)
+	if err != nil {
+		if kivik.HTTPStatus(err) == http.StatusNotFound {
+			respondProblem(c, http.StatusNotFound, codeNotFound, "Database not found")
+			return
+		}
+		respondCouchError(c, "Failed to retrieve shard map", err, "db", dbName)
+		return
+	}
+
+	resp := ShardMapResponse{Database: dbName, ByNode: doc.ByNode, Ranges: []ShardRange{}}
+	for r, nodes := range doc.ByRange {
+		slices.Sort(nodes)
+		resp.Ranges = append(resp.Ranges, ShardRange{Range: r, Nodes: nodes})
+	}
+	sort.Slice(resp.Ranges, func(i, j int) bool { return resp.Ranges[i].Range < resp.Ranges[j].Range })
+	c.JSON(http.StatusOK, resp)
+}
+
+// nodesHandler godoc
+// @Summary System stats of every node
+// @Description Returns _node/{node}/_system stats for every cluster node. Nodes that cannot be reached carry an error.
+// @Tags admin
+// @Produce json
+// @Security BasicAuth
+// @Success 200 {array} NodeSystemResponse
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Admin routes are disabled"
+// @Failure 500 {object} Problem "Failed to retrieve membership"
+// @Router /admin/nodes [get]
+func nodesHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	m, err := client.Membership(ctx)
+	if err != nil {
+		respondCouchError(c, "Failed to retrieve membership", err)
+		return
+	}
+
+	nodes := slices.Clone(m.ClusterNodes)
+	slices.Sort(nodes)
+	stats := make([]NodeSystemResponse, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s, err := nodeSystem(ctx, node)
+			if err != nil {
+				logError(c, "Failed to retrieve node stats", err, "node", node)
+				s = NodeSystemResponse{Node: node, Error: "Node cannot be reached."}
+			}
+			stats[i] = s
+		}()
+	}
+	wg.Wait()
+	c.JSON(http.StatusOK, stats)
+}
+
+// nodeHandler godoc
+// @Summary System stats of one node
+// @Description Returns _node/{node}/_system stats for a single node, e.g. couchdb@couchdb-0.local or _local
+// @Tags admin
+// @Produce json
+// @Security BasicAuth
+// @Param node path string true "Node name"
+// @Success 200 {object} NodeSystemResponse
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Admin routes are disabled"
+// @Failure 404 {object} Problem "Node not found"
+// @Failure 500 {object} Problem "Failed to retrieve node stats"
+// @Router /admin/nodes/{node} [get]
+func nodeHandler(c *gin.Context) {
+	node := c.Param("node")
+	s, err := nodeSystem(c.Request.Context(), node)
+	if err != nil {
+		if kivik.HTTPStatus(err) == http.StatusNotFound {
+			respondProblem(c, http.StatusNotFound, codeNotFound, "Node not found")
+			return
+		}
+		respondCouchError(c, "Failed to retrieve node stats", err, "node", node)
+		return
+	}
+	c.JSON(http.StatusOK, s)
+}
+
+// nodeSystem fetches and normalizes the _system stats of node.
+func nodeSystem(ctx context.Context, node string) (NodeSystemResponse, error) {
+	var s couchSystem
+	err := couchDo(ctx, couchHTTP, http.MethodGet, couchEndpoint("_node/"+url.PathEscape(node)+"/_system"), nil, &s)
+	if err != nil {
+		return NodeSystemResponse{}, err
+	}
+
+	// Each message queue is either a length or, for grouped queues, an
+	// object with a "count" field.
+	queues := make(map[string]int64, len(s.MessageQueues))
+	for name, v := range s.MessageQueues {
+		switch q := v.(type) {
+		case float64:
+			queues[name] = int64(q)
+		case map[string]any:
+			if count, ok := q["count"].(float64); ok {
+				queues[name] = int64(count)
+			}
+		}
+	}
+
+	return NodeSystemResponse{
+		Node:                    node,
+		UptimeSeconds:           s.Uptime,
+		Memory:                  s.Memory,
+		RunQueue:                s.RunQueue,
+		ProcessCount:            s.ProcessCount,
+		ProcessLimit:            s.ProcessLimit,
+		ContextSwitches:         s.ContextSwitches,
+		Reductions:              s.Reductions,
+		IOInputBytes:            s.IOInput,
+		IOOutputBytes:           s.IOOutput,
+		OSProcCount:             s.OSProcCount,
+		InternalReplicationJobs: s.InternalReplicationJobs,
+		MessageQueues:           queues,
+	}, nil
+}
+
+// activeTasksHandler godoc
+// @Summary Active tasks
+// @Description Lists the cluster's active tasks (indexing, compaction, replication) with progress in percent
+// @Tags admin
+// @Produce json
+// @Security BasicAuth
+// @Param type query string false "Only tasks of this type, e.g. replication or indexer"
+// @Success 200 {array} ActiveTask
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Admin routes are disabled"
+// @Failure 500 {object} Problem "Failed to retrieve active tasks"
+// @Router /admin/tasks [get]
+func activeTasksHandler(c *gin.Context) {
+	var tasks []couchActiveTask
+	err := couchDo(c.Request.Context(), couchHTTP, http.MethodGet, couchEndpoint("_active_tasks"), nil, &tasks)
+	if err != nil {
+		respondCouchError(c, "Failed to retrieve active tasks", err)
+		return
+	}
+
+	taskType := c.Query("type")
+	resp := []ActiveTask{}
+	for _, t := range tasks {
+		if taskType != "" && t.Type != taskType {
+			continue
+		}
+		progress := 0
+		if t.Progress != nil {
+			progress = *t.Progress
+		} else if t.TotalChanges > 0 {
+			progress = int(100 * t.ChangesDone / t.TotalChanges)
+		}
+		resp = append(resp, ActiveTask{
+			Type:         t.Type,
+			Node:         t.Node,
+			PID:          t.PID,
+			Database:     t.Database,
+			DesignDoc:    t.DesignDoc,
+			Source:       redactURL(t.Source),
+			Target:       redactURL(t.Target),
+			DocID:        t.DocID,
+			Phase:        t.Phase,
+			Progress:     progress,
+			ChangesDone:  t.ChangesDone,
+			TotalChanges: t.TotalChanges,
+			StartedOn:    time.Unix(t.StartedOn, 0).UTC(),
+			UpdatedOn:    time.Unix(t.UpdatedOn, 0).UTC(),
+		})
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+const auditDB = "audit"
+
+// auditTimeFormat has a fixed width, so record times sort as strings.
+const auditTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// auditDesignDoc makes the audit database append-only: records can be added
+// but not changed or deleted, not even by admins.
+var auditDesignDoc = map[string]interface{}{
+	"validate_doc_update": `function (newDoc, oldDoc) {
+  if (oldDoc) {
+    throw({forbidden: "audit records are append-only"});
+  }
+}`,
+}
+
+// auditIndexes back the GET /audit queries, each sorted by time.
+var auditIndexes = map[string][]string{
+	"by-time":  {"time"},
+	"by-doc":   {"doc_id", "time"},
+	"by-actor": {"actor", "time"},
+}
+
+// auditSetup tracks whether the audit database has its design document and
+// indexes. It is retried on every use until it succeeds.
+var auditSetup struct {
+	sync.Mutex
+	done bool
+}
+
+type FieldChange struct {
+	// Field is the flattened field name, such as address.city.
+	Field string      `json:"field"`
+	Old   interface{} `json:"old,omitempty"`
+	New   interface{} `json:"new,omitempty"`
+}
+
+type AuditRecord struct {
+	ID     string `json:"_id,omitempty"`
+	Tenant string `json:"tenant,omitempty"`
+	Time   string `json:"time"`
+	Actor  string `json:"actor"`
+	Action string `json:"action"` // insert, update, patch, trash, delete, restore, upload or import
+	Method string `json:"method"`
+	Route  string `json:"route"`
+	DocID  string `json:"doc_id"`
+	OldRev string `json:"old_rev,omitempty"`
+	NewRev string `json:"new_rev,omitempty"`
+	// Changes lists the fields that differ between the old and the new
+	// revision. CouchDB's own fields are left out.
+	Changes   []FieldChange `json:"changes,omitempty"`
+	RequestID string        `json:"request_id,omitempty"`
+}
+
+type AuditResponse struct {
+	Records []AuditRecord `json:"records"`
+	// Bookmark fetches the next page when passed back.
+	Bookmark string `json:"bookmark,omitempty"`
+}
+
+// ensureAuditDB installs the design document and indexes of the audit
+// database.
+func ensureAuditDB(ctx context.Context) error {
+	auditSetup.Lock()
+	defer auditSetup.Unlock()
+	if auditSetup.done {
+		return nil
+	}
+
+	db := client.DB(auditDB)
+	_, err := db.Put(ctx, "_design/audit", auditDesignDoc)
+	if err != nil && kivik.HTTPStatus(err) != http.StatusConflict {
+		return err
+	}
+	for name, fields := range auditIndexes {
+		if err := db.CreateIndex(ctx, "audit", name, map[string]interface{}{"fields": fields}); err != nil {
+			return err
+		}
+	}
+	auditSetup.done = true
+	return nil
+}
+
+// actor identifies who made a request: the admin user for authenticated
+// routes, otherwise the API key or client address as used for rate limits.
+func actor(c *gin.Context) string {
+	if user := c.GetString(gin.AuthUserKey); user != "" {
+		return "user:" + user
+	}
+	return clientKey(c)
+}
+
+// diffDocs lists the flattened fields that differ between before and after.
+// Either may be nil for a created or deleted document.
+func diffDocs(before, after map[string]interface{}) []FieldChange {
+	oldFields, newFields := map[string]interface{}{}, map[string]interface{}{}
+	flatten(oldFields, "", before)
+	flatten(newFields, "", after)
+
+	var changes []FieldChange
+	for field, old := range oldFields {
+		if nv, ok := newFields[field]; !ok || !jsonEqual(old, nv) {
+			changes = append(changes, FieldChange{Field: field, Old: old, New: nv})
+		}
+	}
+	for field, nv := range newFields {
+		if _, ok := oldFields[field]; !ok {
+			changes = append(changes, FieldChange{Field: field, New: nv})
+		}
+	}
+	kept := changes[:0]
+	for _, ch := range changes {
+		if !strings.HasPrefix(ch.Field, "_") {
+			kept = append(kept, ch)
+		}
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].Field < kept[j].Field })
+	return kept
+}
+
+// newAuditRecord describes a change of docID made by the request of c.
+func newAuditRecord(c *gin.Context, action, docID string, before, after map[string]interface{}, oldRev, newRev string) AuditRecord {
+	return callerOf(c).auditRecord(c.Request.Context(), action, docID, before, after, oldRev, newRev)
+}
+
+// auditRecord returns the record of a change the caller made.
+func (who caller) auditRecord(ctx context.Context, action, docID string, before, after map[string]interface{}, oldRev, newRev string) AuditRecord {
+	return AuditRecord{
+		Tenant:    who.tenant,
+		Time:      time.Now().UTC().Format(auditTimeFormat),
+		Actor:     who.actor,
+		Action:    action,
+		Method:    who.method,
+		Route:     who.route,
+		DocID:     docID,
+		OldRev:    oldRev,
+		NewRev:    newRev,
+		Changes:   diffDocs(before, after),
+		RequestID: requestID(ctx),
+	}
+}
+
+// recordAudit stores the audit records of a request.
+func recordAudit(c *gin.Context, records ...AuditRecord) {
+	storeAudit(c.Request.Context(), records...)
+}
+
+// storeAudit stores audit records. The change they describe has already
+// been made, so a failure is logged rather than returned, and a client
+// disconnecting does not cancel the write.
+func storeAudit(ctx context.Context, records ...AuditRecord) {
+	if len(records) == 0 {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	if err := ensureAuditDB(ctx); err != nil {
+		slog.ErrorContext(ctx, "Failed to prepare audit database", "error", err)
+	}
+	docs := make([]interface{}, len(records))
+	for i := range records {
+		docs[i] = records[i]
+	}
+	start := time.Now()
+	results, err := client.DB(auditDB).BulkDocs(ctx, docs)
+	observeCouch("BulkDocs", start, err)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to write audit records", "records", len(records), "error", err)
+		return
+	}
+	for i, r := range results {
+		if r.Error != nil {
+			slog.ErrorContext(ctx, "Failed to write audit record", "doc_id", records[i].DocID, "action", records[i].Action, "error", r.Error)
+		}
+	}
+}
+
+// auditHandler godoc
+// @Summary Query the audit log
+// @Description Lists audit records of document changes, newest first. Each record holds the actor, route, document
+// @Description ID, old and new revision, the changed fields and the request ID. Filters combine; since and until are
+// @Description RFC 3339 times. Only records of the tenant named by the tenant parameter are listed.
+// @Tags admin
+// @Produce json
+// @Security BasicAuth
+// @Param tenant query string false "Tenant whose records are listed; those of the default database without it"
+// @Param doc_id query string false "Document ID"
+// @Param actor query string false "Actor, e.g. user:admin, key:… or ip:…"
+// @Param since query string false "Only records at or after this time"
+// @Param until query string false "Only records before this time"
+// @Param limit query int false "Maximum number of records" default(100)
+// @Param bookmark query string false "Bookmark of the previous page"
+// @Success 200 {object} AuditResponse
+// @Failure 400 {object} Problem "Invalid filter"
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Admin routes are disabled"
+// @Failure 429 {object} Problem "Rate limit or daily quota exceeded."
+// @Failure 500 {object} Problem "Failed to query audit log"
+// @Deprecated
+// @Router /audit [get]
+func auditHandler(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 {
+		respondProblem(c, http.StatusBadRequest, codeInvalidRequest, "limit must be a positive number")
+		return
+	}
+	timeRange := map[string]interface{}{"$gt": nil}
+	for param, op := range map[string]string{"since": "$gte", "until": "$lt"} {
+		if v := c.Query(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				respondProblem(c, http.StatusBadRequest, codeInvalidRequest, param+" must be an RFC 3339 time")
+				return
+			}
+			timeRange[op] = t.UTC().Format(auditTimeFormat)
+		}
+	}
+
+	// The sort has to match one of auditIndexes.
+	selector := map[string]interface{}{"time": timeRange, "tenant": map[string]interface{}{"$exists": false}}
+	if tenant := tenantOf(c); tenant != "" {
+		selector["tenant"] = tenant
+	}
+	sortBy := []interface{}{map[string]string{"time": "desc"}}
+	if docID := c.Query("doc_id"); docID != "" {
+		selector["doc_id"] = docID
+		sortBy = []interface{}{map[string]string{"doc_id": "desc"}, map[string]string{"time": "desc"}}
+	}
+	if a := c.Query("actor"); a != "" {
+		selector["actor"] = a
+		if c.Query("doc_id") == "" {
+			sortBy = []interface{}{map[string]string{"actor": "desc"}, map[string]string{"time": "desc"}}
+		}
+	}
+	query := map[string]interface{}{"selector": selector, "sort": sortBy, "limit": limit}
+	if bookmark := c.Query("bookmark"); bookmark != "" {
+		query["bookmark"] = bookmark
+	}
+
+	ctx := c.Request.Context()
+	if err := ensureAuditDB(ctx); err != nil {
+		respondCouchError(c, "Failed to prepare audit database", err)
+		return
+	}
+	start := time.Now()
+	rs := client.DB(auditDB).Find(ctx, query)
+	defer rs.Close()
+	resp := AuditResponse{Records: []AuditRecord{}}
+	for rs.Next() {
+		var record AuditRecord
+		if err = rs.ScanDoc(&record); err != nil {
+			break
+		}
+		resp.Records = append(resp.Records, record)
+	}
+	if err == nil {
+		err = rs.Err()
+	}
+	observeCouch("Find", start, err)
+	if err != nil {
+		respondCouchError(c, "Failed to query audit log", err)
+		return
+	}
+	if meta, err := rs.Metadata(); err == nil && len(resp.Records) == limit {
+		resp.Bookmark = meta.Bookmark
+	}
+	c.JSON(http.StatusOK, resp)
+}
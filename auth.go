@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminAccounts are allowed on the /admin routes. Unless ADMIN_USER and
+// ADMIN_PASSWORD are set they are the CouchDB admin credentials of couchURL.
+// Without a password there are none, and the admin routes are disabled.
+var adminAccounts = func() gin.Accounts {
+	user, password := "admin", ""
+	if u, err := url.Parse(couchURL); err == nil && u.User != nil {
+		user = u.User.Username()
+		password, _ = u.User.Password()
+	}
+	user = envString("ADMIN_USER", user)
+	password = envString("ADMIN_PASSWORD", password)
+	if password == "" {
+		return nil
+	}
+	return gin.Accounts{user: password}
+}()
+
+// adminAuth protects the admin routes with HTTP basic authentication. Like
+// gin.BasicAuth, it puts the user under gin.AuthUserKey, but it refuses
+// requests with a problem.
+func adminAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(adminAccounts) == 0 {
+			respondProblem(c, http.StatusForbidden, codeForbidden, "Admin routes are disabled: no admin password is configured.")
+			return
+		}
+		user, password, ok := c.Request.BasicAuth()
+		want, known := adminAccounts[user]
+		if !ok || !known || subtle.ConstantTimeCompare([]byte(password), []byte(want)) != 1 {
+			c.Header("WWW-Authenticate", `Basic realm="Student API admin"`)
+			respondProblem(c, http.StatusUnauthorized, codeUnauthorized, "Admin credentials are required.")
+			return
+		}
+		c.Set(gin.AuthUserKey, user)
+		c.Next()
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+// documentCache holds recently read student documents of every tenant for GET
+// /document/:id. CACHE_SIZE of 0 turns it off.
+var documentCache = newDocCache(
+	envInt("CACHE_SIZE", 1000),
+	time.Duration(envInt("CACHE_TTL_SECONDS", 60))*time.Second,
+)
+
+type cacheKey struct {
+	db, id string
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	rev     string
+	doc     json.RawMessage
+	expires time.Time
+}
+
+// docCache is an LRU cache of documents by database and ID, each stored with
+// its rev. It only serves documents of databases whose changes feed, which
+// invalidates it, is being followed, since otherwise writes by other
+// instances would go unnoticed.
+type docCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[cacheKey]*list.Element
+	// lru has the most recently used entry at the front.
+	lru       *list.List
+	following map[string]bool
+	// generations counts the invalidations of each database, so that a
+	// document read while it was being changed is not cached. Writes to
+	// other databases do not hold back caching.
+	generations map[string]uint64
+}
+
+func newDocCache(size int, ttl time.Duration) *docCache {
+	return &docCache{
+		size:        size,
+		ttl:         ttl,
+		entries:     map[cacheKey]*list.Element{},
+		lru:         list.New(),
+		following:   map[string]bool{},
+		generations: map[string]uint64{},
+	}
+}
+
+// get returns the cached document id of db. gen is passed to put when the
+// document is fetched after a miss.
+func (dc *docCache) get(db, id string, now time.Time) (doc json.RawMessage, gen uint64, ok bool) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if el, found := dc.entries[cacheKey{db, id}]; found && dc.following[db] {
+		e := el.Value.(*cacheEntry)
+		if now.Before(e.expires) {
+			dc.lru.MoveToFront(el)
+			cacheLookups.WithLabelValues("hit").Inc()
+			return e.doc, dc.generations[db], true
+		}
+		dc.removeElement(el)
+	}
+	cacheLookups.WithLabelValues("miss").Inc()
+	return nil, dc.generations[db], false
+}
+
+// put caches revision rev of document id of db, unless a document of db was
+// invalidated since gen was returned by get.
+func (dc *docCache) put(db, id, rev string, doc json.RawMessage, gen uint64, now time.Time) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if dc.size <= 0 || !dc.following[db] || gen != dc.generations[db] {
+		return
+	}
+	key := cacheKey{db, id}
+	if el, found := dc.entries[key]; found {
+		dc.removeElement(el)
+	}
+	dc.entries[key] = dc.lru.PushFront(&cacheEntry{key: key, rev: rev, doc: doc, expires: now.Add(dc.ttl)})
+	for dc.lru.Len() > dc.size {
+		dc.removeElement(dc.lru.Back())
+	}
+	cacheEntries.Set(float64(dc.lru.Len()))
+}
+
+// invalidate drops document id of db unless revision rev of it is cached. An
+// empty rev always drops it.
+func (dc *docCache) invalidate(db, id, rev string) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	dc.generations[db]++
+	if el, found := dc.entries[cacheKey{db, id}]; found && (rev == "" || el.Value.(*cacheEntry).rev != rev) {
+		dc.removeElement(el)
+	}
+}
+
+// setFollowing drops the documents of db and turns caching them on or off.
+func (dc *docCache) setFollowing(db string, following bool) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	dc.generations[db]++
+	dc.following[db] = following
+	for key, el := range dc.entries {
+		if key.db == db {
+			dc.removeElement(el)
+		}
+	}
+}
+
+func (dc *docCache) removeElement(el *list.Element) {
+	dc.lru.Remove(el)
+	delete(dc.entries, el.Value.(*cacheEntry).key)
+	cacheEntries.Set(float64(dc.lru.Len()))
+}
+
+// followCacheInvalidations invalidates documentCache from the changes feeds
+// of the student databases until ctx is done.
+func followCacheInvalidations(ctx context.Context) {
+	if documentCache.size <= 0 {
+		return
+	}
+	followStudentDBs(ctx, followDBInvalidations)
+}
+
+// followDBInvalidations invalidates the documents of db from its changes
+// feed. While the feed is down they are not cached.
+func followDBInvalidations(ctx context.Context, db string) {
+	for delay := time.Second; ctx.Err() == nil; delay = min(2*delay, time.Minute) {
+		changes := client.DB(db).Changes(ctx, kivik.Options{
+			"feed":      "continuous",
+			"since":     "now",
+			"heartbeat": 30000,
+		})
+		documentCache.setFollowing(db, true)
+		for changes.Next() {
+			documentCache.invalidate(db, changes.ID(), firstRev(changes.Changes()))
+			delay = time.Second
+		}
+		err := changes.Err()
+		changes.Close()
+		documentCache.setFollowing(db, false)
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Warn("Cache invalidation feed stopped", "db", db, "error", err, "retry_in", delay.String())
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+	}
+}
+
+// firstRev returns the winning rev of a change, or "" if there is none.
+func firstRev(revs []string) string {
+	if len(revs) == 0 {
+		return ""
+	}
+	return revs[0]
+}
+
+// invalidateCache drops the document a write route changed from the cache as
+// soon as the handler is done, so that this instance reads its own writes
+// without waiting for the changes feed.
+func invalidateCache(c *gin.Context) {
+	c.Next()
+	for _, id := range []string{c.Param("docID"), c.Param("id"), c.PostForm("docID")} {
+		if id != "" {
+			documentCache.invalidate(studentDBName(c), id, "")
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDocCacheGenerationPerDatabase(t *testing.T) {
+	dc := newDocCache(10, time.Minute)
+	dc.setFollowing("a", true)
+	dc.setFollowing("b", true)
+	now := time.Now()
+	doc := json.RawMessage(`{"_id":"s1"}`)
+
+	_, genA, _ := dc.get("a", "s1", now)
+	_, genB, _ := dc.get("b", "s1", now)
+	dc.invalidate("b", "s2", "")
+	dc.put("a", "s1", "1-a", doc, genA, now)
+	if _, _, ok := dc.get("a", "s1", now); !ok {
+		t.Error("a write to another database kept s1 of a from being cached")
+	}
+	dc.put("b", "s1", "1-a", doc, genB, now)
+	if _, _, ok := dc.get("b", "s1", now); ok {
+		t.Error("s1 of b was cached although b changed while it was read")
+	}
+}
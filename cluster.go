@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+// clusterNode is one CouchDB node as seen from this binary (url) and from the
+// other nodes (host, the Erlang node host name).
+type clusterNode struct {
+	url  *url.URL
+	host string
+}
+
+// clusterSetup drives CouchDB's _cluster_setup API. The first node acts as
+// the coordinator that the others are added to.
+type clusterSetup struct {
+	nodes []clusterNode
+	http  *http.Client
+	out   io.Writer
+}
+
+type clusterSetupState struct {
+	State string `json:"state"`
+}
+
+// runCluster implements the "cluster" command.
+func runCluster(args []string, out io.Writer) error {
+	if len(args) == 0 || args[0] != "init" {
+		return fmt.Errorf("usage: cluster init [flags]")
+	}
+
+	fs := flag.NewFlagSet("cluster init", flag.ContinueOnError)
+	urls := fs.String("nodes", strings.Join(couchNodes, ","), "comma-separated URLs of every node, with admin credentials")
+	hosts := fs.String("hosts", "couchdb-0.local,couchdb-1.local,couchdb-2.local", "comma-separated host names the nodes use to reach each other, in the same order")
+	timeout := fs.Duration("timeout", 2*time.Minute, "overall timeout")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	setup, err := newClusterSetup(strings.Split(*urls, ","), strings.Split(*hosts, ","), out)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	return setup.run(ctx)
+}
+
+func newClusterSetup(urls, hosts []string, out io.Writer) (*clusterSetup, error) {
+	if len(urls) != len(hosts) {
+		return nil, fmt.Errorf("got %d node URLs but %d host names", len(urls), len(hosts))
+	}
+	s := &clusterSetup{http: &http.Client{Timeout: 30 * time.Second}, out: out}
+	for i, raw := range urls {
+		u, err := url.Parse(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("node %d: %w", i, err)
+		}
+		if u.User == nil {
+			return nil, fmt.Errorf("node %s: URL must contain admin credentials", u.Host)
+		}
+		s.nodes = append(s.nodes, clusterNode{url: u, host: strings.TrimSpace(hosts[i])})
+	}
+	return s, nil
+}
+
+// run performs every setup step that is not done yet and reports each one.
+// Running it again against a finished cluster changes nothing.
+func (s *clusterSetup) run(ctx context.Context) error {
+	coordinator := s.nodes[0]
+	user := coordinator.url.User.Username()
+	password, _ := coordinator.url.User.Password()
+
+	for _, node := range s.nodes {
+		state, err := s.state(ctx, node)
+		if err != nil {
+			return err
+		}
+		step := "enable cluster on " + node.host
+		if state == "cluster_enabled" || state == "cluster_finished" {
+			s.report(step, "already done")
+			continue
+		}
+		err = s.post(ctx, node, "_cluster_setup", map[string]interface{}{
+			"action":       "enable_cluster",
+			"bind_address": "0.0.0.0",
+			"username":     user,
+			"password":     password,
+			"node_count":   len(s.nodes),
+		}, nil)
+		if err != nil {
+			return fmt.Errorf("%s: %w", step, err)
+		}
+		s.report(step, "done")
+	}
+
+	m, err := s.membership(ctx, coordinator)
+	if err != nil {
+		return err
+	}
+	for _, node := range s.nodes[1:] {
+		step := "add node " + node.host
+		if slices.Contains(m.ClusterNodes, "couchdb@"+node.host) {
+			s.report(step, "already done")
+			continue
+		}
+		err := s.post(ctx, coordinator, "_cluster_setup", map[string]interface{}{
+			"action":   "add_node",
+			"host":     node.host,
+			"port":     5984, // the port inside the cluster network
+			"username": user,
+			"password": password,
+		}, nil)
+		if err != nil {
+			return fmt.Errorf("%s: %w", step, err)
+		}
+		s.report(step, "done")
+	}
+
+	state, err := s.state(ctx, coordinator)
+	if err != nil {
+		return err
+	}
+	if state == "cluster_finished" {
+		s.report("finish cluster", "already done")
+	} else {
+		if err := s.post(ctx, coordinator, "_cluster_setup", map[string]string{"action": "finish_cluster"}, nil); err != nil {
+			return fmt.Errorf("finish cluster: %w", err)
+		}
+		s.report("finish cluster", "done")
+	}
+
+	return s.verify(ctx)
+}
+
+// verify checks that the coordinator sees every node as a connected member.
+func (s *clusterSetup) verify(ctx context.Context) error {
+	m, err := s.membership(ctx, s.nodes[0])
+	if err != nil {
+		return err
+	}
+	for _, node := range s.nodes {
+		name := "couchdb@" + node.host
+		if !slices.Contains(m.ClusterNodes, name) || !slices.Contains(m.AllNodes, name) {
+			return fmt.Errorf("verify membership: %s is not a connected cluster member (all_nodes %v, cluster_nodes %v)",
+				name, m.AllNodes, m.ClusterNodes)
+		}
+	}
+	s.report("verify membership", fmt.Sprintf("ok, %d nodes", len(m.ClusterNodes)))
+	return nil
+}
+
+func (s *clusterSetup) report(step, status string) {
+	fmt.Fprintf(s.out, "%-40s %s\n", step, status)
+}
+
+func (s *clusterSetup) state(ctx context.Context, node clusterNode) (string, error) {
+	var state clusterSetupState
+	if err := s.get(ctx, node, "_cluster_setup", &state); err != nil {
+		return "", fmt.Errorf("cluster setup state of %s: %w", node.host, err)
+	}
+	return state.State, nil
+}
+
+func (s *clusterSetup) membership(ctx context.Context, node clusterNode) (*kivik.ClusterMembership, error) {
+	var m kivik.ClusterMembership
+	if err := s.get(ctx, node, "_membership", &m); err != nil {
+		return nil, fmt.Errorf("membership of %s: %w", node.host, err)
+	}
+	return &m, nil
+}
+
+func (s *clusterSetup) get(ctx context.Context, node clusterNode, path string, dest interface{}) error {
+	return s.do(ctx, node, http.MethodGet, path, nil, dest)
+}
+
+func (s *clusterSetup) post(ctx context.Context, node clusterNode, path string, body, dest interface{}) error {
+	return s.do(ctx, node, http.MethodPost, path, body, dest)
+}
+
+func (s *clusterSetup) do(ctx context.Context, node clusterNode, method, path string, body, dest interface{}) error {
+	return couchDo(ctx, s.http, method, node.url.JoinPath(path).String(), body, dest)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeCluster serves _cluster_setup and _membership for a set of fake nodes,
+// the first of which is the coordinator, and records the setup actions.
+type fakeCluster struct {
+	mu      sync.Mutex
+	hosts   []string
+	states  []string
+	members []string
+	actions []string
+	servers []*httptest.Server
+}
+
+func newFakeCluster(t *testing.T, states []string, members ...string) *fakeCluster {
+	f := &fakeCluster{states: states, members: members}
+	for i := range states {
+		f.hosts = append(f.hosts, "couchdb-"+strconv.Itoa(i)+".local")
+		srv := httptest.NewServer(f.handler(i))
+		t.Cleanup(srv.Close)
+		f.servers = append(f.servers, srv)
+	}
+	return f
+}
+
+func (f *fakeCluster) handler(node int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if user, password, ok := r.BasicAuth(); !ok || user != "admin" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/_cluster_setup":
+			json.NewEncoder(w).Encode(map[string]string{"state": f.states[node]})
+		case r.Method == http.MethodGet && r.URL.Path == "/_membership" && node == 0:
+			json.NewEncoder(w).Encode(map[string][]string{"all_nodes": f.members, "cluster_nodes": f.members})
+		case r.Method == http.MethodPost && r.URL.Path == "/_cluster_setup":
+			var body struct{ Action, Host string }
+			json.NewDecoder(r.Body).Decode(&body)
+			f.actions = append(f.actions, strings.TrimSpace(body.Action+" "+f.hosts[node]+" "+body.Host))
+			switch {
+			case body.Action == "enable_cluster":
+				f.states[node] = "cluster_enabled"
+			case body.Action == "add_node" && node == 0:
+				f.members = append(f.members, "couchdb@"+body.Host)
+			case body.Action == "finish_cluster" && node == 0:
+				f.states[0] = "cluster_finished"
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"ok":true}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+}
+
+func (f *fakeCluster) setup(t *testing.T) (*clusterSetup, *bytes.Buffer) {
+	var urls []string
+	for _, srv := range f.servers {
+		urls = append(urls, strings.Replace(srv.URL, "http://", "http://admin:secret@", 1))
+	}
+	out := &bytes.Buffer{}
+	s, err := newClusterSetup(urls, f.hosts, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, out
+}
+
+func TestClusterSetup(t *testing.T) {
+	tests := []struct {
+		name    string
+		states  []string
+		members []string
+		actions []string
+	}{{
+		name:    "fresh",
+		states:  []string{"cluster_disabled", "cluster_disabled", "cluster_disabled"},
+		members: []string{"couchdb@couchdb-0.local"},
+		actions: []string{
+			"enable_cluster couchdb-0.local",
+			"enable_cluster couchdb-1.local",
+			"enable_cluster couchdb-2.local",
+			"add_node couchdb-0.local couchdb-1.local",
+			"add_node couchdb-0.local couchdb-2.local",
+			"finish_cluster couchdb-0.local",
+		},
+	}, {
+		name:    "half finished",
+		states:  []string{"cluster_enabled", "cluster_enabled", "cluster_disabled"},
+		members: []string{"couchdb@couchdb-0.local", "couchdb@couchdb-1.local"},
+		actions: []string{
+			"enable_cluster couchdb-2.local",
+			"add_node couchdb-0.local couchdb-2.local",
+			"finish_cluster couchdb-0.local",
+		},
+	}, {
+		name:    "finished",
+		states:  []string{"cluster_finished", "cluster_enabled", "cluster_enabled"},
+		members: []string{"couchdb@couchdb-0.local", "couchdb@couchdb-1.local", "couchdb@couchdb-2.local"},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeCluster(t, tt.states, tt.members...)
+			s, out := f.setup(t)
+			if err := s.run(context.Background()); err != nil {
+				t.Fatalf("run: %v\n%s", err, out)
+			}
+			if !slices.Equal(f.actions, tt.actions) {
+				t.Errorf("actions = %q, want %q", f.actions, tt.actions)
+			}
+			if !strings.Contains(out.String(), "ok, 3 nodes") {
+				t.Errorf("membership not verified:\n%s", out)
+			}
+		})
+	}
+}
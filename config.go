@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// envString returns the value of the environment variable key, or def when it
+// is unset.
+func envString(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
+
+// envInt returns the integer value of the environment variable key, or def
+// when it is unset or not a number.
+func envInt(key string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// envList returns the comma-separated values of the environment variable key,
+// or def when it is unset or empty.
+func envList(key string, def []string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	if len(list) == 0 {
+		return def
+	}
+	return list
+}
+
+// envMap parses the environment variable key as comma-separated name=value
+// pairs.
+func envMap(key string) map[string]string {
+	m := make(map[string]string)
+	for _, pair := range envList(key, nil) {
+		if name, value, ok := strings.Cut(pair, "="); ok {
+			m[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+	return m
+}
+
+// envBool returns the boolean value of the environment variable key, or def
+// when it is unset or not a boolean.
+func envBool(key string, def bool) bool {
+	v, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
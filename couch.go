@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+// couchEndpoint resolves path, which may carry a query string, against
+// couchURL.
+func couchEndpoint(path string) string {
+	return strings.TrimSuffix(couchURL, "/") + "/" + path
+}
+
+// couchDo sends a JSON request to a CouchDB URL and decodes the response into
+// dest, which may be nil. Error responses are returned as *kivik.Error
+// carrying CouchDB's reason, so kivik.HTTPStatus works on them.
+func couchDo(ctx context.Context, hc *http.Client, method, rawURL string, body, dest interface{}) error {
+	var payload io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return couchError(resp)
+	}
+	if dest == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(dest)
+}
+
+// couchError converts an error response from CouchDB into a *kivik.Error.
+func couchError(resp *http.Response) error {
+	var body struct {
+		Error  string `json:"error"`
+		Reason string `json:"reason"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	msg := body.Error
+	if body.Reason != "" {
+		msg += ": " + body.Reason
+	}
+	if msg == "" {
+		msg = resp.Status
+	}
+	return &kivik.Error{Status: resp.StatusCode, Message: msg}
+}
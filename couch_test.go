@@ -0,0 +1,516 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeCouch is an in-memory CouchDB with the parts of the API the service
+// uses: databases, documents with revisions and attachments, _all_docs,
+// _bulk_docs, a normal _changes feed and _find with simple selectors.
+type fakeCouch struct {
+	mu  sync.Mutex
+	dbs map[string]map[string]*fakeDoc
+	seq int
+}
+
+type fakeDoc struct {
+	body    map[string]interface{}
+	revs    []string // newest first
+	deleted bool
+	seq     int
+	atts    map[string]fakeAttachment
+	// old holds the earlier revisions, by rev, without attachments.
+	old map[string]map[string]interface{}
+}
+
+type fakeAttachment struct {
+	contentType string
+	data        []byte
+}
+
+// newFakeCouch starts a fake CouchDB with the given databases and points the
+// client at it.
+func newFakeCouch(t *testing.T, dbs ...string) *fakeCouch {
+	t.Helper()
+	f := &fakeCouch{dbs: map[string]map[string]*fakeDoc{}}
+	for _, db := range dbs {
+		f.dbs[db] = map[string]*fakeDoc{}
+	}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	couchURL = srv.URL
+	if err := connectCouchDB(); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// doc returns the current body of document id of db, nil when it is missing
+// or deleted.
+func (f *fakeCouch) doc(db, id string) map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	d := f.dbs[db][id]
+	if d == nil || d.deleted {
+		return nil
+	}
+	return d.render(false, false)
+}
+
+func (d *fakeDoc) rev() string { return d.revs[0] }
+
+// oldRevision returns the body of an earlier revision rev of d.
+func (d *fakeDoc) oldRevision(rev string) (map[string]interface{}, bool) {
+	if d == nil || rev == "" || rev == d.rev() {
+		return nil, false
+	}
+	body, ok := d.old[rev]
+	return body, ok
+}
+
+func (d *fakeDoc) render(revs, inline bool) map[string]interface{} {
+	out := make(map[string]interface{}, len(d.body)+3)
+	for k, v := range d.body {
+		out[k] = v
+	}
+	out["_rev"] = d.rev()
+	if d.deleted {
+		out["_deleted"] = true
+	}
+	if revs {
+		ids := make([]string, len(d.revs))
+		for i, r := range d.revs {
+			_, ids[i], _ = strings.Cut(r, "-")
+		}
+		out["_revisions"] = map[string]interface{}{"start": len(d.revs), "ids": ids}
+	}
+	if len(d.atts) > 0 {
+		atts := map[string]interface{}{}
+		for name, a := range d.atts {
+			att := map[string]interface{}{"content_type": a.contentType, "length": len(a.data)}
+			if inline {
+				att["data"] = base64.StdEncoding.EncodeToString(a.data)
+			} else {
+				att["stub"] = true
+			}
+			atts[name] = att
+		}
+		out["_attachments"] = atts
+	}
+	return out
+}
+
+func (f *fakeCouch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	reply := func(status int, v interface{}) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	}
+	fail := func(status int, e, reason string) {
+		reply(status, map[string]string{"error": e, "reason": reason})
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/", 3)
+	for i := range parts {
+		parts[i], _ = url.PathUnescape(parts[i])
+	}
+	if parts[0] == "" {
+		reply(http.StatusOK, map[string]string{"couchdb": "Welcome", "version": "3.3.3"})
+		return
+	}
+	if parts[0] == "_all_dbs" {
+		var names []string
+		for name := range f.dbs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		reply(http.StatusOK, names)
+		return
+	}
+	name := parts[0]
+	docs, exists := f.dbs[name]
+
+	if len(parts) == 1 {
+		switch {
+		case r.Method == http.MethodPut && exists:
+			fail(http.StatusPreconditionFailed, "file_exists", "The database could not be created, the file already exists.")
+		case r.Method == http.MethodPut:
+			f.dbs[name] = map[string]*fakeDoc{}
+			reply(http.StatusCreated, map[string]bool{"ok": true})
+		case !exists:
+			fail(http.StatusNotFound, "not_found", "Database does not exist.")
+		default:
+			reply(http.StatusOK, map[string]interface{}{"db_name": name, "update_seq": strconv.Itoa(f.seq)})
+		}
+		return
+	}
+	if !exists {
+		fail(http.StatusNotFound, "not_found", "Database does not exist.")
+		return
+	}
+
+	if parts[1] == "_design" && len(parts) == 3 && !strings.Contains(parts[2], "/") {
+		parts = []string{name, "_design/" + parts[2]}
+	}
+	var body map[string]interface{}
+	if r.Body != nil && (r.Method == http.MethodPost || r.Method == http.MethodPut) && len(parts) == 2 {
+		json.NewDecoder(requestBody(r)).Decode(&body)
+	}
+	q := r.URL.Query()
+
+	switch id := parts[1]; {
+	case id == "_security" || id == "_index":
+		reply(http.StatusOK, map[string]interface{}{"ok": true, "result": "created"})
+	case id == "_all_docs":
+		f.allDocs(docs, q, body, reply)
+	case id == "_bulk_docs":
+		var results []map[string]interface{}
+		newEdits, _ := body["new_edits"].(bool)
+		if _, set := body["new_edits"]; !set {
+			newEdits = true
+		}
+		list, _ := body["docs"].([]interface{})
+		for _, v := range list {
+			doc, _ := v.(map[string]interface{})
+			docID, _ := doc["_id"].(string)
+			if docID == "" {
+				// CouchDB generates the IDs of new documents without one.
+				docID = fmt.Sprintf("generated-%d", f.seq+1)
+			}
+			if !newEdits {
+				f.store(docs, docID, doc, true)
+				continue
+			}
+			rev, err := f.write(docs, docID, doc)
+			if err != "" {
+				results = append(results, map[string]interface{}{"id": docID, "error": err, "reason": "Document update conflict."})
+				continue
+			}
+			results = append(results, map[string]interface{}{"ok": true, "id": docID, "rev": rev})
+		}
+		if results == nil {
+			results = []map[string]interface{}{}
+		}
+		reply(http.StatusCreated, results)
+	case id == "_changes":
+		f.changes(docs, q, reply)
+	case id == "_find":
+		f.find(docs, body, reply)
+	default:
+		docID := parts[1]
+		d := docs[docID]
+		if len(parts) == 3 {
+			f.attachment(w, r, docs, docID, parts[2], reply, fail)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			if d != nil && q.Get("open_revs") == "all" {
+				reply(http.StatusOK, []interface{}{map[string]interface{}{"ok": d.render(q.Get("revs") == "true", false)}})
+				return
+			}
+			if old, ok := d.oldRevision(q.Get("rev")); ok {
+				reply(http.StatusOK, old)
+				return
+			}
+			if d == nil || d.deleted || (q.Get("rev") != "" && q.Get("rev") != d.rev()) {
+				fail(http.StatusNotFound, "not_found", "missing")
+				return
+			}
+			w.Header().Set("ETag", `"`+d.rev()+`"`)
+			reply(http.StatusOK, d.render(q.Get("revs") == "true", q.Get("attachments") == "true"))
+		case http.MethodPut:
+			if body == nil {
+				fail(http.StatusBadRequest, "bad_request", "invalid UTF-8 JSON")
+				return
+			}
+			if rev := q.Get("rev"); rev != "" {
+				body["_rev"] = rev
+			}
+			rev, err := f.write(docs, docID, body)
+			if err != "" {
+				fail(http.StatusConflict, err, "Document update conflict.")
+				return
+			}
+			reply(http.StatusCreated, map[string]interface{}{"ok": true, "id": docID, "rev": rev})
+		case http.MethodDelete:
+			rev, err := f.write(docs, docID, map[string]interface{}{"_rev": q.Get("rev"), "_deleted": true})
+			if err != "" {
+				fail(http.StatusConflict, err, "Document update conflict.")
+				return
+			}
+			reply(http.StatusOK, map[string]interface{}{"ok": true, "id": docID, "rev": rev})
+		default:
+			fail(http.StatusMethodNotAllowed, "method_not_allowed", r.Method)
+		}
+	}
+}
+
+// requestBody returns the body of r, which kivik gzips.
+func requestBody(r *http.Request) io.Reader {
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		if zr, err := gzip.NewReader(r.Body); err == nil {
+			return zr
+		}
+	}
+	return r.Body
+}
+
+// write stores doc as the next revision of docID, checking its _rev like
+// CouchDB. It returns the new revision or the error name.
+func (f *fakeCouch) write(docs map[string]*fakeDoc, docID string, doc map[string]interface{}) (string, string) {
+	rev, _ := doc["_rev"].(string)
+	d := docs[docID]
+	switch {
+	case d == nil && rev != "":
+		return "", "conflict"
+	case d != nil && !d.deleted && rev != d.rev():
+		return "", "conflict"
+	case d != nil && d.deleted && rev != "" && rev != d.rev():
+		return "", "conflict"
+	}
+	return f.store(docs, docID, doc, false), ""
+}
+
+// store saves doc as a revision of docID: the given one with keep, else the
+// next one.
+func (f *fakeCouch) store(docs map[string]*fakeDoc, docID string, doc map[string]interface{}, keep bool) string {
+	f.seq++
+	d := docs[docID]
+	if d == nil {
+		d = &fakeDoc{}
+		docs[docID] = d
+	}
+	if len(d.revs) > 0 {
+		if d.old == nil {
+			d.old = map[string]map[string]interface{}{}
+		}
+		d.old[d.rev()] = d.render(false, false)
+	}
+	rev, _ := doc["_rev"].(string)
+	if !keep {
+		rev = strconv.Itoa(len(d.revs)+1) + "-" + fmt.Sprintf("%032x", f.seq)
+	}
+	d.revs = append([]string{rev}, d.revs...)
+	d.seq = f.seq
+	d.deleted, _ = doc["_deleted"].(bool)
+	d.body = map[string]interface{}{"_id": docID}
+	atts := d.atts
+	d.atts = nil
+	for k, v := range doc {
+		switch k {
+		case "_rev", "_deleted", "_revisions":
+		case "_attachments":
+			d.atts = map[string]fakeAttachment{}
+			for name, a := range v.(map[string]interface{}) {
+				a := a.(map[string]interface{})
+				ct, _ := a["content_type"].(string)
+				if data, ok := a["data"].(string); ok {
+					b, _ := base64.StdEncoding.DecodeString(data)
+					d.atts[name] = fakeAttachment{ct, b}
+				} else if old, ok := atts[name]; ok {
+					d.atts[name] = old
+				}
+			}
+		default:
+			d.body[k] = v
+		}
+	}
+	return rev
+}
+
+func (f *fakeCouch) attachment(w http.ResponseWriter, r *http.Request, docs map[string]*fakeDoc, docID, name string, reply func(int, interface{}), fail func(int, string, string)) {
+	d := docs[docID]
+	switch r.Method {
+	case http.MethodGet:
+		if d == nil || d.deleted {
+			fail(http.StatusNotFound, "not_found", "missing")
+			return
+		}
+		a, ok := d.atts[name]
+		if !ok {
+			fail(http.StatusNotFound, "not_found", "Document is missing attachment")
+			return
+		}
+		w.Header().Set("Content-Type", a.contentType)
+		w.WriteHeader(http.StatusOK)
+		w.Write(a.data)
+	case http.MethodPut:
+		if d == nil || d.deleted || r.URL.Query().Get("rev") != d.rev() {
+			fail(http.StatusConflict, "conflict", "Document update conflict.")
+			return
+		}
+		data, _ := io.ReadAll(requestBody(r))
+		doc := d.render(false, true)
+		doc["_attachments"].(map[string]interface{})[name] = map[string]interface{}{
+			"content_type": r.Header.Get("Content-Type"),
+			"data":         base64.StdEncoding.EncodeToString(data),
+		}
+		rev := f.store(docs, docID, doc, false)
+		reply(http.StatusCreated, map[string]interface{}{"ok": true, "id": docID, "rev": rev})
+	default:
+		fail(http.StatusMethodNotAllowed, "method_not_allowed", r.Method)
+	}
+}
+
+func (f *fakeCouch) allDocs(docs map[string]*fakeDoc, q url.Values, body map[string]interface{}, reply func(int, interface{})) {
+	rows := []map[string]interface{}{}
+	if keys, ok := body["keys"].([]interface{}); ok {
+		for _, k := range keys {
+			key, _ := k.(string)
+			d := docs[key]
+			if d == nil {
+				rows = append(rows, map[string]interface{}{"key": key, "error": "not_found"})
+				continue
+			}
+			rows = append(rows, map[string]interface{}{"id": key, "key": key, "value": map[string]interface{}{"rev": d.rev(), "deleted": d.deleted}})
+		}
+		reply(http.StatusOK, map[string]interface{}{"total_rows": len(docs), "rows": rows})
+		return
+	}
+	ids := make([]string, 0, len(docs))
+	for id, d := range docs {
+		if !d.deleted {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		row := map[string]interface{}{"id": id, "key": id, "value": map[string]string{"rev": docs[id].rev()}}
+		if q.Get("include_docs") == "true" {
+			row["doc"] = docs[id].render(false, false)
+		}
+		rows = append(rows, row)
+	}
+	reply(http.StatusOK, map[string]interface{}{"total_rows": len(rows), "offset": 0, "rows": rows})
+}
+
+func (f *fakeCouch) changes(docs map[string]*fakeDoc, q url.Values, reply func(int, interface{})) {
+	since, _ := strconv.Atoi(q.Get("since"))
+	var list []*fakeDoc
+	ids := map[*fakeDoc]string{}
+	for id, d := range docs {
+		if d.seq > since {
+			list = append(list, d)
+			ids[d] = id
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].seq < list[j].seq })
+	results := []map[string]interface{}{}
+	last := since
+	for _, d := range list {
+		change := map[string]interface{}{
+			"seq":     strconv.Itoa(d.seq),
+			"id":      ids[d],
+			"changes": []map[string]string{{"rev": d.rev()}},
+		}
+		if d.deleted {
+			change["deleted"] = true
+		}
+		if q.Get("include_docs") == "true" {
+			change["doc"] = d.render(false, false)
+		}
+		results = append(results, change)
+		last = d.seq
+	}
+	reply(http.StatusOK, map[string]interface{}{"results": results, "last_seq": strconv.Itoa(last)})
+}
+
+func (f *fakeCouch) find(docs map[string]*fakeDoc, body map[string]interface{}, reply func(int, interface{})) {
+	selector, _ := body["selector"].(map[string]interface{})
+	var found []map[string]interface{}
+	for _, d := range docs {
+		if d.deleted || strings.HasPrefix(d.body["_id"].(string), "_design/") {
+			continue
+		}
+		doc := d.render(false, false)
+		if matchesSelector(doc, selector) {
+			found = append(found, doc)
+		}
+	}
+	var fields []string
+	if sortBy, ok := body["sort"].([]interface{}); ok {
+		for _, s := range sortBy {
+			for field := range s.(map[string]interface{}) {
+				fields = append(fields, field)
+			}
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		for _, field := range fields {
+			a, b := fmt.Sprint(found[i][field]), fmt.Sprint(found[j][field])
+			if a != b {
+				return a > b
+			}
+		}
+		return false
+	})
+	if limit, ok := body["limit"].(float64); ok && int(limit) < len(found) {
+		found = found[:int(limit)]
+	}
+	if found == nil {
+		found = []map[string]interface{}{}
+	}
+	reply(http.StatusOK, map[string]interface{}{"docs": found, "bookmark": "nil"})
+}
+
+// matchesSelector evaluates the equality, $exists and comparison operators
+// of a Mango selector on the top-level fields of doc.
+func matchesSelector(doc, selector map[string]interface{}) bool {
+	for field, cond := range selector {
+		v, present := doc[field]
+		ops, ok := cond.(map[string]interface{})
+		if !ok {
+			if !present || fmt.Sprint(v) != fmt.Sprint(cond) {
+				return false
+			}
+			continue
+		}
+		for op, arg := range ops {
+			s, a := fmt.Sprint(v), fmt.Sprint(arg)
+			var ok bool
+			switch {
+			case arg == nil && (op == "$gt" || op == "$gte"):
+				// null sorts before every other value.
+				ok = present && (v != nil || op == "$gte")
+				op = ""
+			}
+			switch op {
+			case "":
+			case "$exists":
+				ok = present == arg.(bool)
+			case "$eq":
+				ok = present && s == a
+			case "$gt":
+				ok = present && s > a
+			case "$gte":
+				ok = present && s >= a
+			case "$lt":
+				ok = present && s < a
+			case "$lte":
+				ok = present && s <= a
+			case "$in":
+				ok = present && slices.ContainsFunc(arg.([]interface{}), func(x interface{}) bool { return fmt.Sprint(x) == s })
+			}
+			if !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -15,367 +15,3902 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/changes": {
+        "/admin/export": {
             "get": {
-                "description": "Retrieves changes from CouchDB using a specified filter",
-                "consumes": [
-                    "application/json"
+                "security": [
+                    {
+                        "BasicAuth": []
+                    }
                 ],
+                "description": "Streams every document of the tenant's student database as one JSON document per line. The last line is\n{\"_export\":{\"last_seq\":\"...\",\"docs\":N}}; pass last_seq as since to export incrementally. Deleted documents\nare exported as tombstones. Documents carry their revision history in _revisions, which an import with the\nkeep-revs policy preserves. The file is named after the tenant's database.",
                 "produces": [
-                    "application/json"
+                    "application/x-ndjson"
                 ],
                 "tags": [
-                    "changes"
+                    "admin"
                 ],
-                "summary": "Get changes from CouchDB with a filter",
+                "summary": "Export documents as NDJSON",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Address",
-                        "name": "address",
+                        "description": "Tenant whose database is used; the default database without it",
+                        "name": "tenant",
                         "in": "query"
                     },
                     {
-                        "type": "integer",
-                        "description": "Age",
-                        "name": "age",
+                        "type": "string",
+                        "default": "0",
+                        "description": "Only export changes after this seq",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "default": "none",
+                        "description": "none (stubs only) or inline (base64 data)",
+                        "name": "attachments",
                         "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "OK",
+                        "description": "NDJSON stream",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": true
+                            "type": "string"
                         }
                     },
-                    "500": {
-                        "description": "Failed to retrieve changes.",
+                    "400": {
+                        "description": "Invalid request",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin routes are disabled",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
                         }
                     }
                 }
             }
         },
-        "/document/{docID}": {
-            "put": {
-                "description": "Updates an existing document in the CouchDB database",
-                "consumes": [
-                    "application/json"
+        "/admin/export/attachments": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    }
                 ],
+                "description": "Streams the attachments of every document changed since the given seq as a tar archive of\n\u003cdoc ID\u003e/\u003cfile name\u003e entries, both path-escaped. The archive is named after the tenant's database.",
                 "produces": [
-                    "application/json"
+                    "application/x-tar"
                 ],
                 "tags": [
-                    "document"
+                    "admin"
                 ],
-                "summary": "Update an existing document",
+                "summary": "Export attachments as tar",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Document ID",
-                        "name": "docID",
-                        "in": "path",
-                        "required": true
+                        "description": "Tenant whose database is used; the default database without it",
+                        "name": "tenant",
+                        "in": "query"
                     },
                     {
-                        "description": "Document Data",
-                        "name": "document",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "type": "object",
-                            "additionalProperties": true
-                        }
+                        "type": "string",
+                        "default": "0",
+                        "description": "Only export changes after this seq",
+                        "name": "since",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Document updated successfully",
-                        "schema": {
-                            "$ref": "#/definitions/main.Response"
-                        }
-                    },
-                    "400": {
-                        "description": "Invalid request",
+                        "description": "tar archive",
                         "schema": {
-                            "$ref": "#/definitions/main.Response"
+                            "type": "file"
                         }
                     },
-                    "404": {
-                        "description": "Document not found",
+                    "401": {
+                        "description": "Unauthorized",
                         "schema": {
-                            "$ref": "#/definitions/main.Response"
+                            "$ref": "#/definitions/main.Problem"
                         }
                     },
-                    "500": {
-                        "description": "Failed to update document",
+                    "403": {
+                        "description": "Admin routes are disabled",
                         "schema": {
-                            "$ref": "#/definitions/main.Response"
+                            "$ref": "#/definitions/main.Problem"
                         }
                     }
                 }
-            },
-            "delete": {
-                "description": "Deletes a document from the CouchDB database",
+            }
+        },
+        "/admin/import": {
+            "post": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    }
+                ],
+                "description": "Writes the documents of an NDJSON export into the tenant's student database in _bulk_docs batches. The body is\neither NDJSON or a multipart form with an NDJSON \"file\" and an optional \"attachments\" tar. Progress is\nstreamed back as one ImportProgress line per batch; after an interruption, pass the last reported batch\nas resume_after. Documents that do not match their schema or reuse a value of a unique field are not\nwritten; they are listed under rejected with the line of the input. Every document written is recorded\nin the audit log as an import.",
+                "consumes": [
+                    "application/x-ndjson",
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/x-ndjson"
+                ],
                 "tags": [
-                    "document"
+                    "admin"
                 ],
-                "summary": "Delete a document",
+                "summary": "Import documents from NDJSON",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Document ID",
-                        "name": "docID",
-                        "in": "path",
-                        "required": true
+                        "description": "Tenant whose database is used; the default database without it",
+                        "name": "tenant",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "default": "skip",
+                        "description": "Conflict policy: skip, overwrite or keep-revs",
+                        "name": "policy",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 500,
+                        "description": "Documents per batch",
+                        "name": "batch_size",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 0,
+                        "description": "Skip batches up to and including this one",
+                        "name": "resume_after",
+                        "in": "query"
+                    },
+                    {
+                        "type": "file",
+                        "description": "NDJSON export",
+                        "name": "file",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "file",
+                        "description": "Attachment tar",
+                        "name": "attachments",
+                        "in": "formData"
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Document deleted successfully",
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.DeleteResponse"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.ImportProgress"
+                            }
                         }
                     },
                     "400": {
                         "description": "Invalid request",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/main.Problem"
                         }
                     },
-                    "404": {
-                        "description": "Document not found",
+                    "401": {
+                        "description": "Unauthorized",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/main.Problem"
                         }
                     },
-                    "500": {
-                        "description": "Failed to delete document",
+                    "403": {
+                        "description": "Admin routes are disabled",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/main.Problem"
                         }
                     }
                 }
             }
         },
-        "/document/{id}": {
+        "/admin/membership": {
             "get": {
-                "description": "Retrieves a specific document from the CouchDB student database by its ID",
+                "security": [
+                    {
+                        "BasicAuth": []
+                    }
+                ],
+                "description": "Lists the nodes of the cluster and the nodes currently connected",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "document"
-                ],
-                "summary": "Get a document by ID",
-                "parameters": [
-                    {
-                        "type": "string",
-                        "description": "Document ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    }
+                    "admin"
                 ],
+                "summary": "Cluster membership",
                 "responses": {
                     "200": {
-                        "description": "Document retrieved successfully.",
+                        "description": "OK",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": true
+                            "$ref": "#/definitions/main.MembershipResponse"
                         }
                     },
-                    "404": {
-                        "description": "Document not found.",
+                    "401": {
+                        "description": "Unauthorized",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin routes are disabled",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
                         }
                     },
                     "500": {
-                        "description": "Failed to retrieve document.",
+                        "description": "Failed to retrieve membership",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/main.Problem"
                         }
                     }
                 }
             }
         },
-        "/documents": {
+        "/admin/nodes": {
             "get": {
-                "description": "Retrieves all documents from the CouchDB student database",
+                "security": [
+                    {
+                        "BasicAuth": []
+                    }
+                ],
+                "description": "Returns _node/{node}/_system stats for every cluster node. Nodes that cannot be reached carry an error.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "document"
+                    "admin"
                 ],
-                "summary": "Get all documents",
+                "summary": "System stats of every node",
                 "responses": {
                     "200": {
-                        "description": "Documents retrieved successfully.",
+                        "description": "OK",
                         "schema": {
                             "type": "array",
                             "items": {
-                                "type": "object",
-                                "additionalProperties": true
+                                "$ref": "#/definitions/main.NodeSystemResponse"
                             }
                         }
                     },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin routes are disabled",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
                     "500": {
-                        "description": "Failed to retrieve documents.",
+                        "description": "Failed to retrieve membership",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/main.Problem"
                         }
                     }
                 }
             }
         },
-        "/file/{docID}/{filename}": {
+        "/admin/nodes/{node}": {
             "get": {
-                "description": "Retrieves an attachment from a CouchDB document",
+                "security": [
+                    {
+                        "BasicAuth": []
+                    }
+                ],
+                "description": "Returns _node/{node}/_system stats for a single node, e.g. couchdb@couchdb-0.local or _local",
                 "produces": [
-                    "application/octet-stream"
+                    "application/json"
                 ],
                 "tags": [
-                    "file"
+                    "admin"
                 ],
-                "summary": "Get a file",
+                "summary": "System stats of one node",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Document ID",
-                        "name": "docID",
-                        "in": "path",
-                        "required": true
-                    },
-                    {
-                        "type": "string",
-                        "description": "Filename",
-                        "name": "filename",
+                        "description": "Node name",
+                        "name": "node",
                         "in": "path",
                         "required": true
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "File downloaded successfully",
+                        "description": "OK",
                         "schema": {
-                            "type": "file"
+                            "$ref": "#/definitions/main.NodeSystemResponse"
                         }
                     },
-                    "400": {
-                        "description": "Invalid request",
+                    "401": {
+                        "description": "Unauthorized",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin routes are disabled",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
                         }
                     },
                     "404": {
-                        "description": "File not found",
+                        "description": "Node not found",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/main.Problem"
                         }
                     },
                     "500": {
-                        "description": "Failed to retrieve file",
+                        "description": "Failed to retrieve node stats",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/main.Problem"
                         }
                     }
                 }
             }
         },
-        "/insert": {
-            "post": {
-                "description": "Inserts a new document into the CouchDB",
-                "consumes": [
-                    "application/json"
+        "/admin/replications": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    }
                 ],
+                "description": "Lists the jobs of the _replicator database with their scheduler state",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "document"
-                ],
-                "summary": "Insert a document",
-                "parameters": [
-                    {
-                        "description": "Document",
-                        "name": "document",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "type": "object",
-                            "additionalProperties": true
-                        }
-                    }
+                    "replication"
                 ],
+                "summary": "List replication jobs",
                 "responses": {
                     "200": {
-                        "description": "Document inserted successfully.",
+                        "description": "OK",
                         "schema": {
-                            "type": "string"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.ReplicationJob"
+                            }
                         }
                     },
-                    "400": {
-                        "description": "Failed to decode JSON.",
+                    "401": {
+                        "description": "Unauthorized",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin routes are disabled",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
                         }
                     },
                     "500": {
-                        "description": "Failed to insert document.",
+                        "description": "Failed to list jobs",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/main.Problem"
                         }
                     }
                 }
-            }
-        },
-        "/upload": {
+            },
             "post": {
-                "description": "Upload a file to CouchDB as an attachment",
+                "security": [
+                    {
+                        "BasicAuth": []
+                    }
+                ],
+                "description": "Creates a continuous or one-shot replication of the student database of the tenant named by the tenant\nparameter to a configured target. Credentials come from REPLICATION_TARGETS; requests only name the target.",
                 "consumes": [
-                    "multipart/form-data"
+                    "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Uploads a file",
+                "tags": [
+                    "replication"
+                ],
+                "summary": "Create a replication job",
                 "parameters": [
                     {
-                        "type": "file",
-                        "description": "File to upload",
-                        "name": "file",
-                        "in": "formData",
+                        "type": "string",
+                        "description": "Tenant whose database is replicated; the default database without it",
+                        "name": "tenant",
+                        "in": "query"
+                    },
+                    {
+                        "description": "Replication job",
+                        "name": "job",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.ReplicationRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.ReplicationJob"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin routes are disabled",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Job already exists",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to create job",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/replications/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    }
+                ],
+                "description": "Returns one replication job with its scheduler state",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "replication"
+                ],
+                "summary": "Get a replication job",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Job ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ReplicationJob"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin routes are disabled",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Job not found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to retrieve job",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    }
+                ],
+                "description": "Cancels a replication job by deleting its _replicator document",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "replication"
+                ],
+                "summary": "Cancel a replication job",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Job ID",
+                        "name": "id",
+                        "in": "path",
                         "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Replication job cancelled",
+                        "schema": {
+                            "$ref": "#/definitions/main.Response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin routes are disabled",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Job not found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
                     },
+                    "500": {
+                        "description": "Failed to cancel job",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/replications/{id}/restart": {
+            "post": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    }
+                ],
+                "description": "Restarts a replication job by rewriting its _replicator document, which also clears a failed state",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "replication"
+                ],
+                "summary": "Restart a replication job",
+                "parameters": [
                     {
                         "type": "string",
-                        "description": "Document ID",
-                        "name": "docID",
-                        "in": "formData",
+                        "description": "Job ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ReplicationJob"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin routes are disabled",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Job not found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to restart job",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/schemas": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    }
+                ],
+                "description": "Lists the latest schema version of every document type",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "schemas"
+                ],
+                "summary": "List schemas",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.SchemaVersion"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin routes are disabled",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to list schemas",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/schemas/{type}": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    }
+                ],
+                "description": "Returns the latest schema version of a document type, which documents of the type are validated against",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "schemas"
+                ],
+                "summary": "Get a schema",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Document type",
+                        "name": "type",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SchemaVersion"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid document type",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin routes are disabled",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "No schema for the type",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to retrieve schema",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    }
+                ],
+                "description": "Stores a JSON Schema as the next version of the schema of a document type. Documents whose type field,\nstudent when missing, names the type are validated against the latest version on insert, update, patch,\nroster import and bulk import. Schemas may not reference other documents. Versions are immutable. The\nregistry is shared by every tenant.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "schemas"
+                ],
+                "summary": "Store a schema",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Document type",
+                        "name": "type",
+                        "in": "path",
                         "required": true
+                    },
+                    {
+                        "description": "JSON Schema",
+                        "name": "schema",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.SchemaVersion"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid document type or schema",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin routes are disabled",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Another version was stored concurrently",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to store schema",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/schemas/{type}/versions": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    }
+                ],
+                "description": "Lists every version of the schema of a document type, oldest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "schemas"
+                ],
+                "summary": "List schema versions",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Document type",
+                        "name": "type",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.SchemaVersion"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid document type",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin routes are disabled",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "No schema for the type",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to retrieve schema",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/schemas/{type}/versions/{version}": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    }
+                ],
+                "description": "Returns one version of the schema of a document type",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "schemas"
+                ],
+                "summary": "Get a schema version",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Document type",
+                        "name": "type",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Version",
+                        "name": "version",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SchemaVersion"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid document type",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin routes are disabled",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Version not found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to retrieve schema",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/shards": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    }
+                ],
+                "description": "Returns the shard map of a database from the _dbs database, by range and by node",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Shard map",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "default": "student",
+                        "description": "Database",
+                        "name": "db",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ShardMapResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin routes are disabled",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Database not found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to retrieve shard map",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/tasks": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    }
+                ],
+                "description": "Lists the cluster's active tasks (indexing, compaction, replication) with progress in percent",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Active tasks",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only tasks of this type, e.g. replication or indexer",
+                        "name": "type",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.ActiveTask"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin routes are disabled",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to retrieve active tasks",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/webhooks": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    }
+                ],
+                "description": "Lists the webhook subscriptions, without their secrets",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "List webhooks",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Webhook"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin routes are disabled",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to list webhooks",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    }
+                ],
+                "description": "Subscribes a URL to changes of the student database of the tenant named by the tenant parameter. Every\nchange of a document matching the selector is POSTed as a WebhookPayload; deletions and moves to the\ntrash, flagged as deleted, match when the last revision before them did. The X-Webhook-Signature header is\nsha256= followed by the hex HMAC-SHA256, keyed with the secret, of the X-Webhook-Timestamp header, a\ndot and the body. Failed deliveries are retried with exponential backoff and then dead-lettered.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Subscribe a webhook",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant whose database is used; the default database without it",
+                        "name": "tenant",
+                        "in": "query"
+                    },
+                    {
+                        "description": "Subscription",
+                        "name": "subscription",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.WebhookRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.Webhook"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin routes are disabled",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to create subscription",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/webhooks/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    }
+                ],
+                "description": "Returns one webhook subscription, without its secret",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Get a webhook",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Subscription ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Webhook"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin routes are disabled",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Webhook not found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to retrieve webhook",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    }
+                ],
+                "description": "Removes a webhook subscription. The worker stops delivering to it within 30 seconds.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Delete a webhook",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Subscription ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin routes are disabled",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Webhook not found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to delete webhook",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/webhooks/{id}/dead-letters": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    }
+                ],
+                "description": "Lists the deliveries to a webhook that failed every attempt",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "List dead-lettered deliveries",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Subscription ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "default": 100,
+                        "description": "Maximum number of deliveries",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.DeadLetter"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid limit",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin routes are disabled",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to list dead letters",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/audit": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    }
+                ],
+                "description": "Lists audit records of document changes, newest first. Each record holds the actor, route, document\nID, old and new revision, the changed fields and the request ID. Filters combine; since and until are\nRFC 3339 times. Only records of the tenant named by the tenant parameter are listed.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Query the audit log",
+                "deprecated": true,
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant whose records are listed; those of the default database without it",
+                        "name": "tenant",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Document ID",
+                        "name": "doc_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Actor, e.g. user:admin, key:… or ip:…",
+                        "name": "actor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only records at or after this time",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only records before this time",
+                        "name": "until",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 100,
+                        "description": "Maximum number of records",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Bookmark of the previous page",
+                        "name": "bookmark",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.AuditResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid filter",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin routes are disabled",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit or daily quota exceeded.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to query audit log",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/changes": {
+            "get": {
+                "description": "Retrieves changes from CouchDB using a specified filter",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "changes"
+                ],
+                "summary": "Get changes from CouchDB with a filter",
+                "deprecated": true,
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Address",
+                        "name": "address",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Age",
+                        "name": "age",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit or daily quota exceeded.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to retrieve changes.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/document/{docID}": {
+            "put": {
+                "description": "Updates an existing document in the CouchDB database",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "document"
+                ],
+                "summary": "Update an existing document",
+                "deprecated": true,
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Document ID",
+                        "name": "docID",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Document Data",
+                        "name": "document",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Document updated successfully",
+                        "schema": {
+                            "$ref": "#/definitions/main.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Document not found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "A unique value is already used",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "Document does not match its schema",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit or daily quota exceeded.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to update document",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Deletes a document from CouchDB. With SOFT_DELETE turned on, the document is moved to the trash\ninstead, from where it can be restored until it is purged; permanent then deletes it right away.",
+                "tags": [
+                    "document"
+                ],
+                "summary": "Delete a document",
+                "deprecated": true,
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Document ID",
+                        "name": "docID",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "default": false,
+                        "description": "Delete without going through the trash",
+                        "name": "permanent",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Document deleted successfully",
+                        "schema": {
+                            "$ref": "#/definitions/main.DeleteResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Document not found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit or daily quota exceeded.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to delete document",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "description": "Applies a JSON Patch (RFC 6902, Content-Type application/json-patch+json, ops test, add, remove, replace,\nmove and copy) or a JSON Merge Patch (RFC 7396, Content-Type application/merge-patch+json, where null\nremoves a field) to a document. The patch applies as a whole or not at all. With If-Match or rev, it\nonly applies to that revision; otherwise it is reapplied to the latest revision when a concurrent\nupdate conflicts. Members starting with an underscore and deleted_at cannot be patched.",
+                "consumes": [
+                    "application/json-patch+json",
+                    "application/merge-patch+json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "document"
+                ],
+                "summary": "Patch a document",
+                "deprecated": true,
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Document ID",
+                        "name": "docID",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Revision the patch applies to",
+                        "name": "If-Match",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Revision the patch applies to",
+                        "name": "rev",
+                        "in": "query"
+                    },
+                    {
+                        "description": "JSON Patch operations, or a merge patch object",
+                        "name": "patch",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.PatchOperation"
+                            }
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Document patched successfully",
+                        "schema": {
+                            "$ref": "#/definitions/main.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid patch",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Document not found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Test operation failed, document updated concurrently, or a unique value is already used",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "412": {
+                        "description": "Revision does not match",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported patch format",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "Patch does not apply, changes a protected member, or the result does not match its schema",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit or daily quota exceeded.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to patch document",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/document/{id}": {
+            "get": {
+                "description": "Retrieves a specific document from the CouchDB student database by its ID. Documents are served from\nan in-process cache while it is kept current by the changes feed; X-Cache tells whether it was a HIT or\na MISS.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "document"
+                ],
+                "summary": "Get a document by ID",
+                "deprecated": true,
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Document ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Document retrieved successfully.",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Document not found.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit or daily quota exceeded.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to retrieve document.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/documents": {
+            "get": {
+                "description": "Retrieves all documents from the CouchDB student database. By default the response is CouchDB's\n_all_docs result. The format query parameter or the Accept header select one flattened record per\ndocument instead: ndjson (application/x-ndjson), csv (text/csv), xlsx or flat (a JSON array, only\nwith format). Nested fields are flattened to dotted names such as address.city or tags.0, and\nCouchDB fields other than _id are left out unless listed in columns. The output is streamed.",
+                "produces": [
+                    "application/json",
+                    "application/x-ndjson",
+                    "text/csv",
+                    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+                ],
+                "tags": [
+                    "document"
+                ],
+                "summary": "Get all documents",
+                "deprecated": true,
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "json, flat, ndjson, csv or xlsx; overrides Accept",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated flattened fields to output, e.g. _id,name,address.city",
+                        "name": "columns",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Documents retrieved successfully.",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "type": "object",
+                                "additionalProperties": true
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Unknown format.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "406": {
+                        "description": "None of the accepted media types is supported.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit or daily quota exceeded.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to retrieve documents.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/file/{docID}/{filename}": {
+            "get": {
+                "description": "Retrieves an attachment from a CouchDB document",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "file"
+                ],
+                "summary": "Get a file",
+                "deprecated": true,
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Document ID",
+                        "name": "docID",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Filename",
+                        "name": "filename",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "File downloaded successfully",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "File not found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit or daily quota exceeded.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to retrieve file",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/healthz": {
+            "get": {
+                "description": "Reports that the process is running. It does not contact CouchDB.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "Liveness probe",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.HealthResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/import/csv": {
+            "post": {
+                "description": "Upserts one student per CSV row, matching existing documents by the key field. The first row holds the\nheaders. Keys match whatever their case and surrounding space, and matching students in the trash are\nrestored. mapping is a JSON object from header to field, optionally typed as \"field:type\" with type string,\nint, float or bool; age is an int unless mapped otherwise. Without a mapping, headers are used as field\nnames. Every row is validated, including against the schema of its document type, and reported; with\ndry_run nothing is written.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "import"
+                ],
+                "summary": "Import a CSV roster",
+                "deprecated": true,
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "CSV file",
+                        "name": "file",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Column mapping, e.g. {\\",
+                        "name": "mapping",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "default": "email",
+                        "description": "Natural key field",
+                        "name": "key",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "boolean",
+                        "default": false,
+                        "description": "Validate and preview only",
+                        "name": "dry_run",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.RosterImportResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid file or mapping",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit or daily quota exceeded.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to import roster",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/import/xlsx": {
+            "post": {
+                "description": "Same as /import/csv for an XLSX workbook. The first sheet is read unless sheet is given.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "import"
+                ],
+                "summary": "Import an Excel roster",
+                "deprecated": true,
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "XLSX file",
+                        "name": "file",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Sheet name",
+                        "name": "sheet",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Column mapping, e.g. {\\",
+                        "name": "mapping",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "default": "email",
+                        "description": "Natural key field",
+                        "name": "key",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "boolean",
+                        "default": false,
+                        "description": "Validate and preview only",
+                        "name": "dry_run",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.RosterImportResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid file or mapping",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit or daily quota exceeded.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to import roster",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/insert": {
+            "post": {
+                "description": "Inserts a new document into the CouchDB",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "document"
+                ],
+                "summary": "Insert a document",
+                "deprecated": true,
+                "parameters": [
+                    {
+                        "description": "Document",
+                        "name": "document",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Document inserted successfully.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Failed to decode JSON.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "A unique value is already used.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "Document does not match its schema.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit or daily quota exceeded.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to insert document.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/metrics": {
+            "get": {
+                "description": "Exposes metrics in the Prometheus text format. Metric names are stable:\nstudent_api_http_requests_total{method,route,status},\nstudent_api_http_request_duration_seconds{method,route,status},\nstudent_api_couchdb_request_duration_seconds{operation},\nstudent_api_couchdb_request_errors_total{operation,status},\nstudent_api_attachment_bytes_total{direction},\nstudent_api_couchdb_node_up{node},\nstudent_api_webhook_deliveries_total{result},\nstudent_api_cache_lookups_total{result},\nstudent_api_cache_entries,\nstudent_api_schema_validations_total{type,result},\nstudent_api_idempotency_requests_total{outcome},\nstudent_api_legacy_requests_total{route},\nstudent_api_grpc_requests_total{method,code},\nstudent_api_grpc_request_duration_seconds{method,code}.\nCouchDB operations are Get, Put, Delete, PutAttachment, GetAttachment, AllDocs, BulkDocs, Find, Purge and changes.",
+                "produces": [
+                    "text/plain"
+                ],
+                "tags": [
+                    "metrics"
+                ],
+                "summary": "Prometheus metrics",
+                "responses": {
+                    "200": {
+                        "description": "Metrics in the Prometheus text format",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/readyz": {
+            "get": {
+                "description": "Checks that every configured CouchDB node is up, that the cluster membership is consistent,\nand that the student database and its required design documents exist. Each check reports its own status.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "Readiness probe",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.HealthResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.HealthResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/trash": {
+            "get": {
+                "description": "Lists soft-deleted documents, most recently deleted first. Documents are purged once they have been in\nthe trash for the retention period.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "trash"
+                ],
+                "summary": "List the trash",
+                "deprecated": true,
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "default": 100,
+                        "description": "Maximum number of documents",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Bookmark of the previous page",
+                        "name": "bookmark",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TrashResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid limit",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit or daily quota exceeded.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to list trash",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/trash/{id}/restore": {
+            "post": {
+                "description": "Takes a document out of the trash. A document that was deleted permanently is recreated from its last\nrevision, including attachments, as long as compaction has not removed it. Like an update, the document\nmust match its schema and keep its unique values.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "trash"
+                ],
+                "summary": "Restore a deleted document",
+                "deprecated": true,
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Document ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Document restored",
+                        "schema": {
+                            "$ref": "#/definitions/main.Response"
+                        }
+                    },
+                    "404": {
+                        "description": "Document not found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Document is not deleted, or a unique value is already used",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "410": {
+                        "description": "Last revision no longer available",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "Document does not match its schema",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit or daily quota exceeded.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to restore document",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/upload": {
+            "post": {
+                "description": "Upload a file to CouchDB as an attachment",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Uploads a file",
+                "deprecated": true,
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "File to upload",
+                        "name": "file",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Document ID",
+                        "name": "docID",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "File uploaded successfully.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Response"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit or daily quota exceeded.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/audit": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "students"
+                ],
+                "summary": "Query the audit log of students",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant whose records are listed; those of the default database without it",
+                        "name": "tenant",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Student ID",
+                        "name": "doc_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Actor, e.g. user:admin, key:… or ip:…",
+                        "name": "actor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only records at or after this time",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only records before this time",
+                        "name": "until",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 100,
+                        "description": "Maximum number of records",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Bookmark of the previous page",
+                        "name": "bookmark",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.AuditResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid filter",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin routes are disabled",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit or daily quota exceeded.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to query audit log",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/changes": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "students"
+                ],
+                "summary": "Changes of students by address and age",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Address",
+                        "name": "address",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Age",
+                        "name": "age",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Address and age are required",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit or daily quota exceeded.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to retrieve changes",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/students": {
+            "get": {
+                "description": "Lists the students, as CouchDB's _all_docs result or, with format or Accept, as streamed flattened\nrecords (flat, ndjson, csv or xlsx).",
+                "produces": [
+                    "application/json",
+                    "application/x-ndjson",
+                    "text/csv",
+                    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+                ],
+                "tags": [
+                    "students"
+                ],
+                "summary": "List students",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "json, flat, ndjson, csv or xlsx; overrides Accept",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated flattened fields to output, e.g. _id,name,address.city",
+                        "name": "columns",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "type": "object",
+                                "additionalProperties": true
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Unknown format",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "406": {
+                        "description": "None of the accepted media types is supported",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit or daily quota exceeded.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to retrieve students",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Creates a student with a server-generated ID and answers with its Location. The ID is a UUIDv7, which\nsorts by creation time, prefixed with the prefix configured for the document type, e.g. stu_0190b5a2-….\nThe student is validated against the schema of its type, and values of unique fields, such as the\nemail, are reserved across the cluster.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "students"
+                ],
+                "summary": "Create a student",
+                "parameters": [
+                    {
+                        "description": "Student without _id",
+                        "name": "student",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.CreatedResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Path of the new student"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid student",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "A unique value is already used",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "Student does not match its schema",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit or daily quota exceeded.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to create student",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/students/import/csv": {
+            "post": {
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "students"
+                ],
+                "summary": "Import students from CSV",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "CSV file",
+                        "name": "file",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Column mapping, e.g. {\\",
+                        "name": "mapping",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "default": "email",
+                        "description": "Natural key field",
+                        "name": "key",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "boolean",
+                        "default": false,
+                        "description": "Validate and preview only",
+                        "name": "dry_run",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.RosterImportResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid file or mapping",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit or daily quota exceeded.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to import roster",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/students/import/xlsx": {
+            "post": {
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "students"
+                ],
+                "summary": "Import students from Excel",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "XLSX file",
+                        "name": "file",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Sheet name",
+                        "name": "sheet",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Column mapping, e.g. {\\",
+                        "name": "mapping",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "default": "email",
+                        "description": "Natural key field",
+                        "name": "key",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "boolean",
+                        "default": false,
+                        "description": "Validate and preview only",
+                        "name": "dry_run",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.RosterImportResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid file or mapping",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit or daily quota exceeded.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to import roster",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/students/{id}": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "students"
+                ],
+                "summary": "Get a student",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Student ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Student not found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit or daily quota exceeded.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to retrieve student",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Sets the given fields of a student, keeping the others.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "students"
+                ],
+                "summary": "Update a student",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Student ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Fields to set",
+                        "name": "student",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Student not found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "A unique value is already used",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "Student does not match its schema",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit or daily quota exceeded.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to update student",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Deletes a student. With SOFT_DELETE turned on, the student is moved to the trash instead, or deleted\nright away with permanent.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "students"
+                ],
+                "summary": "Delete a student",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Student ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "default": false,
+                        "description": "Delete without going through the trash",
+                        "name": "permanent",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.DeleteResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Student not found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit or daily quota exceeded.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to delete student",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "description": "Applies a JSON Patch (RFC 6902) or JSON Merge Patch (RFC 7396), optionally to the revision in If-Match.\nMembers starting with an underscore and deleted_at cannot be patched.",
+                "consumes": [
+                    "application/json-patch+json",
+                    "application/merge-patch+json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "students"
+                ],
+                "summary": "Patch a student",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Student ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Revision the patch applies to",
+                        "name": "If-Match",
+                        "in": "header"
+                    },
+                    {
+                        "description": "JSON Patch operations, or a merge patch object",
+                        "name": "patch",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.PatchOperation"
+                            }
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid patch",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Student not found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Test operation failed, student updated concurrently, or a unique value is already used",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "412": {
+                        "description": "Revision does not match",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported patch format",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "Patch does not apply, changes a protected member, or the result does not match its schema",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit or daily quota exceeded.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to patch student",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/students/{id}/attachments": {
+            "post": {
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "students"
+                ],
+                "summary": "Attach a file to a student",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Student ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "file",
+                        "description": "File to attach",
+                        "name": "file",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Missing file",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Student not found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit or daily quota exceeded.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to upload file",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/students/{id}/attachments/{name}": {
+            "get": {
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "students"
+                ],
+                "summary": "Download an attachment of a student",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Student ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Attachment name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "404": {
+                        "description": "File not found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit or daily quota exceeded.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to retrieve file",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/students/{id}/restore": {
+            "post": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "students"
+                ],
+                "summary": "Restore a deleted student",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Student ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Response"
+                        }
+                    },
+                    "404": {
+                        "description": "Student not found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Student is not deleted, or a unique value is already used",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "410": {
+                        "description": "Last revision no longer available",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "Student does not match its schema",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit or daily quota exceeded.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to restore student",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/trash": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "students"
+                ],
+                "summary": "List deleted students",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "default": 100,
+                        "description": "Maximum number of students",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Bookmark of the previous page",
+                        "name": "bookmark",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TrashResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid limit",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit or daily quota exceeded.",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to list trash",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "main.ActiveTask": {
+            "type": "object",
+            "properties": {
+                "changes_done": {
+                    "type": "integer"
+                },
+                "database": {
+                    "type": "string"
+                },
+                "design_document": {
+                    "type": "string"
+                },
+                "doc_id": {
+                    "type": "string"
+                },
+                "node": {
+                    "type": "string"
+                },
+                "phase": {
+                    "type": "string"
+                },
+                "pid": {
+                    "type": "string"
+                },
+                "progress": {
+                    "type": "integer"
+                },
+                "source": {
+                    "type": "string"
+                },
+                "started_on": {
+                    "type": "string"
+                },
+                "target": {
+                    "type": "string"
+                },
+                "total_changes": {
+                    "type": "integer"
+                },
+                "type": {
+                    "type": "string"
+                },
+                "updated_on": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.AuditRecord": {
+            "type": "object",
+            "properties": {
+                "_id": {
+                    "type": "string"
+                },
+                "action": {
+                    "description": "insert, update, patch, trash, delete, restore, upload or import",
+                    "type": "string"
+                },
+                "actor": {
+                    "type": "string"
+                },
+                "changes": {
+                    "description": "Changes lists the fields that differ between the old and the new\nrevision. CouchDB's own fields are left out.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.FieldChange"
+                    }
+                },
+                "doc_id": {
+                    "type": "string"
+                },
+                "method": {
+                    "type": "string"
+                },
+                "new_rev": {
+                    "type": "string"
+                },
+                "old_rev": {
+                    "type": "string"
+                },
+                "request_id": {
+                    "type": "string"
+                },
+                "route": {
+                    "type": "string"
+                },
+                "tenant": {
+                    "type": "string"
+                },
+                "time": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.AuditResponse": {
+            "type": "object",
+            "properties": {
+                "bookmark": {
+                    "description": "Bookmark fetches the next page when passed back.",
+                    "type": "string"
+                },
+                "records": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.AuditRecord"
+                    }
+                }
+            }
+        },
+        "main.CheckResult": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "latency_ms": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.CreatedResponse": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "string"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "rev": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.DeadLetter": {
+            "type": "object",
+            "properties": {
+                "_id": {
+                    "type": "string"
+                },
+                "attempts": {
+                    "type": "integer"
+                },
+                "failed_at": {
+                    "type": "string"
+                },
+                "last_error": {
+                    "type": "string"
+                },
+                "last_status": {
+                    "type": "integer"
+                },
+                "payload": {
+                    "$ref": "#/definitions/main.WebhookPayload"
+                },
+                "subscription": {
+                    "type": "string"
+                },
+                "url": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.DeleteResponse": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.FieldChange": {
+            "type": "object",
+            "properties": {
+                "field": {
+                    "description": "Field is the flattened field name, such as address.city.",
+                    "type": "string"
+                },
+                "new": {},
+                "old": {}
+            }
+        },
+        "main.HealthResponse": {
+            "type": "object",
+            "properties": {
+                "checks": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/main.CheckResult"
+                    }
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.ImportProgress": {
+            "type": "object",
+            "properties": {
+                "batch": {
+                    "type": "integer"
+                },
+                "docs": {
+                    "type": "integer"
+                },
+                "done": {
+                    "type": "boolean"
+                },
+                "errors": {
+                    "description": "Errors lists the documents of this batch that could not be written.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "failed": {
+                    "type": "integer"
+                },
+                "missing_attachments": {
+                    "description": "MissingAttachments counts attachment stubs that had no data in the\nexport and no file in the attachment tar. They are dropped.",
+                    "type": "integer"
+                },
+                "rejected": {
+                    "description": "Rejected lists the documents of this batch that were refused before\nwriting. They count as failed.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.ImportRejection"
+                    }
+                },
+                "skipped": {
+                    "type": "integer"
+                },
+                "written": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.ImportRejection": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string"
+                },
+                "detail": {
+                    "type": "string"
+                },
+                "doc_id": {
+                    "type": "string"
+                },
+                "field": {
+                    "description": "Field and Owner are the unique field and the document already holding\nits value, for a unique_violation.",
+                    "type": "string"
+                },
+                "line": {
+                    "description": "Line is the line of the document in the NDJSON input.",
+                    "type": "integer"
+                },
+                "owner": {
+                    "type": "string"
+                },
+                "violations": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.SchemaViolation"
+                    }
+                }
+            }
+        },
+        "main.MembershipResponse": {
+            "type": "object",
+            "properties": {
+                "all_nodes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "cluster_nodes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
                     }
-                ],
-                "responses": {
-                    "200": {
-                        "description": "File uploaded successfully.",
-                        "schema": {
-                            "type": "string"
-                        }
+                },
+                "consistent": {
+                    "description": "Consistent is true when every cluster node is connected and no other\nnode is.",
+                    "type": "boolean"
+                }
+            }
+        },
+        "main.NodeMemory": {
+            "type": "object",
+            "properties": {
+                "atom": {
+                    "type": "integer"
+                },
+                "binary": {
+                    "type": "integer"
+                },
+                "code": {
+                    "type": "integer"
+                },
+                "ets": {
+                    "type": "integer"
+                },
+                "other": {
+                    "type": "integer"
+                },
+                "processes": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.NodeSystemResponse": {
+            "type": "object",
+            "properties": {
+                "context_switches": {
+                    "type": "integer"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "internal_replication_jobs": {
+                    "type": "integer"
+                },
+                "io_input_bytes": {
+                    "type": "integer"
+                },
+                "io_output_bytes": {
+                    "type": "integer"
+                },
+                "memory": {
+                    "$ref": "#/definitions/main.NodeMemory"
+                },
+                "message_queues": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer"
                     }
+                },
+                "node": {
+                    "type": "string"
+                },
+                "os_proc_count": {
+                    "type": "integer"
+                },
+                "process_count": {
+                    "type": "integer"
+                },
+                "process_limit": {
+                    "type": "integer"
+                },
+                "reductions": {
+                    "type": "integer"
+                },
+                "run_queue": {
+                    "type": "integer"
+                },
+                "uptime_seconds": {
+                    "type": "integer"
                 }
             }
-        }
-    },
-    "definitions": {
-        "main.DeleteResponse": {
+        },
+        "main.PatchOperation": {
             "type": "object",
             "properties": {
-                "message": {
+                "from": {
+                    "type": "string"
+                },
+                "op": {
+                    "type": "string",
+                    "enum": [
+                        "test",
+                        "add",
+                        "remove",
+                        "replace",
+                        "move",
+                        "copy"
+                    ]
+                },
+                "path": {
+                    "type": "string"
+                },
+                "value": {
+                    "type": "object"
+                }
+            }
+        },
+        "main.Problem": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string",
+                    "enum": [
+                        "invalid_request",
+                        "unauthorized",
+                        "forbidden",
+                        "not_found",
+                        "method_not_allowed",
+                        "not_acceptable",
+                        "conflict",
+                        "unique_violation",
+                        "request_in_progress",
+                        "gone",
+                        "precondition_failed",
+                        "payload_too_large",
+                        "unsupported_media_type",
+                        "unprocessable",
+                        "validation_failed",
+                        "idempotency_key_reused",
+                        "rate_limited",
+                        "quota_exceeded",
+                        "internal_error",
+                        "upstream_error",
+                        "unavailable",
+                        "timeout"
+                    ],
+                    "example": "not_found"
+                },
+                "detail": {
+                    "type": "string",
+                    "example": "Document not found"
+                },
+                "doc_id": {
+                    "type": "string"
+                },
+                "document_type": {
+                    "description": "DocumentType, SchemaVersion and Violations describe a\nvalidation_failed problem.",
+                    "type": "string"
+                },
+                "field": {
+                    "description": "Field and DocID name the unique field and the document holding its\nvalue, on unique_violation.",
+                    "type": "string"
+                },
+                "instance": {
+                    "type": "string",
+                    "example": "/document/42"
+                },
+                "request_id": {
+                    "description": "RequestID is the X-Request-ID of the request, for support.",
+                    "type": "string"
+                },
+                "rev": {
+                    "description": "Rev is the current revision of the document, on conflicts and\nfailed preconditions.",
+                    "type": "string"
+                },
+                "schema_version": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "integer",
+                    "example": 404
+                },
+                "title": {
+                    "type": "string",
+                    "example": "Not found"
+                },
+                "type": {
+                    "type": "string",
+                    "example": "urn:student-api:problem:not_found"
+                },
+                "violations": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.SchemaViolation"
+                    }
+                }
+            }
+        },
+        "main.ReplicationJob": {
+            "type": "object",
+            "properties": {
+                "continuous": {
+                    "type": "boolean"
+                },
+                "create_target": {
+                    "type": "boolean"
+                },
+                "doc_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "error_count": {
+                    "type": "integer"
+                },
+                "filter": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "info": {},
+                "last_event": {
+                    "type": "string"
+                },
+                "last_updated": {
+                    "type": "string"
+                },
+                "node": {
+                    "type": "string"
+                },
+                "query_params": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "rev": {
+                    "type": "string"
+                },
+                "selector": {
+                    "type": "object",
+                    "additionalProperties": true
+                },
+                "source_db": {
+                    "type": "string"
+                },
+                "start_time": {
+                    "type": "string"
+                },
+                "state": {
+                    "type": "string"
+                },
+                "target": {
+                    "type": "string"
+                },
+                "target_db": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.ReplicationRequest": {
+            "type": "object",
+            "required": [
+                "target"
+            ],
+            "properties": {
+                "continuous": {
+                    "type": "boolean"
+                },
+                "create_target": {
+                    "type": "boolean"
+                },
+                "doc_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "filter": {
+                    "type": "string"
+                },
+                "id": {
+                    "description": "ID of the _replicator document. Generated by CouchDB when empty.",
+                    "type": "string"
+                },
+                "query_params": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "selector": {
+                    "type": "object",
+                    "additionalProperties": true
+                },
+                "target": {
+                    "description": "Target names one of the configured REPLICATION_TARGETS.",
+                    "type": "string"
+                },
+                "target_db": {
+                    "description": "TargetDB defaults to the name of the source database.",
                     "type": "string"
                 }
             }
@@ -383,17 +3918,250 @@ const docTemplate = `{
         "main.Response": {
             "type": "object",
             "properties": {
-                "error": {
+                "message": {
+                    "type": "string"
+                },
+                "rev": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.RosterImportResponse": {
+            "type": "object",
+            "properties": {
+                "created": {
+                    "type": "integer"
+                },
+                "dry_run": {
+                    "type": "boolean"
+                },
+                "failed": {
+                    "type": "integer"
+                },
+                "invalid": {
+                    "type": "integer"
+                },
+                "results": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.RowResult"
+                    }
+                },
+                "rows": {
+                    "type": "integer"
+                },
+                "updated": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.RowResult": {
+            "type": "object",
+            "properties": {
+                "errors": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "id": {
+                    "type": "string"
+                },
+                "rev": {
+                    "type": "string"
+                },
+                "row": {
+                    "description": "Row is the 1-based line or sheet row, counting the header row.",
+                    "type": "integer"
+                },
+                "status": {
+                    "description": "created, updated, invalid or failed",
+                    "type": "string"
+                }
+            }
+        },
+        "main.SchemaVersion": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "created_by": {
+                    "description": "CreatedBy is the admin user who stored the version.",
+                    "type": "string"
+                },
+                "schema": {
+                    "type": "object"
+                },
+                "type": {
                     "type": "string"
                 },
+                "version": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.SchemaViolation": {
+            "type": "object",
+            "properties": {
                 "message": {
                     "type": "string"
                 },
+                "path": {
+                    "description": "Path is the JSON pointer of the offending value, \"\" for the document.",
+                    "type": "string"
+                }
+            }
+        },
+        "main.ShardMapResponse": {
+            "type": "object",
+            "properties": {
+                "by_node": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        }
+                    }
+                },
+                "database": {
+                    "type": "string"
+                },
+                "ranges": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.ShardRange"
+                    }
+                }
+            }
+        },
+        "main.ShardRange": {
+            "type": "object",
+            "properties": {
+                "nodes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "range": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.TrashItem": {
+            "type": "object",
+            "properties": {
+                "deleted_at": {
+                    "type": "string"
+                },
+                "doc": {
+                    "type": "object",
+                    "additionalProperties": true
+                },
+                "id": {
+                    "type": "string"
+                },
+                "rev": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.TrashResponse": {
+            "type": "object",
+            "properties": {
+                "bookmark": {
+                    "description": "Bookmark fetches the next page when passed back.",
+                    "type": "string"
+                },
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.TrashItem"
+                    }
+                }
+            }
+        },
+        "main.Webhook": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
                 "rev": {
                     "type": "string"
+                },
+                "selector": {
+                    "type": "object",
+                    "additionalProperties": true
+                },
+                "tenant": {
+                    "description": "Tenant is the tenant whose changes are delivered, empty for the\ndefault student database.",
+                    "type": "string"
+                },
+                "url": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.WebhookPayload": {
+            "type": "object",
+            "properties": {
+                "deleted": {
+                    "description": "Deleted tells whether the document was deleted or moved to the trash.\nDoc is then the tombstone or the trashed document.",
+                    "type": "boolean"
+                },
+                "doc": {
+                    "type": "object"
+                },
+                "doc_id": {
+                    "type": "string"
+                },
+                "id": {
+                    "description": "ID identifies the delivery and stays the same across retries.",
+                    "type": "string"
+                },
+                "seq": {
+                    "type": "string"
+                },
+                "subscription": {
+                    "type": "string"
+                },
+                "tenant": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.WebhookRequest": {
+            "type": "object",
+            "required": [
+                "secret",
+                "url"
+            ],
+            "properties": {
+                "secret": {
+                    "description": "Secret signs the deliveries. It cannot be read back.",
+                    "type": "string"
+                },
+                "selector": {
+                    "description": "Selector is a Mango selector documents must match; empty matches all.",
+                    "type": "object",
+                    "additionalProperties": true
+                },
+                "url": {
+                    "type": "string"
                 }
             }
         }
+    },
+    "securityDefinitions": {
+        "BasicAuth": {
+            "type": "basic"
+        }
     }
 }`
 
@@ -404,7 +4172,7 @@ var SwaggerInfo = &swag.Spec{
 	BasePath:         "/",
 	Schemes:          []string{},
 	Title:            "Student API",
-	Description:      "This is a simple API to interact with CouchDB and perform CRUD operations.",
+	Description:      "This is a simple API to interact with CouchDB and perform CRUD operations.\nStudent routes work on the database of the request's tenant, named by the tenant claim of a bearer\ntoken and, when TENANT_SOURCES turns them on, the subdomain or the X-Tenant-ID header. Requests\nwithout a tenant use the student database. Only tenants of a verified token or of TENANTS get a\ndatabase on first use; others need an existing one.\nMutating requests may carry an Idempotency-Key header. A retry with the same key gets the stored\nresponse of the first request, marked with Idempotent-Replayed; reusing a key for a different\nrequest fails with 422. Keys belong to the tenant and to the admin user, the subject of the bearer token\nor else the API key or address of the client, and expire after IDEMPOTENCY_TTL_HOURS (24 by default).\nResponses larger than IDEMPOTENCY_MAX_BODY_KB (1024 by default) are not stored.\nErrors are RFC 7807 problem details served as application/problem+json. Clients should switch on\ntheir code, such as not_found, conflict, unique_violation or validation_failed, which never changes\nfor a kind of error; the detail is meant for people.\nThe resource-oriented routes under /v1 replace the unversioned ones, which answer with Deprecation,\nSunset and a Link to their successor until they are removed.\nWhen GRPC_ADDR is set, the students are also served over gRPC on it by the StudentService of\nstudentpb/student.proto, with the same tenants, rate limits, schemas and audit log. The gRPC API uses\nTLS with the certificate and key of GRPC_TLS_CERT and GRPC_TLS_KEY, and plaintext without them.",
 	InfoInstanceName: "swagger",
 	SwaggerTemplate:  docTemplate,
 	LeftDelim:        "{{",
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+// exportTrailer is the last line of an NDJSON export. It records the _changes
+// seq the export is consistent with; passing it as since to the next export
+// continues incrementally.
+type exportTrailer struct {
+	Export struct {
+		LastSeq string `json:"last_seq"`
+		Docs    int    `json:"docs"`
+	} `json:"_export"`
+}
+
+// attachmentStub is the part of an _attachments entry an export needs.
+type attachmentStub struct {
+	ContentType string `json:"content_type"`
+	Length      int64  `json:"length"`
+}
+
+// exportDocs writes every document of db changed since the given seq to w, one
+// JSON document per line, followed by an exportTrailer line. Documents carry
+// their revision history in _revisions, so an import with keep-revs
+// reproduces it. Deleted documents are written as tombstones. With inline set,
+// attachments are
+// embedded as base64 data instead of stubs. When tw is not nil the attachment
+// files of each exported revision are also written to it, named by
+// attachmentEntry.
+func exportDocs(ctx context.Context, db *kivik.DB, since string, inline bool, w io.Writer, tw *tar.Writer) (exportTrailer, error) {
+	var trailer exportTrailer
+	start := time.Now()
+	changes := db.Changes(ctx, kivik.Options{"since": since})
+	defer changes.Close()
+
+	enc := json.NewEncoder(w)
+	for changes.Next() {
+		revs := changes.Changes()
+		if len(revs) == 0 {
+			continue
+		}
+		doc, err := exportRevision(ctx, db, changes.ID(), revs[0], inline)
+		if err != nil {
+			return trailer, fmt.Errorf("read %s: %w", changes.ID(), err)
+		}
+		if err := enc.Encode(doc); err != nil {
+			return trailer, err
+		}
+		trailer.Export.Docs++
+		if tw == nil || changes.Deleted() {
+			continue
+		}
+
+		var stubs struct {
+			Rev         string                    `json:"_rev"`
+			Attachments map[string]attachmentStub `json:"_attachments"`
+		}
+		if err := json.Unmarshal(doc, &stubs); err != nil {
+			return trailer, fmt.Errorf("read %s: %w", changes.ID(), err)
+		}
+		for name, stub := range stubs.Attachments {
+			if err := exportAttachment(ctx, db, tw, changes.ID(), stubs.Rev, name, stub); err != nil {
+				return trailer, fmt.Errorf("attachment %s of %s: %w", name, changes.ID(), err)
+			}
+		}
+	}
+	err := changes.Err()
+	observeCouch("changes", start, err)
+	if err != nil {
+		return trailer, err
+	}
+	meta, err := changes.Metadata()
+	if err != nil {
+		return trailer, err
+	}
+	trailer.Export.LastSeq = meta.LastSeq
+	return trailer, enc.Encode(trailer)
+}
+
+// exportRevision reads revision rev of docID, deleted or not, with its
+// revision history.
+func exportRevision(ctx context.Context, db *kivik.DB, docID, rev string, inline bool) (json.RawMessage, error) {
+	opts := kivik.Options{"rev": rev, "revs": true}
+	if inline {
+		opts["attachments"] = true
+	}
+	var doc json.RawMessage
+	start := time.Now()
+	err := db.Get(ctx, docID, opts).ScanDoc(&doc)
+	observeCouch("Get", start, err)
+	return doc, err
+}
+
+// attachmentEntry returns the tar entry name of attachment name of docID:
+// <doc ID>/<file name> with both path-escaped, so that a slash in either
+// stays inside its segment. Names that would still resolve elsewhere, such
+// as "..", are refused.
+func attachmentEntry(docID, name string) (string, error) {
+	entry := url.PathEscape(docID) + "/" + url.PathEscape(name)
+	if path.Clean(entry) != entry || strings.HasPrefix(entry, "../") {
+		return "", fmt.Errorf("attachment %q of %q cannot be archived", name, docID)
+	}
+	return entry, nil
+}
+
+// exportAttachment copies one attachment of revision rev of docID into tw.
+func exportAttachment(ctx context.Context, db *kivik.DB, tw *tar.Writer, docID, rev, name string, stub attachmentStub) error {
+	entry, err := attachmentEntry(docID, name)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	att, err := db.GetAttachment(ctx, docID, name, kivik.Options{"rev": rev})
+	observeCouch("GetAttachment", start, err)
+	if err != nil {
+		return err
+	}
+	defer att.Content.Close()
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:    entry,
+		Mode:    0o644,
+		Size:    stub.Length,
+		ModTime: time.Now(),
+		PAXRecords: map[string]string{
+			"COUCHDB.content_type": stub.ContentType,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(tw, att.Content)
+	attachmentBytes.WithLabelValues("download").Add(float64(n))
+	return err
+}
+
+// exportFilename returns the Content-Disposition of an export of db, named
+// after the database so that exports of different tenants do not collide.
+func exportFilename(db *kivik.DB, suffix string) string {
+	name := strings.ReplaceAll(db.Name(), "/", "_") + suffix
+	return mime.FormatMediaType("attachment", map[string]string{"filename": name})
+}
+
+// runExport implements the "export" command.
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	out := fs.String("o", "-", "NDJSON output file, - for stdout")
+	since := fs.String("since", "0", "only export changes after this seq, as printed by a previous export")
+	attachments := fs.String("attachments", "none", "attachment handling: none (stubs only) or inline (base64)")
+	tarFile := fs.String("attachments-tar", "", "also write attachment files to this tar archive")
+	dbName := fs.String("db", "student", "database to export")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *attachments != "none" && *attachments != "inline" {
+		return fmt.Errorf("-attachments must be none or inline")
+	}
+	if err := connectCouchDB(); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	db := client.DB(*dbName)
+	w := io.Writer(os.Stdout)
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	var tw *tar.Writer
+	if *tarFile != "" {
+		f, err := os.Create(*tarFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		tw = tar.NewWriter(f)
+	}
+
+	trailer, err := exportDocs(ctx, db, *since, *attachments == "inline", w, tw)
+	if err != nil {
+		return err
+	}
+	if tw != nil {
+		if err := tw.Close(); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "exported %d documents, last_seq %s\n", trailer.Export.Docs, trailer.Export.LastSeq)
+	return nil
+}
+
+// exportHandler godoc
+// @Summary Export documents as NDJSON
+// @Description Streams every document of the tenant's student database as one JSON document per line. The last line is
+// @Description {"_export":{"last_seq":"...","docs":N}}; pass last_seq as since to export incrementally. Deleted documents
+// @Description are exported as tombstones. Documents carry their revision history in _revisions, which an import with the
+// @Description keep-revs policy preserves. The file is named after the tenant's database.
+// @Tags admin
+// @Produce application/x-ndjson
+// @Security BasicAuth
+// @Param tenant query string false "Tenant whose database is used; the default database without it"
+// @Param since query string false "Only export changes after this seq" default(0)
+// @Param attachments query string false "none (stubs only) or inline (base64 data)" default(none)
+// @Success 200 {string} string "NDJSON stream"
+// @Failure 400 {object} Problem "Invalid request"
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Admin routes are disabled"
+// @Router /admin/export [get]
+func exportHandler(c *gin.Context) {
+	attachments := c.DefaultQuery("attachments", "none")
+	if attachments != "none" && attachments != "inline" {
+		respondProblem(c, http.StatusBadRequest, codeInvalidRequest, "attachments must be none or inline")
+		return
+	}
+
+	db := studentDB(c)
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", exportFilename(db, ".ndjson"))
+	c.Status(http.StatusOK)
+	_, err := exportDocs(c.Request.Context(), db, c.DefaultQuery("since", "0"), attachments == "inline", c.Writer, nil)
+	if err != nil {
+		// The status line is already sent; a missing trailer line tells the
+		// client the export is incomplete.
+		logError(c, "Export failed", err)
+	}
+}
+
+// exportAttachmentsHandler godoc
+// @Summary Export attachments as tar
+// @Description Streams the attachments of every document changed since the given seq as a tar archive of
+// @Description <doc ID>/<file name> entries, both path-escaped. The archive is named after the tenant's database.
+// @Tags admin
+// @Produce application/x-tar
+// @Security BasicAuth
+// @Param tenant query string false "Tenant whose database is used; the default database without it"
+// @Param since query string false "Only export changes after this seq" default(0)
+// @Success 200 {file} file "tar archive"
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Admin routes are disabled"
+// @Router /admin/export/attachments [get]
+func exportAttachmentsHandler(c *gin.Context) {
+	db := studentDB(c)
+	c.Header("Content-Type", "application/x-tar")
+	c.Header("Content-Disposition", exportFilename(db, "-attachments.tar"))
+	c.Status(http.StatusOK)
+	tw := tar.NewWriter(c.Writer)
+	_, err := exportDocs(c.Request.Context(), db, c.DefaultQuery("since", "0"), false, io.Discard, tw)
+	if err != nil {
+		// Leaving out the end-of-archive marker makes the tar invalid.
+		logError(c, "Attachment export failed", err)
+		return
+	}
+	tw.Close()
+}
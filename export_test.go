@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestAttachmentEntry(t *testing.T) {
+	tests := []struct {
+		docID, name string
+		want        string
+		wantErr     bool
+	}{
+		{"s1", "photo.jpg", "s1/photo.jpg", false},
+		{"a/b", "c", "a%2Fb/c", false},
+		{"a", "b/c", "a/b%2Fc", false},
+		{"_design/x", "../../etc/passwd", "_design%2Fx/..%2F..%2Fetc%2Fpasswd", false},
+		{"s1", "..", "", true},
+		{"s1", ".", "", true},
+		{"..", "x", "", true},
+	}
+	for _, tt := range tests {
+		got, err := attachmentEntry(tt.docID, tt.name)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("attachmentEntry(%q, %q) = %q, %v; want %q, error %t", tt.docID, tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	kivik "github.com/go-kivik/kivik/v4"
+	"github.com/xuri/excelize/v2"
+)
+
+// Output formats of GET /documents. formatJSON is CouchDB's own _all_docs
+// response; the others write one flattened record per document.
+const (
+	formatJSON   = "json"
+	formatFlat   = "flat"
+	formatNDJSON = "ndjson"
+	formatCSV    = "csv"
+	formatXLSX   = "xlsx"
+)
+
+const xlsxContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// formatTypes maps each format to its media type. formatFlat is plain JSON
+// too, so it can only be asked for with ?format=.
+var formatTypes = map[string]string{
+	formatJSON:   "application/json",
+	formatFlat:   "application/json",
+	formatNDJSON: "application/x-ndjson",
+	formatCSV:    "text/csv",
+	formatXLSX:   xlsxContentType,
+}
+
+// negotiatedFormats are the formats offered to the Accept header, in order of
+// preference for wildcards.
+var negotiatedFormats = []string{formatJSON, formatNDJSON, formatCSV, formatXLSX}
+
+// documentsFormat picks the output format from ?format= or, failing that, the
+// Accept header. ok is false when neither names a supported format.
+func documentsFormat(c *gin.Context) (format string, ok bool) {
+	if format := c.Query("format"); format != "" {
+		_, ok := formatTypes[format]
+		return format, ok
+	}
+	offered := make([]string, len(negotiatedFormats))
+	for i, f := range negotiatedFormats {
+		offered[i] = formatTypes[f]
+	}
+	mediaType := c.NegotiateFormat(offered...)
+	for _, f := range negotiatedFormats {
+		if formatTypes[f] == mediaType {
+			return f, true
+		}
+	}
+	return "", false
+}
+
+// flatten adds v to rec under key. The fields of nested objects and the items
+// of arrays are added individually, their names or indexes joined to key with
+// dots, so {"address":{"city":"X"}} becomes {"address.city":"X"}.
+func flatten(rec map[string]interface{}, key string, v interface{}) {
+	join := func(name string) string {
+		if key == "" {
+			return name
+		}
+		return key + "." + name
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for name, item := range v {
+			flatten(rec, join(name), item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			flatten(rec, join(strconv.Itoa(i)), item)
+		}
+	default:
+		rec[key] = v
+	}
+}
+
+// documentRecord flattens a document. Without columns, CouchDB's special
+// fields other than _id are left out; with columns, only those fields are
+// kept. Documents in the trash give a nil record.
+func documentRecord(raw json.RawMessage, columns []string) (map[string]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var doc map[string]interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if isTrashed(doc) {
+		return nil, nil
+	}
+	rec := map[string]interface{}{}
+	flatten(rec, "", doc)
+
+	if columns == nil {
+		for name := range rec {
+			if strings.HasPrefix(name, "_") && name != "_id" {
+				delete(rec, name)
+			}
+		}
+		return rec, nil
+	}
+	picked := make(map[string]interface{}, len(columns))
+	for _, name := range columns {
+		if v, ok := rec[name]; ok {
+			picked[name] = v
+		}
+	}
+	return picked, nil
+}
+
+// eachDocument calls fn with every document of db other than design
+// documents, in _id order. Rows are read as CouchDB sends them.
+func eachDocument(ctx context.Context, db *kivik.DB, fn func(json.RawMessage) error) error {
+	start := time.Now()
+	rows := db.AllDocs(ctx, kivik.Options{"include_docs": true})
+	defer rows.Close()
+	for rows.Next() {
+		id, err := rows.ID()
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(id, "_design/") {
+			continue
+		}
+		var doc json.RawMessage
+		if err := rows.ScanDoc(&doc); err != nil {
+			return fmt.Errorf("read %s: %w", id, err)
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+	err := rows.Err()
+	observeCouch("AllDocs", start, err)
+	return err
+}
+
+// documentColumns returns every flattened field of the documents of db, _id
+// first and the others sorted. It takes a pass over the database so that the
+// documents do not have to be held while the header is written.
+func documentColumns(ctx context.Context, db *kivik.DB) ([]string, error) {
+	seen := map[string]bool{}
+	err := eachDocument(ctx, db, func(raw json.RawMessage) error {
+		rec, err := documentRecord(raw, nil)
+		for name := range rec {
+			seen[name] = true
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	delete(seen, "_id")
+	columns := make([]string, 0, len(seen)+1)
+	for name := range seen {
+		columns = append(columns, name)
+	}
+	sort.Strings(columns)
+	return append([]string{"_id"}, columns...), nil
+}
+
+// recordWriter writes flattened documents in one of the output formats.
+type recordWriter interface {
+	Write(rec map[string]interface{}) error
+	Close() error
+}
+
+// newRecordWriter returns a recordWriter for format writing to w. CSV and
+// XLSX need columns for the header row.
+func newRecordWriter(format string, w io.Writer, columns []string) (recordWriter, error) {
+	switch format {
+	case formatNDJSON:
+		return ndjsonWriter{enc: json.NewEncoder(w)}, nil
+	case formatFlat:
+		return &jsonArrayWriter{w: w}, nil
+	case formatCSV:
+		cw := csv.NewWriter(w)
+		return &csvWriter{w: cw, columns: columns}, cw.Write(columns)
+	case formatXLSX:
+		xw, err := newXLSXWriter(w, columns)
+		if err != nil {
+			return nil, err
+		}
+		return xw, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func (nw ndjsonWriter) Write(rec map[string]interface{}) error { return nw.enc.Encode(rec) }
+func (nw ndjsonWriter) Close() error                           { return nil }
+
+// jsonArrayWriter writes records as the items of a JSON array.
+type jsonArrayWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (jw *jsonArrayWriter) Write(rec map[string]interface{}) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	sep := ","
+	if jw.count == 0 {
+		sep = "["
+	}
+	jw.count++
+	if _, err := io.WriteString(jw.w, sep); err != nil {
+		return err
+	}
+	_, err = jw.w.Write(b)
+	return err
+}
+
+func (jw *jsonArrayWriter) Close() error {
+	end := "]"
+	if jw.count == 0 {
+		end = "[]"
+	}
+	_, err := io.WriteString(jw.w, end)
+	return err
+}
+
+type csvWriter struct {
+	w       *csv.Writer
+	columns []string
+}
+
+func (cw *csvWriter) Write(rec map[string]interface{}) error {
+	row := make([]string, len(cw.columns))
+	for i, name := range cw.columns {
+		row[i] = cellString(rec[name])
+	}
+	return cw.w.Write(row)
+}
+
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// cellString formats a flattened value for CSV. Missing values and nulls are
+// empty.
+func cellString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return fmt.Sprint(v)
+}
+
+// xlsxWriter writes records to a single sheet workbook. An XLSX file is a zip
+// archive that can only be written once complete, so rows go through
+// excelize's stream writer, which keeps large sheets in a temporary file
+// rather than in memory, and the workbook is copied to w on Close.
+type xlsxWriter struct {
+	f       *excelize.File
+	sw      *excelize.StreamWriter
+	w       io.Writer
+	columns []string
+	row     int
+}
+
+func newXLSXWriter(w io.Writer, columns []string) (*xlsxWriter, error) {
+	f := excelize.NewFile()
+	if err := f.SetSheetName("Sheet1", "student"); err != nil {
+		f.Close()
+		return nil, err
+	}
+	sw, err := f.NewStreamWriter("student")
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	xw := &xlsxWriter{f: f, sw: sw, w: w, columns: columns}
+	header := make([]interface{}, len(columns))
+	for i, name := range columns {
+		header[i] = name
+	}
+	return xw, xw.setRow(header)
+}
+
+func (xw *xlsxWriter) setRow(values []interface{}) error {
+	xw.row++
+	cell, err := excelize.CoordinatesToCellName(1, xw.row)
+	if err != nil {
+		return err
+	}
+	return xw.sw.SetRow(cell, values)
+}
+
+func (xw *xlsxWriter) Write(rec map[string]interface{}) error {
+	row := make([]interface{}, len(xw.columns))
+	for i, name := range xw.columns {
+		switch v := rec[name].(type) {
+		case json.Number:
+			// Numbers stay numeric in the sheet.
+			if n, err := v.Int64(); err == nil {
+				row[i] = n
+			} else if f, err := v.Float64(); err == nil {
+				row[i] = f
+			} else {
+				row[i] = v.String()
+			}
+		case nil, string, bool:
+			row[i] = v
+		default:
+			row[i] = cellString(v)
+		}
+	}
+	return xw.setRow(row)
+}
+
+func (xw *xlsxWriter) Close() error {
+	defer xw.f.Close()
+	if err := xw.sw.Flush(); err != nil {
+		return err
+	}
+	return xw.f.Write(xw.w)
+}
+
+// writeDocuments streams the documents of the student database to the
+// client in format, restricted to the comma separated ?columns= if given.
+func writeDocuments(c *gin.Context, format string) {
+	ctx := c.Request.Context()
+	db := studentDB(c)
+
+	var columns []string
+	if list := c.Query("columns"); list != "" {
+		for _, name := range strings.Split(list, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				columns = append(columns, name)
+			}
+		}
+	} else if format == formatCSV || format == formatXLSX {
+		var err error
+		if columns, err = documentColumns(ctx, db); err != nil {
+			respondCouchError(c, "Failed to retrieve documents", err)
+			return
+		}
+	}
+
+	// The response is only started with the first document, so that an
+	// unreachable database still gets an error status.
+	var rw recordWriter
+	begin := func() (err error) {
+		c.Header("Content-Type", formatTypes[format])
+		if format != formatFlat {
+			c.Header("Content-Disposition", "attachment; filename=student."+format)
+		}
+		c.Status(http.StatusOK)
+		rw, err = newRecordWriter(format, c.Writer, columns)
+		return err
+	}
+	err := eachDocument(ctx, db, func(raw json.RawMessage) error {
+		rec, err := documentRecord(raw, columns)
+		if err != nil || rec == nil {
+			return err
+		}
+		if rw == nil {
+			if err := begin(); err != nil {
+				return err
+			}
+		}
+		return rw.Write(rec)
+	})
+	if err == nil && rw == nil {
+		err = begin()
+	}
+	if err == nil {
+		err = rw.Close()
+	}
+	if err == nil {
+		return
+	}
+	if !c.Writer.Written() {
+		c.Writer.Header().Del("Content-Type")
+		c.Writer.Header().Del("Content-Disposition")
+		respondCouchError(c, "Failed to retrieve documents", err)
+		return
+	}
+	// The status line is already sent; the output is cut short instead.
+	logError(c, "Failed to write documents", err, "format", format)
+}
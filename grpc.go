@@ -0,0 +1,503 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"main.go/studentpb"
+)
+
+var (
+	// grpcAddr is where the gRPC API listens. It is off when empty, which
+	// is the default.
+	grpcAddr = envString("GRPC_ADDR", "")
+	// grpcTLSCert and grpcTLSKey are the PEM files of the certificate and
+	// key the gRPC API is served with. Without them it is served in
+	// plaintext, for use behind a TLS terminating proxy.
+	grpcTLSCert = envString("GRPC_TLS_CERT", "")
+	grpcTLSKey  = envString("GRPC_TLS_KEY", "")
+)
+
+// downloadChunkSize is the size of the chunks attachments are downloaded in.
+const downloadChunkSize = 32 << 10
+
+// grpcRateLimitGroups assigns each method the rate limit group of the
+// matching HTTP route.
+var grpcRateLimitGroups = map[string]string{
+	studentpb.StudentService_Get_FullMethodName:          "read",
+	studentpb.StudentService_Put_FullMethodName:          "write",
+	studentpb.StudentService_Delete_FullMethodName:       "write",
+	studentpb.StudentService_Restore_FullMethodName:      "write",
+	studentpb.StudentService_List_FullMethodName:         "documents",
+	studentpb.StudentService_WatchChanges_FullMethodName: "documents",
+	studentpb.StudentService_Upload_FullMethodName:       "write",
+	studentpb.StudentService_Download_FullMethodName:     "read",
+}
+
+// grpcCodes maps the problem codes of the HTTP API to gRPC status codes.
+var grpcCodes = map[string]codes.Code{
+	codeInvalidRequest:     codes.InvalidArgument,
+	codeUnauthorized:       codes.Unauthenticated,
+	codeForbidden:          codes.PermissionDenied,
+	codeNotFound:           codes.NotFound,
+	codeConflict:           codes.Aborted,
+	codeUniqueViolation:    codes.AlreadyExists,
+	codePreconditionFailed: codes.FailedPrecondition,
+	codePayloadTooLarge:    codes.ResourceExhausted,
+	codeValidationFailed:   codes.InvalidArgument,
+	codeRateLimited:        codes.ResourceExhausted,
+	codeQuotaExceeded:      codes.ResourceExhausted,
+	codeUnavailable:        codes.Unavailable,
+	codeTimeout:            codes.DeadlineExceeded,
+}
+
+type callerKey struct{}
+
+// callerFrom returns the caller stored by the gRPC interceptors.
+func callerFrom(ctx context.Context) caller {
+	who, _ := ctx.Value(callerKey{}).(caller)
+	return who
+}
+
+// serveGRPC serves the gRPC API on grpcAddr until ctx is done, then drains
+// the running calls for up to shutdownTimeout. Streams that outlast it, such
+// as WatchChanges, are cut off.
+func serveGRPC(ctx context.Context) {
+	var opts []grpc.ServerOption
+	if grpcTLSCert != "" || grpcTLSKey != "" {
+		creds, err := credentials.NewServerTLSFromFile(grpcTLSCert, grpcTLSKey)
+		if err != nil {
+			fatal("Failed to load gRPC TLS credentials", "cert", grpcTLSCert, "key", grpcTLSKey, "error", err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	lis, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		fatal("Failed to listen for gRPC", "addr", grpcAddr, "error", err)
+	}
+	srv := newGRPCServer(opts...)
+
+	errc := make(chan error, 1)
+	go func() {
+		slog.Info("Listening for gRPC", "addr", grpcAddr, "tls", len(opts) > 0)
+		errc <- srv.Serve(lis)
+	}()
+	select {
+	case err := <-errc:
+		fatal("gRPC server stopped", "error", err)
+	case <-ctx.Done():
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(shutdownTimeout):
+		slog.Warn("gRPC calls still running at shutdown timeout, closing connections")
+		srv.Stop()
+	}
+}
+
+// newGRPCServer returns a server with the StudentService and reflection.
+func newGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(unaryScope),
+		grpc.ChainStreamInterceptor(streamScope),
+	)
+	srv := grpc.NewServer(opts...)
+	studentpb.RegisterStudentServiceServer(srv, studentServer{})
+	reflection.Register(srv)
+	return srv
+}
+
+// unaryScope runs a unary call in the scope of its caller.
+func unaryScope(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			err = recovered(ctx, info.FullMethod, r)
+		}
+		observeGRPC(ctx, info.FullMethod, start, err)
+	}()
+	ctx, err = grpcScope(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	resp, err = handler(ctx, req)
+	return resp, grpcError(ctx, info.FullMethod, err)
+}
+
+// streamScope runs a streaming call in the scope of its caller.
+func streamScope(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	start := time.Now()
+	ctx := ss.Context()
+	defer func() {
+		if r := recover(); r != nil {
+			err = recovered(ctx, info.FullMethod, r)
+		}
+		observeGRPC(ctx, info.FullMethod, start, err)
+	}()
+	ctx, err = grpcScope(ctx, info.FullMethod)
+	if err != nil {
+		return err
+	}
+	err = handler(srv, scopedStream{ss, ctx})
+	return grpcError(ctx, info.FullMethod, err)
+}
+
+// scopedStream is a server stream whose context carries the caller.
+type scopedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s scopedStream) Context() context.Context { return s.ctx }
+
+// grpcScope does for a gRPC call what the middleware does for an HTTP
+// request: it assigns a request ID, resolves the tenant from the metadata and
+// enforces the rate limit and the daily quota of the client. It returns the
+// context for the call, carrying the caller. Calls of other services than
+// the StudentService, such as reflection, only get a request ID.
+func grpcScope(ctx context.Context, method string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	header := func(name string) string {
+		if v := md.Get(name); len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	id := header("x-request-id")
+	if id == "" || len(id) > 128 {
+		id = newRequestID()
+	}
+	ctx = context.WithValue(ctx, requestIDKey{}, id)
+	grpc.SetHeader(ctx, metadata.Pairs("x-request-id", id))
+	group, ok := grpcRateLimitGroups[method]
+	if !ok {
+		return ctx, nil
+	}
+
+	var ip string
+	if p, ok := peer.FromContext(ctx); ok {
+		ip, _, _ = net.SplitHostPort(p.Addr.String())
+	}
+	key := clientKeyOf(header("x-api-key"), ip)
+	now := time.Now()
+	if _, wait, ok := rateLimitGroups[group].take(key, now); !ok {
+		grpc.SetHeader(ctx, metadata.Pairs("retry-after", seconds(wait)))
+		return ctx, status.Error(codes.ResourceExhausted, "Rate limit exceeded.")
+	}
+	if !quotas.allow(ctx, key, now) {
+		grpc.SetHeader(ctx, metadata.Pairs("retry-after", seconds(untilMidnight(now))))
+		return ctx, status.Error(codes.ResourceExhausted, "Daily quota exceeded.")
+	}
+
+	tenant, db, err := scopeTenant(ctx, header(":authority"), header)
+	var te *tenantError
+	if errors.As(err, &te) {
+		return ctx, status.Error(grpcCodes[statusCode(te.status)], te.msg)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to prepare tenant database", "tenant", tenant, "method", method, "error", err)
+		return ctx, status.Error(codes.Unavailable, "Tenant database is not available.")
+	}
+	return context.WithValue(ctx, callerKey{}, caller{
+		tenant: tenant,
+		db:     db,
+		actor:  key,
+		method: "GRPC",
+		route:  method,
+	}), nil
+}
+
+// grpcError turns an error of the repository into a gRPC status, using the
+// same mapping as the problems of the HTTP API. Server-side failures are
+// logged rather than described to the client.
+func grpcError(ctx context.Context, method string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	var se *schemaError
+	var ue *uniqueError
+	switch {
+	case errors.As(err, &se):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.As(err, &ue):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	}
+	httpStatus, code := couchProblem(err)
+	grpcCode, ok := grpcCodes[code]
+	if !ok {
+		grpcCode = codes.Internal
+	}
+	if httpStatus >= http.StatusInternalServerError {
+		slog.ErrorContext(ctx, "gRPC call failed", "method", method, "code", grpcCode.String(), "error", err)
+		return status.Error(grpcCode, "The call failed.")
+	}
+	return status.Error(grpcCode, err.Error())
+}
+
+// recovered answers a call that panicked with r.
+func recovered(ctx context.Context, method string, r any) error {
+	slog.ErrorContext(ctx, "gRPC call panicked", "method", method, "panic", r)
+	return status.Error(codes.Internal, "The call failed unexpectedly.")
+}
+
+// observeGRPC records the metrics and the log record of a finished call.
+func observeGRPC(ctx context.Context, method string, start time.Time, err error) {
+	code := status.Code(err).String()
+	latency := time.Since(start)
+	grpcRequests.WithLabelValues(method, code).Inc()
+	grpcDuration.WithLabelValues(method, code).Observe(latency.Seconds())
+
+	args := []any{"method", method, "code", code, "latency_ms", latency.Milliseconds()}
+	if tenant := callerFrom(ctx).tenant; tenant != "" {
+		args = append(args, "tenant", tenant)
+	}
+	level := slog.LevelInfo
+	if c := status.Code(err); c == codes.Internal || c == codes.Unknown {
+		level = slog.LevelError
+	}
+	slog.Log(ctx, level, "grpc request", args...)
+}
+
+// studentServer implements the gRPC StudentService on studentRepo.
+type studentServer struct {
+	studentpb.UnimplementedStudentServiceServer
+}
+
+func (studentServer) repo(ctx context.Context) studentRepo {
+	return studentRepo{callerFrom(ctx)}
+}
+
+func (s studentServer) Get(ctx context.Context, req *studentpb.GetRequest) (*studentpb.Student, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+	doc, _, err := s.repo(ctx).get(ctx, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	return toStudent(doc)
+}
+
+func (s studentServer) Put(ctx context.Context, req *studentpb.PutRequest) (*studentpb.PutResponse, error) {
+	student := req.GetStudent()
+	doc := student.GetFields().AsMap()
+	delete(doc, "_id")
+	delete(doc, "_rev")
+	repo := s.repo(ctx)
+
+	if student.GetId() == "" {
+		id, rev, err := repo.create(ctx, doc)
+		if err != nil {
+			return nil, err
+		}
+		return &studentpb.PutResponse{Id: id, Rev: rev}, nil
+	}
+
+	id := student.GetId()
+	action := "update"
+	before, err := repo.load(ctx, id)
+	switch {
+	case errors.Is(err, errDocumentNotFound):
+		action = "insert"
+	case err != nil:
+		return nil, err
+	case isTrashed(before):
+		return nil, status.Error(codes.FailedPrecondition, "Student is in the trash; restore it first.")
+	default:
+		rev, _ := before["_rev"].(string)
+		if student.GetRev() != "" && student.GetRev() != rev {
+			return nil, status.Error(codes.FailedPrecondition, "Student is at revision "+rev)
+		}
+		doc["_rev"] = rev
+	}
+	rev, err := repo.put(ctx, action, id, before, doc)
+	if err != nil {
+		return nil, err
+	}
+	return &studentpb.PutResponse{Id: id, Rev: rev}, nil
+}
+
+func (s studentServer) Delete(ctx context.Context, req *studentpb.DeleteRequest) (*studentpb.DeleteResponse, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+	repo := s.repo(ctx)
+	doc, err := repo.load(ctx, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	if isTrashed(doc) && !req.GetPermanent() {
+		return nil, errDocumentNotFound
+	}
+	trashed, err := repo.remove(ctx, req.GetId(), doc, req.GetPermanent())
+	if err != nil {
+		return nil, err
+	}
+	return &studentpb.DeleteResponse{Trashed: trashed}, nil
+}
+
+func (s studentServer) Restore(ctx context.Context, req *studentpb.RestoreRequest) (*studentpb.RestoreResponse, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+	rev, err := s.repo(ctx).restore(ctx, req.GetId())
+	switch {
+	case errors.Is(err, errNotDeleted):
+		return nil, status.Error(codes.FailedPrecondition, "Student is not deleted.")
+	case errors.Is(err, errNotRecoverable):
+		return nil, status.Error(codes.FailedPrecondition, "Student cannot be restored: "+err.Error())
+	case err != nil:
+		return nil, err
+	}
+	return &studentpb.RestoreResponse{Rev: rev}, nil
+}
+
+func (s studentServer) List(req *studentpb.ListRequest, stream studentpb.StudentService_ListServer) error {
+	ctx := stream.Context()
+	return s.repo(ctx).list(ctx, func(doc map[string]interface{}) error {
+		student, err := toStudent(doc)
+		if err != nil {
+			return err
+		}
+		return stream.Send(student)
+	})
+}
+
+func (s studentServer) WatchChanges(req *studentpb.WatchChangesRequest, stream studentpb.StudentService_WatchChangesServer) error {
+	ctx := stream.Context()
+	// The headers go out right away, as the feed may stay quiet for long.
+	stream.SendHeader(nil)
+	return s.repo(ctx).watch(ctx, req.GetSince(), func(ch studentChange) error {
+		change := &studentpb.Change{Id: ch.ID, Seq: ch.Seq, Deleted: ch.Deleted}
+		if ch.Doc != nil {
+			student, err := toStudent(ch.Doc)
+			if err != nil {
+				return err
+			}
+			change.Student = student
+		}
+		return stream.Send(change)
+	})
+}
+
+func (s studentServer) Upload(stream studentpb.StudentService_UploadServer) error {
+	ctx := stream.Context()
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	att := first.GetAttachment()
+	if att.GetId() == "" || att.GetFilename() == "" {
+		return status.Error(codes.InvalidArgument, "The first message must name the student and the file.")
+	}
+	rev, err := s.repo(ctx).attach(ctx, att.GetId(), att.GetFilename(), att.GetContentType(), &uploadReader{stream: stream})
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(&studentpb.UploadResponse{Rev: rev})
+}
+
+func (s studentServer) Download(req *studentpb.DownloadRequest, stream studentpb.StudentService_DownloadServer) error {
+	if req.GetId() == "" || req.GetFilename() == "" {
+		return status.Error(codes.InvalidArgument, "id and filename are required")
+	}
+	ctx := stream.Context()
+	att, err := s.repo(ctx).attachment(ctx, req.GetId(), req.GetFilename())
+	if err != nil {
+		return err
+	}
+	defer att.Content.Close()
+
+	err = stream.Send(&studentpb.DownloadResponse{Part: &studentpb.DownloadResponse_Attachment{Attachment: &studentpb.Attachment{
+		Id:          req.GetId(),
+		Filename:    req.GetFilename(),
+		ContentType: att.ContentType,
+	}}})
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, downloadChunkSize)
+	for {
+		n, err := att.Content.Read(buf)
+		if n > 0 {
+			attachmentBytes.WithLabelValues("download").Add(float64(n))
+			if err := stream.Send(&studentpb.DownloadResponse{Part: &studentpb.DownloadResponse_Chunk{Chunk: buf[:n]}}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// uploadReader reads the chunks of an Upload stream.
+type uploadReader struct {
+	stream studentpb.StudentService_UploadServer
+	buf    []byte
+}
+
+func (r *uploadReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		req, err := r.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		if req.GetAttachment() != nil {
+			return 0, status.Error(codes.InvalidArgument, "Only the first message may name the file.")
+		}
+		r.buf = req.GetChunk()
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// toStudent converts a CouchDB document to a Student.
+func toStudent(doc map[string]interface{}) (*studentpb.Student, error) {
+	student := &studentpb.Student{}
+	student.Id, _ = doc["_id"].(string)
+	student.Rev, _ = doc["_rev"].(string)
+	fields := make(map[string]interface{}, len(doc))
+	for k, v := range doc {
+		if k != "_id" && k != "_rev" {
+			fields[k] = v
+		}
+	}
+	var err error
+	student.Fields, err = structpb.NewStruct(fields)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Student cannot be represented: "+strings.TrimPrefix(err.Error(), "proto: "))
+	}
+	return student, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"slices"
+	"testing"
+
+	kivik "github.com/go-kivik/kivik/v4"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"main.go/studentpb"
+)
+
+// dialBufconn serves srv on an in-memory listener and returns a client
+// connection to it.
+func dialBufconn(t *testing.T, srv *grpc.Server) *grpc.ClientConn {
+	lis := bufconn.Listen(1 << 20)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGRPCReflection(t *testing.T) {
+	conn := dialBufconn(t, newGRPCServer())
+
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("ListServices failed: %v", err)
+	}
+	var services []string
+	for _, s := range resp.GetListServicesResponse().GetService() {
+		services = append(services, s.GetName())
+	}
+	if !slices.Contains(services, "student.v1.StudentService") {
+		t.Errorf("services = %v, want student.v1.StudentService among them", services)
+	}
+	stream.CloseSend()
+}
+
+func TestGRPCStatusCodes(t *testing.T) {
+	f := newFakeCouch(t, defaultDB, lockDBName(defaultDB), auditDB, quotaDB, schemaDB)
+	f.store(f.dbs[defaultDB], "s-ann", map[string]interface{}{"name": "Ann"}, false)
+	f.store(f.dbs[schemaDB], schemaDocID("course", 1), map[string]interface{}{
+		"type":    "course",
+		"version": 1,
+		"schema":  map[string]interface{}{"type": "object", "required": []string{"title"}},
+	}, false)
+	documentSchemas.invalidate()
+	t.Cleanup(documentSchemas.invalidate)
+
+	conn := dialBufconn(t, newGRPCServer())
+	client := studentpb.NewStudentServiceClient(conn)
+	ctx := context.Background()
+	student := func(id, rev string, fields map[string]interface{}) *studentpb.PutRequest {
+		s, err := structpb.NewStruct(fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &studentpb.PutRequest{Student: &studentpb.Student{Id: id, Rev: rev, Fields: s}}
+	}
+
+	tests := []struct {
+		name string
+		call func() error
+		want codes.Code
+	}{
+		{"missing student", func() error {
+			_, err := client.Get(ctx, &studentpb.GetRequest{Id: "s-ghost"})
+			return err
+		}, codes.NotFound},
+		{"stale revision", func() error {
+			_, err := client.Put(ctx, student("s-ann", "1-stale", map[string]interface{}{"name": "Ann"}))
+			return err
+		}, codes.FailedPrecondition},
+		{"restoring a student that is not deleted", func() error {
+			_, err := client.Restore(ctx, &studentpb.RestoreRequest{Id: "s-ann"})
+			return err
+		}, codes.FailedPrecondition},
+		{"document not matching its schema", func() error {
+			_, err := client.Put(ctx, student("", "", map[string]interface{}{"type": "course"}))
+			return err
+		}, codes.InvalidArgument},
+		{"invalid document type", func() error {
+			_, err := client.Put(ctx, student("", "", map[string]interface{}{"type": "Not a type"}))
+			return err
+		}, codes.InvalidArgument},
+		{"unique value of another student", func() error {
+			_, err := client.Put(ctx, student("s-bob", "", map[string]interface{}{"name": "Bob", "email": " ANN@example.com"}))
+			return err
+		}, codes.AlreadyExists},
+		{"unknown tenant", func() error {
+			_, err := client.Get(metadata.AppendToOutgoingContext(ctx, "x-tenant-id", "ghost"), &studentpb.GetRequest{Id: "s-ann"})
+			return err
+		}, codes.NotFound},
+		{"invalid tenant", func() error {
+			_, err := client.Get(metadata.AppendToOutgoingContext(ctx, "x-tenant-id", "_users"), &studentpb.GetRequest{Id: "s-ann"})
+			return err
+		}, codes.InvalidArgument},
+	}
+
+	defer func(sources []string) { tenantSources = sources }(tenantSources)
+	tenantSources = []string{"token", "header"}
+	// Giving Ann an email through the API locks it.
+	if _, err := client.Put(ctx, student("s-ann", "", map[string]interface{}{"name": "Ann", "email": "ann@example.com"})); err != nil {
+		t.Fatalf("updating Ann: %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := status.Code(tt.call()); got != tt.want {
+				t.Errorf("code = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("unscoped tenant", func(t *testing.T) {
+		defer func(require bool) { requireTenant = require }(requireTenant)
+		requireTenant = true
+		_, err := client.Get(ctx, &studentpb.GetRequest{Id: "s-ann"})
+		if got := status.Code(err); got != codes.InvalidArgument {
+			t.Errorf("code = %s, want %s", got, codes.InvalidArgument)
+		}
+	})
+
+	t.Run("CouchDB conflict", func(t *testing.T) {
+		err := grpcError(ctx, "/student.v1.StudentService/Put", &kivik.Error{Status: http.StatusConflict, Message: "Document update conflict."})
+		if got := status.Code(err); got != codes.Aborted {
+			t.Errorf("code = %s, want %s", got, codes.Aborted)
+		}
+	})
+}
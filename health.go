@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requiredDesignDocs must exist in the student database for every route to
+// work. _design/filters backs the /changes filter. Tenant databases get a copy
+// of them when they are created.
+var requiredDesignDocs = []string{"_design/filters"}
+
+type CheckResult struct {
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+}
+
+type HealthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// healthzHandler godoc
+// @Summary Liveness probe
+// @Description Reports that the process is running. It does not contact CouchDB.
+// @Tags health
+// @Produce json
+// @Success 200 {object} HealthResponse
+// @Router /healthz [get]
+func healthzHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// readyzHandler godoc
+// @Summary Readiness probe
+// @Description Checks that every configured CouchDB node is up, that the cluster membership is consistent,
+// @Description and that the student database and its required design documents exist. Each check reports its own status.
+// @Tags health
+// @Produce json
+// @Success 200 {object} HealthResponse
+// @Failure 503 {object} HealthResponse
+// @Router /readyz [get]
+func readyzHandler(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 3*time.Second)
+	defer cancel()
+
+	checks := map[string]func(context.Context) error{
+		"membership": checkMembership,
+		"database":   checkDatabase,
+	}
+	for _, node := range couchNodes {
+		checks["node:"+nodeLabel(node)] = func(ctx context.Context) error { return probeNode(ctx, node) }
+	}
+	for _, ddoc := range requiredDesignDocs {
+		checks["design_doc:"+ddoc] = func(ctx context.Context) error { return checkDesignDoc(ctx, ddoc) }
+	}
+
+	resp := HealthResponse{Status: "ok", Checks: make(map[string]CheckResult, len(checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := check(ctx)
+			result := CheckResult{Status: "ok", LatencyMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				result.Status = "fail"
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			resp.Checks[name] = result
+			if err != nil {
+				resp.Status = "fail"
+			}
+		}()
+	}
+	wg.Wait()
+
+	if resp.Status != "ok" {
+		slog.WarnContext(c.Request.Context(), "Readiness check failed", "checks", resp.Checks)
+		c.JSON(http.StatusServiceUnavailable, resp)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// checkMembership verifies that every node the cluster knows about is
+// connected and that the cluster has as many nodes as are configured.
+func checkMembership(ctx context.Context) error {
+	m, err := client.Membership(ctx)
+	if err != nil {
+		return err
+	}
+	all, cluster := slices.Clone(m.AllNodes), slices.Clone(m.ClusterNodes)
+	slices.Sort(all)
+	slices.Sort(cluster)
+	if !slices.Equal(all, cluster) {
+		return fmt.Errorf("connected nodes %v do not match cluster nodes %v", all, cluster)
+	}
+	if len(cluster) != len(couchNodes) {
+		return fmt.Errorf("cluster has %d nodes, %d are configured", len(cluster), len(couchNodes))
+	}
+	return nil
+}
+
+// checkDatabase verifies that the student database exists.
+func checkDatabase(ctx context.Context) error {
+	exists, err := client.DBExists(ctx, defaultDB)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.New("database " + defaultDB + " does not exist")
+	}
+	return nil
+}
+
+// checkDesignDoc verifies that the design document id exists in the student
+// database.
+func checkDesignDoc(ctx context.Context, id string) error {
+	_, err := client.DB(defaultDB).GetRev(ctx, id)
+	return err
+}
+
+// prepareDatabases creates the databases the API needs, retrying until
+// CouchDB is reachable or ctx is done. Until then /readyz reports the service
+// as not ready.
+func prepareDatabases(ctx context.Context, names ...string) {
+	for delay := time.Second; ; delay = min(2*delay, time.Minute) {
+		var failed bool
+		for _, name := range names {
+			if err := ensureDatabase(ctx, name); err != nil {
+				slog.Error("Failed to prepare database", "db", name, "error", err, "retry_in", delay.String())
+				failed = true
+			}
+		}
+		if !failed {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
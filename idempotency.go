@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+const (
+	idempotencyDB     = "idempotency_keys"
+	idempotencyHeader = "Idempotency-Key"
+	// idempotencyKeyMax is the longest key accepted. Clients usually send a
+	// UUID.
+	idempotencyKeyMax = 255
+	// idempotencyPending is how long a key stays reserved for a request that
+	// has not finished, after which the instance that served it is assumed
+	// gone and a retry runs the request again.
+	idempotencyPending = 5 * time.Minute
+)
+
+// idempotencyTTL is how long a key and its stored response are kept.
+var idempotencyTTL = time.Duration(envInt("IDEMPOTENCY_TTL_HOURS", 24)) * time.Hour
+
+// idempotencyMaxBody is the largest request and response body of a request
+// with a key that is held in memory. Larger requests, such as uploads, are
+// spooled to a temporary file while they are fingerprinted and served;
+// larger responses are not stored, so a retry runs the request again.
+var idempotencyMaxBody = int64(envInt("IDEMPOTENCY_MAX_BODY_KB", 1024)) * 1024
+
+// replayedHeaders are the response headers stored with a key and sent again
+// on replay.
+var replayedHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// idempotencyRecord is the CouchDB representation of a key: the request it
+// was first used for and, once that finished, its response.
+type idempotencyRecord struct {
+	ID          string            `json:"_id"`
+	Rev         string            `json:"_rev,omitempty"`
+	Fingerprint string            `json:"fingerprint"`
+	State       string            `json:"state"` // pending or done
+	Status      int               `json:"status,omitempty"`
+	Header      map[string]string `json:"header,omitempty"`
+	Body        []byte            `json:"body,omitempty"`
+	CreatedAt   string            `json:"created_at"`
+	ExpiresAt   string            `json:"expires_at"`
+}
+
+func (r *idempotencyRecord) expired(now time.Time) bool {
+	expires, err := time.Parse(time.RFC3339, r.ExpiresAt)
+	if err != nil || now.After(expires) {
+		return true
+	}
+	created, err := time.Parse(time.RFC3339, r.CreatedAt)
+	return r.State == "pending" && (err != nil || now.Sub(created) > idempotencyPending)
+}
+
+// idempotencyDocID scopes key to the tenant and to the client of the
+// request, so that clients cannot replay each other's responses. The client
+// is the admin user, the subject of a verified bearer token or, failing
+// those, the API key or address used for rate limits.
+func idempotencyDocID(c *gin.Context, key string) string {
+	caller := actor(c)
+	if sub := tokenSubject(c); sub != "" && c.GetString(gin.AuthUserKey) == "" {
+		caller = "sub:" + sub
+	}
+	sum := sha256.Sum256([]byte(caller + "\x00" + tenantOf(c) + "\x00" + key))
+	return "key:" + hex.EncodeToString(sum[:])
+}
+
+// requestFingerprint hashes the route and payload of a request, reading body
+// from its start. The parts of a multipart form are hashed rather than the
+// raw body, since a retry sends them with another boundary.
+func requestFingerprint(c *gin.Context, body io.ReadSeeker) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s?%s\n", c.Request.Method, c.Request.URL.Path, c.Request.URL.RawQuery)
+	mediaType, params, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		if sum, err := multipartDigest(body, params["boundary"]); err == nil {
+			h.Write(sum)
+			return hex.EncodeToString(h.Sum(nil)), nil
+		}
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+	}
+	fmt.Fprintf(h, "%s\n", mediaType)
+	if _, err := io.Copy(h, body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func multipartDigest(body io.Reader, boundary string) ([]byte, error) {
+	h := sha256.New()
+	mr := multipart.NewReader(body, boundary)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return h.Sum(nil), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(h, "%q %q\n", part.FormName(), part.FileName())
+		if _, err := io.Copy(h, part); err != nil {
+			return nil, err
+		}
+		h.Write([]byte{0})
+	}
+}
+
+// spoolBody copies body so that it can be read more than once: into memory
+// up to idempotencyMaxBody, and into a temporary file beyond that. cleanup
+// removes the file. Failures to write the file are *fs.PathErrors.
+func spoolBody(body io.Reader) (r io.ReadSeeker, cleanup func(), err error) {
+	buf, err := io.ReadAll(io.LimitReader(body, idempotencyMaxBody+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if int64(len(buf)) <= idempotencyMaxBody {
+		return bytes.NewReader(buf), func() {}, nil
+	}
+	f, err := os.CreateTemp("", "idempotency-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup = func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	if _, err = f.Write(buf); err == nil {
+		_, err = io.Copy(f, body)
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return f, cleanup, nil
+}
+
+// responseRecorder keeps a copy of the response body written through it, up
+// to idempotencyMaxBody bytes.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+	// overflow tells whether the body was larger.
+	overflow bool
+}
+
+func (w *responseRecorder) keep(b []byte) {
+	if w.overflow || int64(w.body.Len()+len(b)) > idempotencyMaxBody {
+		w.overflow = true
+		w.body.Reset()
+		return
+	}
+	w.body.Write(b)
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.keep(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.keep([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// idempotency makes mutating requests that carry an Idempotency-Key safe to
+// retry. The first request with a key reserves it and stores its response;
+// a retry gets that response again, with Idempotent-Replayed set, instead of
+// running a second time. A key reused for another payload is refused with
+// 422, and one whose request is still running with 409. Server errors, rate
+// limiting and responses over idempotencyMaxBody are not stored, so a retry
+// after them runs the request again.
+func idempotency(c *gin.Context) {
+	key := c.GetHeader(idempotencyHeader)
+	if key == "" || c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+		c.Next()
+		return
+	}
+	if len(key) > idempotencyKeyMax {
+		respondProblem(c, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("%s must be at most %d characters.", idempotencyHeader, idempotencyKeyMax))
+		return
+	}
+	body, cleanup, err := spoolBody(c.Request.Body)
+	var pathErr *fs.PathError
+	switch {
+	case errors.As(err, &pathErr):
+		logError(c, "Failed to spool request body", err)
+		respondProblem(c, http.StatusInternalServerError, codeInternal, "Failed to spool request body.")
+		return
+	case err != nil:
+		respondProblem(c, http.StatusBadRequest, codeInvalidRequest, "Failed to read request body.")
+		return
+	}
+	defer cleanup()
+	fingerprint, err := requestFingerprint(c, body)
+	if err == nil {
+		_, err = body.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		logError(c, "Failed to fingerprint request", err)
+		respondProblem(c, http.StatusInternalServerError, codeInternal, "Failed to fingerprint request.")
+		return
+	}
+	c.Request.Body = io.NopCloser(body)
+
+	ctx := c.Request.Context()
+	now := time.Now().UTC()
+	db := client.DB(idempotencyDB)
+	record := idempotencyRecord{
+		ID:          idempotencyDocID(c, key),
+		Fingerprint: fingerprint,
+		State:       "pending",
+		CreatedAt:   now.Format(time.RFC3339),
+		ExpiresAt:   now.Add(idempotencyTTL).Format(time.RFC3339),
+	}
+	start := time.Now()
+	rev, err := db.Put(ctx, record.ID, record)
+	observeCouch("Put", start, err)
+	if kivik.HTTPStatus(err) == http.StatusConflict {
+		var stored idempotencyRecord
+		start = time.Now()
+		err = db.Get(ctx, record.ID).ScanDoc(&stored)
+		observeCouch("Get", start, err)
+		switch {
+		case err != nil:
+		case stored.expired(now):
+			record.Rev = stored.Rev
+			start = time.Now()
+			rev, err = db.Put(ctx, record.ID, record)
+			observeCouch("Put", start, err)
+			if kivik.HTTPStatus(err) == http.StatusConflict {
+				// Another retry took the key over first.
+				idempotencyOutcomes.WithLabelValues("in_progress").Inc()
+				respondProblem(c, http.StatusConflict, codeRequestInProgress, "A request with this Idempotency-Key is in progress.")
+				return
+			}
+		case stored.Fingerprint != record.Fingerprint:
+			idempotencyOutcomes.WithLabelValues("mismatch").Inc()
+			respondProblem(c, http.StatusUnprocessableEntity, codeIdempotencyKeyReused, "Idempotency-Key was already used for a different request.")
+			return
+		case stored.State == "pending":
+			idempotencyOutcomes.WithLabelValues("in_progress").Inc()
+			c.Header("Retry-After", "1")
+			respondProblem(c, http.StatusConflict, codeRequestInProgress, "A request with this Idempotency-Key is in progress.")
+			return
+		default:
+			idempotencyOutcomes.WithLabelValues("replayed").Inc()
+			for name, value := range stored.Header {
+				c.Header(name, value)
+			}
+			c.Header("Idempotent-Replayed", "true")
+			c.Status(stored.Status)
+			c.Writer.Write(stored.Body)
+			c.Abort()
+			return
+		}
+	}
+	if err != nil {
+		respondCouchError(c, "Failed to reserve idempotency key", err)
+		return
+	}
+	record.Rev = rev
+	idempotencyOutcomes.WithLabelValues("stored").Inc()
+
+	recorder := &responseRecorder{ResponseWriter: c.Writer}
+	c.Writer = recorder
+	c.Next()
+
+	// The request may have run out of time; its outcome is stored regardless.
+	ctx = context.WithoutCancel(ctx)
+	status := recorder.Status()
+	if status >= http.StatusInternalServerError || status == http.StatusTooManyRequests || recorder.overflow {
+		start = time.Now()
+		_, err = db.Delete(ctx, record.ID, record.Rev)
+		observeCouch("Delete", start, err)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to release idempotency key", "error", err)
+		}
+		return
+	}
+	record.State = "done"
+	record.Status = status
+	record.Body = recorder.body.Bytes()
+	record.Header = map[string]string{}
+	for _, name := range replayedHeaders {
+		if value := recorder.Header().Get(name); value != "" {
+			record.Header[name] = value
+		}
+	}
+	start = time.Now()
+	_, err = db.Put(ctx, record.ID, record)
+	observeCouch("Put", start, err)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to store idempotent response", "error", err)
+	}
+}
+
+// purgeIdempotencyKeys deletes expired keys every interval.
+func purgeIdempotencyKeys(ctx context.Context, interval time.Duration) {
+	db := client.DB(idempotencyDB)
+	every(ctx, interval, func(now time.Time) {
+		err := db.CreateIndex(ctx, "idempotency", "by-expires-at", map[string]interface{}{"fields": []string{"expires_at"}})
+		if err != nil {
+			slog.Warn("Failed to create idempotency key index", "error", err)
+			return
+		}
+		start := time.Now()
+		rs := db.Find(ctx, map[string]interface{}{
+			"selector": map[string]interface{}{"expires_at": map[string]interface{}{"$lt": now.UTC().Format(time.RFC3339)}},
+			"fields":   []string{"_id", "_rev"},
+			"limit":    1000,
+		})
+		defer rs.Close()
+		var docs []interface{}
+		for rs.Next() {
+			var doc map[string]interface{}
+			if err := rs.ScanDoc(&doc); err != nil {
+				break
+			}
+			doc["_deleted"] = true
+			docs = append(docs, doc)
+		}
+		err = rs.Err()
+		observeCouch("Find", start, err)
+		if err != nil || len(docs) == 0 {
+			if err != nil {
+				slog.Warn("Failed to find expired idempotency keys", "error", err)
+			}
+			return
+		}
+		start = time.Now()
+		_, err = db.BulkDocs(ctx, docs)
+		observeCouch("BulkDocs", start, err)
+		if err != nil {
+			slog.Warn("Failed to delete expired idempotency keys", "error", err)
+			return
+		}
+		slog.Info("Deleted expired idempotency keys", "keys", len(docs))
+	})
+}
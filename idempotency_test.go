@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIdempotencyDocIDIsScopedToTheClient(t *testing.T) {
+	defer func(secret string) { tenantTokenSecret = secret }(tenantTokenSecret)
+	tenantTokenSecret = "secret"
+
+	docID := func(remoteAddr, authorization, user string) string {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/students", nil)
+		c.Request.RemoteAddr = remoteAddr
+		if authorization != "" {
+			c.Request.Header.Set("Authorization", authorization)
+		}
+		if user != "" {
+			c.Set(gin.AuthUserKey, user)
+		}
+		return idempotencyDocID(c, "key-1")
+	}
+	ann := signToken(t, "HS256", "secret", map[string]interface{}{"sub": "ann"})
+	bob := signToken(t, "HS256", "secret", map[string]interface{}{"sub": "bob"})
+
+	if docID("192.0.2.1:1000", "", "") == docID("192.0.2.2:1000", "", "") {
+		t.Error("anonymous clients at different addresses share keys")
+	}
+	if docID("192.0.2.1:1000", ann, "") == docID("192.0.2.1:1000", bob, "") {
+		t.Error("different token subjects share keys")
+	}
+	if docID("192.0.2.1:1000", ann, "") != docID("192.0.2.2:1000", ann, "") {
+		t.Error("a token subject retrying from another address gets another key")
+	}
+	if docID("192.0.2.1:1000", "", "admin") != docID("192.0.2.2:1000", "", "admin") {
+		t.Error("an admin retrying from another address gets another key")
+	}
+}
+
+func TestIdempotencySpoolsLargeBodies(t *testing.T) {
+	newFakeCouch(t, idempotencyDB)
+	defer func(max int64) { idempotencyMaxBody = max }(idempotencyMaxBody)
+	idempotencyMaxBody = 1024
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	runs := 0
+	r.POST("/upload", idempotency, func(c *gin.Context) {
+		runs++
+		b, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		c.JSON(http.StatusCreated, gin.H{"size": len(b)})
+	})
+	upload := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set(idempotencyHeader, "upload-1")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	body := strings.Repeat("x", 4096)
+	first := upload(body)
+	if first.Code != http.StatusCreated || first.Body.String() != `{"size":4096}` {
+		t.Fatalf("first upload: %d %s", first.Code, first.Body)
+	}
+	retry := upload(body)
+	if retry.Code != http.StatusCreated || retry.Header().Get("Idempotent-Replayed") != "true" || runs != 1 {
+		t.Errorf("retry: %d, replayed %q, runs %d", retry.Code, retry.Header().Get("Idempotent-Replayed"), runs)
+	}
+	if w := upload(body + "y"); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("other body with the same key: status %d, want 422", w.Code)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// idPrefixes are prepended to the generated IDs of documents by type, from
+// ID_PREFIXES (e.g. "student=stu,course=crs").
+var idPrefixes = envMap("ID_PREFIXES")
+
+type CreatedResponse struct {
+	Message string `json:"message"`
+	ID      string `json:"id"`
+	Rev     string `json:"rev"`
+}
+
+// newDocumentID returns a UUIDv7, which sorts by creation time, prefixed for
+// docType if a prefix is configured.
+func newDocumentID(docType string) (string, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return "", err
+	}
+	if prefix := idPrefixes[docType]; prefix != "" {
+		return prefix + "_" + id.String(), nil
+	}
+	return id.String(), nil
+}
+
+// createDocumentHandler creates a document with an ID from newDocumentID
+// and answers with its Location. It serves POST /v1/students.
+func createDocumentHandler(c *gin.Context) {
+	var doc map[string]interface{}
+	if err := c.ShouldBindJSON(&doc); err != nil || doc == nil {
+		respondProblem(c, http.StatusBadRequest, codeInvalidRequest, "Document must be a JSON object")
+		return
+	}
+	if _, ok := doc["_id"]; ok {
+		respondProblem(c, http.StatusBadRequest, codeInvalidRequest, "Document must not contain '_id'; its ID is generated")
+		return
+	}
+	delete(doc, "_rev")
+	id, rev, err := studentsOf(c).create(c.Request.Context(), doc)
+	if err != nil {
+		respondRepoError(c, "Failed to create document", err, "doc_id", id)
+		return
+	}
+
+	c.Header("Location", documentPath(c, id))
+	c.JSON(http.StatusCreated, CreatedResponse{Message: "Document created", ID: id, Rev: rev})
+}
@@ -0,0 +1,611 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+// Conflict policies of an import.
+const (
+	// importSkip leaves documents that already exist untouched.
+	importSkip = "skip"
+	// importOverwrite replaces existing documents with the imported version.
+	importOverwrite = "overwrite"
+	// importKeepRevs writes the exported revisions as they are
+	// (new_edits=false), so the revision history matches the source.
+	importKeepRevs = "keep-revs"
+)
+
+type importOptions struct {
+	Policy    string
+	BatchSize int
+	// ResumeAfter skips the batches up to and including this one, as
+	// reported by a previous, interrupted import.
+	ResumeAfter int
+}
+
+// ImportProgress is reported after every batch, with counts for the whole
+// import so far.
+type ImportProgress struct {
+	Batch   int `json:"batch"`
+	Docs    int `json:"docs"`
+	Written int `json:"written"`
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+	// MissingAttachments counts attachment stubs that had no data in the
+	// export and no file in the attachment tar. They are dropped.
+	MissingAttachments int `json:"missing_attachments"`
+	// Errors lists the documents of this batch that could not be written.
+	Errors []string `json:"errors,omitempty"`
+	// Rejected lists the documents of this batch that were refused before
+	// writing. They count as failed.
+	Rejected []ImportRejection `json:"rejected,omitempty"`
+	Done     bool              `json:"done"`
+}
+
+// ImportRejection is a document an import refused, with the problem code an
+// insert of it would have been answered with.
+type ImportRejection struct {
+	// Line is the line of the document in the NDJSON input.
+	Line       int               `json:"line"`
+	DocID      string            `json:"doc_id"`
+	Code       string            `json:"code"`
+	Detail     string            `json:"detail"`
+	Violations []SchemaViolation `json:"violations,omitempty"`
+	// Field and Owner are the unique field and the document already holding
+	// its value, for a unique_violation.
+	Field string `json:"field,omitempty"`
+	Owner string `json:"owner,omitempty"`
+}
+
+// importDoc is a document of an import and its line in the input.
+type importDoc struct {
+	line int
+	doc  map[string]interface{}
+}
+
+// attachmentTar hands out the files of an export's attachment tar. The export
+// writes each document's files right after the previous document's, so the
+// tar is read in step with the NDJSON stream.
+type attachmentTar struct {
+	tr   *tar.Reader
+	next *tar.Header
+	err  error
+}
+
+func newAttachmentTar(r io.Reader) *attachmentTar {
+	a := &attachmentTar{tr: tar.NewReader(r)}
+	a.advance()
+	return a
+}
+
+func (a *attachmentTar) advance() {
+	a.next, a.err = a.tr.Next()
+	if a.err == io.EOF {
+		a.err = nil
+	}
+}
+
+// take returns the files of docID, keyed by attachment name. Entries are
+// named as attachmentEntry names them, so the document ID is the first,
+// escaped, segment.
+func (a *attachmentTar) take(docID string) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	for a.err == nil && a.next != nil {
+		entryID, name, ok := strings.Cut(a.next.Name, "/")
+		if !ok {
+			return nil, fmt.Errorf("tar entry %q is not named <doc ID>/<file name>", a.next.Name)
+		}
+		if id, err := url.PathUnescape(entryID); err != nil || id != docID {
+			break
+		}
+		if name, err := url.PathUnescape(name); err == nil {
+			data, err := io.ReadAll(a.tr)
+			if err != nil {
+				return nil, err
+			}
+			files[name] = data
+		}
+		a.advance()
+	}
+	return files, a.err
+}
+
+// importInputError is an import failing on its input rather than on
+// CouchDB, so that the reason can be shown to the client.
+type importInputError struct{ err error }
+
+func (e *importInputError) Error() string { return e.err.Error() }
+func (e *importInputError) Unwrap() error { return e.err }
+
+// importDocs writes the NDJSON documents read from r into the database of
+// repo in _bulk_docs batches, auditing them as repo's caller. atts may be
+// nil. progress is called after every batch.
+func importDocs(ctx context.Context, repo studentRepo, r io.Reader, atts *attachmentTar, opts importOptions, progress func(ImportProgress) error) error {
+	if err := checkImportPolicy(opts.Policy); err != nil {
+		return err
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	total := ImportProgress{}
+	var batch []importDoc
+	flush := func() error {
+		total.Batch++
+		if total.Batch > opts.ResumeAfter {
+			p, err := importBatch(ctx, repo, batch, opts.Policy)
+			if err != nil {
+				return fmt.Errorf("batch %d: %w", total.Batch, err)
+			}
+			total.Docs += p.Docs
+			total.Written += p.Written
+			total.Skipped += p.Skipped
+			total.Failed += p.Failed
+			total.Errors = p.Errors
+			total.Rejected = p.Rejected
+		}
+		batch = batch[:0]
+		if err := progress(total); err != nil {
+			return err
+		}
+		total.Errors, total.Rejected = nil, nil
+		return nil
+	}
+
+	for line := 1; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var doc map[string]interface{}
+		dec := json.NewDecoder(bytes.NewReader(sc.Bytes()))
+		dec.UseNumber()
+		if err := dec.Decode(&doc); err != nil {
+			return &importInputError{fmt.Errorf("line %d: %w", line, err)}
+		}
+		if _, ok := doc["_export"]; ok {
+			continue
+		}
+		id, _ := doc["_id"].(string)
+		if id == "" {
+			return &importInputError{fmt.Errorf("line %d: document has no _id", line)}
+		}
+
+		missing, err := fillAttachments(doc, id, atts)
+		if err != nil {
+			return &importInputError{fmt.Errorf("attachments of %s: %w", id, err)}
+		}
+		total.MissingAttachments += missing
+
+		batch = append(batch, importDoc{line: line, doc: doc})
+		if len(batch) == opts.BatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return &importInputError{err}
+	}
+	if len(batch) > 0 {
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+	total.Done = true
+	return progress(total)
+}
+
+// checkImportPolicy reports whether policy is a known conflict policy.
+func checkImportPolicy(policy string) error {
+	switch policy {
+	case importSkip, importOverwrite, importKeepRevs:
+		return nil
+	}
+	return fmt.Errorf("unknown conflict policy %q", policy)
+}
+
+// fillAttachments replaces the attachment stubs of doc with inline data from
+// atts. Stubs without data are dropped and counted.
+func fillAttachments(doc map[string]interface{}, id string, atts *attachmentTar) (missing int, err error) {
+	stubs, _ := doc["_attachments"].(map[string]interface{})
+	if len(stubs) == 0 {
+		return 0, nil
+	}
+	var files map[string][]byte
+	if atts != nil {
+		if files, err = atts.take(id); err != nil {
+			return 0, err
+		}
+	}
+	for name, v := range stubs {
+		stub, _ := v.(map[string]interface{})
+		if _, ok := stub["data"]; ok {
+			continue
+		}
+		data, ok := files[name]
+		if !ok {
+			delete(stubs, name)
+			missing++
+			continue
+		}
+		stubs[name] = map[string]interface{}{
+			"content_type": stub["content_type"],
+			"data":         base64.StdEncoding.EncodeToString(data),
+		}
+	}
+	if len(stubs) == 0 {
+		delete(doc, "_attachments")
+	}
+	return missing, nil
+}
+
+// importBatch writes one batch with the given conflict policy. Documents
+// that do not match their schema or hold a value of a unique field that
+// another document holds are rejected. Every document written is audited
+// as an import and dropped from the document cache.
+func importBatch(ctx context.Context, repo studentRepo, lines []importDoc, policy string) (ImportProgress, error) {
+	db := client.DB(repo.db)
+	p := ImportProgress{Docs: len(lines)}
+	var batch []map[string]interface{}
+	claims := map[string]*uniqueClaim{}
+	abort := func() {
+		for _, claim := range claims {
+			claim.abort(ctx)
+		}
+	}
+	for _, d := range lines {
+		claim, rejection, err := admitImportDoc(ctx, db.Name(), d)
+		if err != nil {
+			abort()
+			return p, err
+		}
+		if rejection != nil {
+			p.Failed++
+			p.Rejected = append(p.Rejected, *rejection)
+			continue
+		}
+		if claim != nil {
+			claims[d.doc["_id"].(string)] = claim
+		}
+		batch = append(batch, d.doc)
+	}
+	if len(batch) == 0 {
+		return p, nil
+	}
+	docs := make([]interface{}, len(batch))
+	var opts kivik.Options
+	var current map[string]string
+
+	switch policy {
+	case importKeepRevs:
+		opts = kivik.Options{"new_edits": false}
+		for i, doc := range batch {
+			docs[i] = doc
+		}
+	case importSkip, importOverwrite:
+		if policy == importOverwrite {
+			var err error
+			if current, err = currentRevs(ctx, db, batch); err != nil {
+				abort()
+				return p, err
+			}
+		}
+		for i, doc := range batch {
+			delete(doc, "_revisions")
+			delete(doc, "_rev")
+			if rev, ok := current[doc["_id"].(string)]; ok {
+				doc["_rev"] = rev
+			}
+			docs[i] = doc
+		}
+	}
+
+	start := time.Now()
+	results, err := db.BulkDocs(ctx, docs, opts)
+	observeCouch("BulkDocs", start, err)
+	if err != nil {
+		abort()
+		return p, err
+	}
+
+	// With new_edits=false CouchDB only reports failures.
+	p.Written = len(batch)
+	failed := map[string]bool{}
+	newRevs := map[string]string{}
+	for _, r := range results {
+		if r.Error == nil {
+			newRevs[r.ID] = r.Rev
+			continue
+		}
+		failed[r.ID] = true
+		if claim, ok := claims[r.ID]; ok {
+			claim.abort(ctx)
+			delete(claims, r.ID)
+		}
+		p.Written--
+		if kivik.HTTPStatus(r.Error) == http.StatusConflict && policy == importSkip {
+			p.Skipped++
+			continue
+		}
+		p.Failed++
+		p.Errors = append(p.Errors, r.ID+": "+r.Error.Error())
+	}
+	for _, claim := range claims {
+		claim.commit(ctx)
+	}
+
+	var audits []AuditRecord
+	for _, doc := range batch {
+		id := doc["_id"].(string)
+		if failed[id] {
+			continue
+		}
+		documentCache.invalidate(repo.db, id, "")
+		newRev, ok := newRevs[id]
+		if !ok {
+			newRev, _ = doc["_rev"].(string)
+		}
+		after := doc
+		if deleted, _ := doc["_deleted"].(bool); deleted {
+			after = nil
+		}
+		audits = append(audits, repo.auditRecord(ctx, "import", id, nil, after, current[id], newRev))
+	}
+	storeAudit(ctx, audits...)
+	return p, nil
+}
+
+// admitImportDoc validates a document of an import against its schema and
+// claims the values of its unique fields in student database db, like an
+// insert. Tombstones and design documents are admitted as they are. The
+// document already in the database is not consulted: locks it holds for
+// values the import drops are left behind and taken over when claimed.
+func admitImportDoc(ctx context.Context, db string, d importDoc) (*uniqueClaim, *ImportRejection, error) {
+	id, _ := d.doc["_id"].(string)
+	if deleted, _ := d.doc["_deleted"].(bool); deleted || strings.HasPrefix(id, "_design/") {
+		return nil, nil, nil
+	}
+	err := checkSchema(ctx, d.doc)
+	var se *schemaError
+	if errors.As(err, &se) {
+		return nil, &ImportRejection{
+			Line:       d.line,
+			DocID:      id,
+			Code:       codeValidationFailed,
+			Detail:     "Document does not match its schema.",
+			Violations: se.violations,
+		}, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	claim, err := claimUnique(ctx, db, id, nil, d.doc)
+	var ue *uniqueError
+	if errors.As(err, &ue) {
+		return nil, &ImportRejection{
+			Line:   d.line,
+			DocID:  id,
+			Code:   codeUniqueViolation,
+			Detail: fmt.Sprintf("The value of %s is already used.", ue.field),
+			Field:  ue.field,
+			Owner:  ue.owner,
+		}, nil
+	}
+	return claim, nil, err
+}
+
+// currentRevs returns the current revision of every live document of batch
+// that already exists in db.
+func currentRevs(ctx context.Context, db *kivik.DB, batch []map[string]interface{}) (map[string]string, error) {
+	keys := make([]string, len(batch))
+	for i, doc := range batch {
+		keys[i] = doc["_id"].(string)
+	}
+	var result struct {
+		Rows []struct {
+			ID    string `json:"id"`
+			Value struct {
+				Rev     string `json:"rev"`
+				Deleted bool   `json:"deleted"`
+			} `json:"value"`
+		} `json:"rows"`
+	}
+	err := couchDo(ctx, couchHTTP, http.MethodPost, couchEndpoint(db.Name()+"/_all_docs"),
+		map[string]interface{}{"keys": keys}, &result)
+	if err != nil {
+		return nil, err
+	}
+	revs := make(map[string]string, len(result.Rows))
+	for _, row := range result.Rows {
+		if row.ID != "" && !row.Value.Deleted {
+			revs[row.ID] = row.Value.Rev
+		}
+	}
+	return revs, nil
+}
+
+// runImport implements the "import" command. Progress goes to stderr and the
+// last completed batch to the checkpoint file, which a rerun resumes from.
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	in := fs.String("i", "-", "NDJSON input file, - for stdin")
+	tarFile := fs.String("attachments-tar", "", "attachment tar written by export -attachments-tar")
+	policy := fs.String("policy", importSkip, "conflict policy: skip, overwrite or keep-revs")
+	batchSize := fs.Int("batch-size", 500, "documents per _bulk_docs request")
+	checkpoint := fs.String("checkpoint", "", "file recording the last completed batch; an existing one is resumed from")
+	dbName := fs.String("db", "student", "database to import into")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := checkImportPolicy(*policy); err != nil {
+		return err
+	}
+	if err := connectCouchDB(); err != nil {
+		return err
+	}
+
+	r := io.Reader(os.Stdin)
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	var atts *attachmentTar
+	if *tarFile != "" {
+		f, err := os.Open(*tarFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		atts = newAttachmentTar(f)
+	}
+
+	opts := importOptions{Policy: *policy, BatchSize: *batchSize}
+	if *checkpoint != "" {
+		if b, err := os.ReadFile(*checkpoint); err == nil {
+			opts.ResumeAfter, _ = strconv.Atoi(strings.TrimSpace(string(b)))
+			fmt.Fprintf(os.Stderr, "resuming after batch %d\n", opts.ResumeAfter)
+		}
+	}
+
+	ctx := context.Background()
+	for _, name := range []string{*dbName, lockDBName(*dbName)} {
+		if err := ensureDatabase(ctx, name); err != nil {
+			return err
+		}
+	}
+	repo := studentRepo{caller{db: *dbName, actor: "cli", method: "CLI", route: "import"}}
+	return importDocs(ctx, repo, r, atts, opts, func(p ImportProgress) error {
+		for _, e := range p.Errors {
+			fmt.Fprintln(os.Stderr, "  failed:", e)
+		}
+		for _, r := range p.Rejected {
+			fmt.Fprintf(os.Stderr, "  rejected: line %d, %s: %s %s\n", r.Line, r.DocID, r.Code, r.Detail)
+			for _, v := range r.Violations {
+				fmt.Fprintf(os.Stderr, "    %s: %s\n", v.Path, v.Message)
+			}
+			if r.Owner != "" {
+				fmt.Fprintf(os.Stderr, "    held by %s\n", r.Owner)
+			}
+		}
+		if p.Done {
+			fmt.Fprintf(os.Stderr, "done: %d written, %d skipped, %d failed, %d attachments missing\n",
+				p.Written, p.Skipped, p.Failed, p.MissingAttachments)
+			if *checkpoint == "" {
+				return nil
+			}
+			if err := os.Remove(*checkpoint); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			return nil
+		}
+		fmt.Fprintf(os.Stderr, "batch %d: %d written, %d skipped, %d failed\n", p.Batch, p.Written, p.Skipped, p.Failed)
+		if *checkpoint == "" {
+			return nil
+		}
+		return os.WriteFile(*checkpoint, []byte(strconv.Itoa(p.Batch)), 0o644)
+	})
+}
+
+// importHandler godoc
+// @Summary Import documents from NDJSON
+// @Description Writes the documents of an NDJSON export into the tenant's student database in _bulk_docs batches. The body is
+// @Description either NDJSON or a multipart form with an NDJSON "file" and an optional "attachments" tar. Progress is
+// @Description streamed back as one ImportProgress line per batch; after an interruption, pass the last reported batch
+// @Description as resume_after. Documents that do not match their schema or reuse a value of a unique field are not
+// @Description written; they are listed under rejected with the line of the input. Every document written is recorded
+// @Description in the audit log as an import.
+// @Tags admin
+// @Accept application/x-ndjson,mpfd
+// @Produce application/x-ndjson
+// @Security BasicAuth
+// @Param tenant query string false "Tenant whose database is used; the default database without it"
+// @Param policy query string false "Conflict policy: skip, overwrite or keep-revs" default(skip)
+// @Param batch_size query int false "Documents per batch" default(500)
+// @Param resume_after query int false "Skip batches up to and including this one" default(0)
+// @Param file formData file false "NDJSON export"
+// @Param attachments formData file false "Attachment tar"
+// @Success 200 {array} ImportProgress
+// @Failure 400 {object} Problem "Invalid request"
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Admin routes are disabled"
+// @Router /admin/import [post]
+func importHandler(c *gin.Context) {
+	opts := importOptions{Policy: c.DefaultQuery("policy", importSkip)}
+	opts.BatchSize, _ = strconv.Atoi(c.DefaultQuery("batch_size", "500"))
+	opts.ResumeAfter, _ = strconv.Atoi(c.DefaultQuery("resume_after", "0"))
+	if err := checkImportPolicy(opts.Policy); err != nil {
+		respondProblem(c, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+
+	body := io.Reader(c.Request.Body)
+	var atts *attachmentTar
+	if mt, _, _ := mime.ParseMediaType(c.ContentType()); mt == "multipart/form-data" {
+		file, err := c.FormFile("file")
+		if err != nil {
+			respondProblem(c, http.StatusBadRequest, codeInvalidRequest, "Missing NDJSON file: "+err.Error())
+			return
+		}
+		f, err := file.Open()
+		if err != nil {
+			respondProblem(c, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		defer f.Close()
+		body = f
+
+		if tarHeader, err := c.FormFile("attachments"); err == nil {
+			t, err := tarHeader.Open()
+			if err != nil {
+				respondProblem(c, http.StatusBadRequest, codeInvalidRequest, err.Error())
+				return
+			}
+			defer t.Close()
+			atts = newAttachmentTar(t)
+		}
+	}
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+	enc := json.NewEncoder(c.Writer)
+	err := importDocs(c.Request.Context(), studentsOf(c), body, atts, opts, func(p ImportProgress) error {
+		if err := enc.Encode(p); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	})
+	// The status is already sent; the problem ends the progress stream.
+	var ie *importInputError
+	switch {
+	case errors.As(err, &ie):
+		enc.Encode(newProblem(c, http.StatusBadRequest, codeInvalidRequest, "Import failed: "+err.Error()))
+	case err != nil:
+		enc.Encode(newCouchProblem(c, "Import failed", err))
+	}
+}
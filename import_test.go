@@ -0,0 +1,58 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestImportMatchesAttachmentsByEscapedID(t *testing.T) {
+	f := newFakeCouch(t, defaultDB, lockDBName(defaultDB), auditDB, schemaDB)
+
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	for _, a := range []struct{ docID, name, data string }{
+		{"a", "x.txt", "of a"},
+		{"a/b", "y.txt", "of a/b"},
+	} {
+		entry, err := attachmentEntry(a.docID, a.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tw.WriteHeader(&tar.Header{Name: entry, Mode: 0o644, Size: int64(len(a.data))})
+		tw.Write([]byte(a.data))
+	}
+	tw.Close()
+	ndjson := `{"_id":"a","name":"A","_attachments":{"x.txt":{"content_type":"text/plain","stub":true}}}
+{"_id":"a/b","name":"B","_attachments":{"y.txt":{"content_type":"text/plain","stub":true}}}
+`
+
+	repo := studentRepo{caller{db: defaultDB, actor: "test", method: "CLI", route: "import"}}
+	var last ImportProgress
+	err := importDocs(context.Background(), repo, strings.NewReader(ndjson), newAttachmentTar(&archive),
+		importOptions{Policy: importSkip}, func(p ImportProgress) error { last = p; return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.Written != 2 || last.MissingAttachments != 0 {
+		t.Fatalf("progress = %+v, want 2 written and no missing attachments", last)
+	}
+	for id, want := range map[string]string{"a": "x.txt", "a/b": "y.txt"} {
+		atts, _ := f.doc(defaultDB, id)["_attachments"].(map[string]interface{})
+		if _, ok := atts[want]; len(atts) != 1 || !ok {
+			t.Errorf("attachments of %s = %v, want only %s", id, atts, want)
+		}
+	}
+
+	var audits []string
+	for _, d := range f.dbs[auditDB] {
+		if doc := d.render(false, false); doc["action"] == "import" {
+			audits = append(audits, doc["doc_id"].(string))
+		}
+	}
+	if len(audits) != 2 {
+		t.Errorf("import audit records of %v, want a and a/b", audits)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	listenAddr = envString("LISTEN_ADDR", ":8080")
+	// shutdownTimeout bounds how long in-flight requests are drained, and
+	// then how long workers get to stop.
+	shutdownTimeout = time.Duration(envInt("SHUTDOWN_TIMEOUT_SECONDS", 30)) * time.Second
+	// defaultRouteTimeout is the deadline of requests to routes without an
+	// entry in routeTimeouts.
+	defaultRouteTimeout = time.Duration(envInt("REQUEST_TIMEOUT_SECONDS", 10)) * time.Second
+)
+
+// routeTimeouts are the request deadlines of routes that stream or process
+// whole databases, by gin route. ROUTE_TIMEOUTS overrides and extends them
+// with route=duration pairs, e.g. "/documents=2m,/admin/export=0"; 0 means
+// no deadline.
+var routeTimeouts = func() map[string]time.Duration {
+	timeouts := map[string]time.Duration{
+		"/documents":                         5 * time.Minute,
+		"/file/:docID/:filename":             5 * time.Minute,
+		"/upload":                            5 * time.Minute,
+		"/import/csv":                        5 * time.Minute,
+		"/import/xlsx":                       5 * time.Minute,
+		"/v1/students":                       5 * time.Minute,
+		"/v1/students/:id/attachments":       5 * time.Minute,
+		"/v1/students/:id/attachments/:name": 5 * time.Minute,
+		"/v1/students/import/csv":            5 * time.Minute,
+		"/v1/students/import/xlsx":           5 * time.Minute,
+		"/admin/export":                      0,
+		"/admin/export/attachments":          0,
+		"/admin/import":                      0,
+	}
+	for route, value := range envMap("ROUTE_TIMEOUTS") {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			slog.Warn("Ignoring invalid route timeout", "route", route, "value", value)
+			continue
+		}
+		timeouts[route] = d
+	}
+	return timeouts
+}()
+
+// routeDeadline puts the deadline of the matched route on the request
+// context, which every CouchDB call of the handler is made with. The
+// context is also cancelled when the client goes away.
+func routeDeadline(c *gin.Context) {
+	d, ok := routeTimeouts[c.FullPath()]
+	if !ok {
+		d = defaultRouteTimeout
+	}
+	if d <= 0 {
+		c.Next()
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), d)
+	defer cancel()
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		slog.WarnContext(ctx, "Request deadline exceeded", "route", c.FullPath(), "timeout", d.String())
+	}
+}
+
+// worker is a background task that runs until its context is cancelled.
+type worker struct {
+	name   string
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// workers are the background tasks of the server.
+type workers []*worker
+
+// start runs fn in the background.
+func (ws *workers) start(name string, fn func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &worker{name: name, cancel: cancel, done: make(chan struct{})}
+	*ws = append(*ws, w)
+	go func() {
+		defer close(w.done)
+		fn(ctx)
+	}()
+}
+
+// stop stops the workers one at a time, the last started first, waiting for
+// each to return until ctx is done.
+func (ws workers) stop(ctx context.Context) {
+	for i := len(ws) - 1; i >= 0; i-- {
+		w := ws[i]
+		w.cancel()
+		select {
+		case <-w.done:
+			slog.Info("Worker stopped", "worker", w.name)
+		case <-ctx.Done():
+			slog.Warn("Worker did not stop in time", "worker", w.name)
+		}
+	}
+}
+
+// every calls fn every interval until ctx is done.
+func every(ctx context.Context, interval time.Duration, fn func(now time.Time)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			fn(now)
+		}
+	}
+}
+
+// runServer serves handler on listenAddr until ctx is done, then drains
+// in-flight requests for up to shutdownTimeout before closing the remaining
+// connections.
+func runServer(ctx context.Context, handler http.Handler) error {
+	srv := &http.Server{
+		Addr:              listenAddr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errc := make(chan error, 1)
+	go func() {
+		slog.Info("Listening", "addr", listenAddr)
+		errc <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	slog.Info("Shutting down, draining requests", "timeout", shutdownTimeout.String())
+	drainCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(drainCtx); err != nil {
+		slog.Warn("Requests still running at shutdown timeout, closing connections", "error", err)
+		srv.Close()
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type requestIDKey struct{}
+
+// contextHandler adds the request and trace IDs carried by the context to
+// every record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := requestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// setupLogging installs a JSON logger writing to w as the slog default.
+// LOG_LEVEL selects the minimum level (debug, info, warn or error).
+func setupLogging(w io.Writer) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(envString("LOG_LEVEL", "info"))); err != nil {
+		level = slog.LevelInfo
+	}
+	handler := slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})
+	slog.SetDefault(slog.New(contextHandler{handler}))
+}
+
+// fatal logs msg with args and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// requestID returns the request ID carried by ctx, if any.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// newRequestID returns a random 128-bit hex identifier.
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestIDMiddleware propagates the caller's X-Request-ID, or generates one,
+// and stores it in the request context so CouchDB calls can forward it.
+func requestIDMiddleware(c *gin.Context) {
+	id := c.GetHeader("X-Request-ID")
+	if id == "" || len(id) > 128 {
+		id = newRequestID()
+	}
+	c.Header("X-Request-ID", id)
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestIDKey{}, id))
+	c.Next()
+}
+
+// requestLogger logs one record per request once it has been handled.
+func requestLogger(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	args := []any{
+		"method", c.Request.Method,
+		"route", c.FullPath(),
+		"path", c.Request.URL.Path,
+		"status", c.Writer.Status(),
+		"latency_ms", time.Since(start).Milliseconds(),
+		"client_ip", c.ClientIP(),
+	}
+	if id := docIDParam(c); id != "" {
+		args = append(args, "doc_id", id)
+	}
+	if tenant := tenantOf(c); tenant != "" {
+		args = append(args, "tenant", tenant)
+	}
+	level := slog.LevelInfo
+	if c.Writer.Status() >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	slog.Log(c.Request.Context(), level, "request", args...)
+}
+
+// docIDParam returns the document ID the request refers to.
+func docIDParam(c *gin.Context) string {
+	if id := c.Param("id"); id != "" {
+		return id
+	}
+	return c.Param("docID")
+}
+
+// logError records a failed handler step together with the underlying error.
+func logError(c *gin.Context, msg string, err error, args ...any) {
+	args = append(args, "route", c.FullPath(), "error", err)
+	var status interface{ HTTPStatus() int }
+	if errors.As(err, &status) {
+		args = append(args, "couchdb_status", status.HTTPStatus())
+	}
+	if id := docIDParam(c); id != "" {
+		args = append(args, "doc_id", id)
+	}
+	slog.ErrorContext(c.Request.Context(), msg, args...)
+}
+
+// redactURL returns raw with any password replaced, for logging.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "<unparseable url>"
+	}
+	return u.Redacted()
+}
+
+// couchTransport forwards the request ID to CouchDB and logs every call with
+// its status and latency.
+type couchTransport struct {
+	base http.RoundTripper
+}
+
+func (t couchTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if id := requestID(ctx); id != "" {
+		req = req.Clone(ctx)
+		req.Header.Set("X-Request-ID", id)
+	}
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	args := []any{
+		"method", req.Method,
+		"url", req.URL.Redacted(),
+		"latency_ms", time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "couchdb request", append(args, "error", err)...)
+		return nil, err
+	}
+	args = append(args, "couchdb_status", resp.StatusCode)
+	level := slog.LevelInfo
+	if resp.StatusCode >= http.StatusInternalServerError {
+		level = slog.LevelWarn
+	}
+	slog.Log(ctx, level, "couchdb request", args...)
+	return resp, nil
+}
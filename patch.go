@@ -0,0 +1,519 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+const (
+	jsonPatchType  = "application/json-patch+json"
+	mergePatchType = "application/merge-patch+json"
+)
+
+// patchAttempts is how often a patch is applied to a freshly read revision
+// when saving it conflicts with a concurrent update.
+const patchAttempts = 5
+
+// PatchOperation is one operation of an RFC 6902 JSON Patch.
+type PatchOperation struct {
+	Op    string          `json:"op" enums:"test,add,remove,replace,move,copy"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty" swaggertype:"object"`
+}
+
+// patchError is a patch that cannot be applied, with the status to report.
+type patchError struct {
+	status int
+	msg    string
+}
+
+func (e *patchError) Error() string { return e.msg }
+
+func unprocessable(format string, args ...interface{}) error {
+	return &patchError{status: http.StatusUnprocessableEntity, msg: fmt.Sprintf(format, args...)}
+}
+
+// decodeJSON decodes data keeping numbers as json.Number, so integers are
+// written back unchanged.
+func decodeJSON(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// parseJSONPatch decodes and checks a JSON Patch document.
+func parseJSONPatch(body []byte) ([]PatchOperation, error) {
+	var ops []PatchOperation
+	if err := json.Unmarshal(body, &ops); err != nil {
+		return nil, fmt.Errorf("patch must be an array of operations: %w", err)
+	}
+	for i, op := range ops {
+		if _, err := pointerTokens(op.Path); err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value == nil {
+				return nil, fmt.Errorf("operation %d: %s needs a value", i, op.Op)
+			}
+		case "move", "copy":
+			if _, err := pointerTokens(op.From); err != nil {
+				return nil, fmt.Errorf("operation %d: from: %w", i, err)
+			}
+			if op.Op == "move" && strings.HasPrefix(op.Path+"/", op.From+"/") && op.Path != op.From {
+				return nil, fmt.Errorf("operation %d: cannot move %s into itself", i, op.From)
+			}
+		case "remove":
+		default:
+			return nil, fmt.Errorf("operation %d: unknown op %q", i, op.Op)
+		}
+	}
+	return ops, nil
+}
+
+// pointerTokens splits an RFC 6901 JSON Pointer into unescaped reference
+// tokens. The empty pointer refers to the whole document.
+func pointerTokens(ptr string) ([]string, error) {
+	if ptr == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(ptr, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", ptr)
+	}
+	tokens := strings.Split(ptr[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// arrayIndex parses token as an index into an array of length n. With
+// insert set, n itself and "-" (the end) are allowed too.
+func arrayIndex(token string, n int, insert bool) (int, error) {
+	if token == "-" && insert {
+		return n, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, unprocessable("invalid array index %q", token)
+	}
+	if i > n || (i == n && !insert) {
+		return 0, unprocessable("array index %d out of range", i)
+	}
+	return i, nil
+}
+
+// getPointer returns the value ptr refers to in doc.
+func getPointer(doc interface{}, ptr string) (interface{}, error) {
+	tokens, _ := pointerTokens(ptr)
+	node := doc
+	for _, t := range tokens {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			v, ok := n[t]
+			if !ok {
+				return nil, unprocessable("path %s does not exist", ptr)
+			}
+			node = v
+		case []interface{}:
+			i, err := arrayIndex(t, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+		default:
+			return nil, unprocessable("path %s does not exist", ptr)
+		}
+	}
+	return node, nil
+}
+
+// updatePointer calls fn with the container holding the last token of ptr
+// and that token, and puts the container fn returns in its place. Arrays
+// change length on add and remove, hence the replacement.
+func updatePointer(node interface{}, ptr string, tokens []string, fn func(container interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(tokens) == 1 {
+		return fn(node, tokens[0])
+	}
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, ok := n[tokens[0]]
+		if !ok {
+			return nil, unprocessable("path %s does not exist", ptr)
+		}
+		child, err := updatePointer(child, ptr, tokens[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		n[tokens[0]] = child
+		return n, nil
+	case []interface{}:
+		i, err := arrayIndex(tokens[0], len(n), false)
+		if err != nil {
+			return nil, err
+		}
+		child, err := updatePointer(n[i], ptr, tokens[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = child
+		return n, nil
+	}
+	return nil, unprocessable("path %s does not exist", ptr)
+}
+
+// addPointer adds value at ptr, replacing an object member or inserting into
+// an array.
+func addPointer(doc interface{}, ptr string, value interface{}) (interface{}, error) {
+	tokens, _ := pointerTokens(ptr)
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return updatePointer(doc, ptr, tokens, func(container interface{}, token string) (interface{}, error) {
+		switch n := container.(type) {
+		case map[string]interface{}:
+			n[token] = value
+			return n, nil
+		case []interface{}:
+			i, err := arrayIndex(token, len(n), true)
+			if err != nil {
+				return nil, err
+			}
+			n = append(n, nil)
+			copy(n[i+1:], n[i:])
+			n[i] = value
+			return n, nil
+		}
+		return nil, unprocessable("path %s does not exist", ptr)
+	})
+}
+
+// removePointer removes the value at ptr, which must exist.
+func removePointer(doc interface{}, ptr string) (interface{}, error) {
+	tokens, _ := pointerTokens(ptr)
+	if len(tokens) == 0 {
+		return nil, unprocessable("cannot remove the whole document")
+	}
+	return updatePointer(doc, ptr, tokens, func(container interface{}, token string) (interface{}, error) {
+		switch n := container.(type) {
+		case map[string]interface{}:
+			if _, ok := n[token]; !ok {
+				return nil, unprocessable("path %s does not exist", ptr)
+			}
+			delete(n, token)
+			return n, nil
+		case []interface{}:
+			i, err := arrayIndex(token, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			return append(n[:i], n[i+1:]...), nil
+		}
+		return nil, unprocessable("path %s does not exist", ptr)
+	})
+}
+
+// jsonEqual compares two decoded JSON values, treating numbers by value.
+func jsonEqual(a, b interface{}) bool {
+	switch a := a.(type) {
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			w, ok := b[k]
+			if !ok || !jsonEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !jsonEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number, float64:
+		x, okA := jsonFloat(a)
+		y, okB := jsonFloat(b)
+		return okA && okB && x == y
+	}
+	return a == b
+}
+
+// jsonFloat returns the value of a number decoded with or without UseNumber.
+func jsonFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// applyJSONPatch applies ops to doc in order. It stops at the first
+// operation that fails; doc may then be partly modified.
+func applyJSONPatch(doc interface{}, ops []PatchOperation) (interface{}, error) {
+	for i, op := range ops {
+		var value interface{}
+		if op.Value != nil {
+			if err := decodeJSON(op.Value, &value); err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		switch op.Op {
+		case "add":
+			doc, err = addPointer(doc, op.Path, value)
+		case "remove":
+			doc, err = removePointer(doc, op.Path)
+		case "replace":
+			if op.Path == "" {
+				doc = value
+			} else if doc, err = removePointer(doc, op.Path); err == nil {
+				doc, err = addPointer(doc, op.Path, value)
+			}
+		case "move", "copy":
+			var v interface{}
+			if v, err = getPointer(doc, op.From); err != nil {
+				break
+			}
+			if op.Op == "move" {
+				doc, err = removePointer(doc, op.From)
+			} else {
+				// The copy must not share containers with the source.
+				var b []byte
+				if b, err = json.Marshal(v); err == nil {
+					err = decodeJSON(b, &v)
+				}
+			}
+			if err == nil {
+				doc, err = addPointer(doc, op.Path, v)
+			}
+		case "test":
+			var v interface{}
+			if v, err = getPointer(doc, op.Path); err == nil && !jsonEqual(v, value) {
+				err = &patchError{status: http.StatusConflict, msg: fmt.Sprintf("test of %s failed", op.Path)}
+			}
+		}
+		if err != nil {
+			var pe *patchError
+			if errors.As(err, &pe) {
+				pe.msg = fmt.Sprintf("operation %d (%s): %s", i, op.Op, pe.msg)
+			}
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// applyMergePatch applies an RFC 7396 merge patch to target: object members
+// are merged recursively, null removes a member, anything else replaces.
+func applyMergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = applyMergePatch(t[k], v)
+		}
+	}
+	return t
+}
+
+// protectedChange reports the first member a patch of before into after
+// added, removed or changed that only CouchDB and the API may set: those
+// starting with an underscore, such as _deleted and _attachments, and
+// trashField, which the trash routes manage.
+func protectedChange(before, after map[string]interface{}) (string, bool) {
+	fields := map[string]bool{trashField: true}
+	for _, doc := range []map[string]interface{}{before, after} {
+		for k := range doc {
+			if strings.HasPrefix(k, "_") {
+				fields[k] = true
+			}
+		}
+	}
+	names := make([]string, 0, len(fields))
+	for k := range fields {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		old, had := before[k]
+		v, has := after[k]
+		if had != has || !jsonEqual(old, v) {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+// patchDocumentHandler godoc
+// @Summary Patch a document
+// @Description Applies a JSON Patch (RFC 6902, Content-Type application/json-patch+json, ops test, add, remove, replace,
+// @Description move and copy) or a JSON Merge Patch (RFC 7396, Content-Type application/merge-patch+json, where null
+// @Description removes a field) to a document. The patch applies as a whole or not at all. With If-Match or rev, it
+// @Description only applies to that revision; otherwise it is reapplied to the latest revision when a concurrent
+// @Description update conflicts. Members starting with an underscore and deleted_at cannot be patched.
+// @Tags document
+// @Accept application/json-patch+json,application/merge-patch+json
+// @Produce json
+// @Param docID path string true "Document ID"
+// @Param If-Match header string false "Revision the patch applies to"
+// @Param rev query string false "Revision the patch applies to"
+// @Param patch body []PatchOperation true "JSON Patch operations, or a merge patch object"
+// @Success 200 {object} Response "Document patched successfully"
+// @Failure 400 {object} Problem "Invalid patch"
+// @Failure 404 {object} Problem "Document not found"
+// @Failure 409 {object} Problem "Test operation failed, document updated concurrently, or a unique value is already used"
+// @Failure 412 {object} Problem "Revision does not match"
+// @Failure 415 {object} Problem "Unsupported patch format"
+// @Failure 422 {object} Problem "Patch does not apply, changes a protected member, or the result does not match its schema"
+// @Failure 429 {object} Problem "Rate limit or daily quota exceeded."
+// @Failure 500 {object} Problem "Failed to patch document"
+// @Deprecated
+// @Router /document/{docID} [patch]
+func patchDocumentHandler(c *gin.Context) {
+	docID := docIDParam(c)
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, codeInvalidRequest, "Failed to read request body")
+		return
+	}
+	var apply func(doc interface{}) (interface{}, error)
+	switch c.ContentType() {
+	case jsonPatchType:
+		ops, err := parseJSONPatch(body)
+		if err != nil {
+			respondProblem(c, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		apply = func(doc interface{}) (interface{}, error) { return applyJSONPatch(doc, ops) }
+	case mergePatchType:
+		var patch map[string]interface{}
+		if err := decodeJSON(body, &patch); err != nil || patch == nil {
+			respondProblem(c, http.StatusBadRequest, codeInvalidRequest, "Merge patch must be a JSON object")
+			return
+		}
+		apply = func(doc interface{}) (interface{}, error) { return applyMergePatch(doc, patch), nil }
+	default:
+		respondProblem(c, http.StatusUnsupportedMediaType, codeUnsupportedMedia, "Content-Type must be "+jsonPatchType+" or "+mergePatchType)
+		return
+	}
+
+	wantRev := strings.Trim(c.GetHeader("If-Match"), `"`)
+	if wantRev == "" {
+		wantRev = c.Query("rev")
+	}
+
+	repo := studentsOf(c)
+	for attempt := 1; ; attempt++ {
+		before, err := repo.load(c.Request.Context(), docID)
+		if err == nil && isTrashed(before) {
+			err = errDocumentNotFound
+		}
+		if err == errDocumentNotFound {
+			respondProblem(c, http.StatusNotFound, codeNotFound, "Document not found")
+			return
+		}
+		if err != nil {
+			respondCouchError(c, "Failed to retrieve document", err)
+			return
+		}
+		rev, _ := before["_rev"].(string)
+		if wantRev != "" && wantRev != rev {
+			p := newProblem(c, http.StatusPreconditionFailed, codePreconditionFailed, "Document is at revision "+rev)
+			p.Rev = rev
+			writeProblem(c, p)
+			return
+		}
+
+		// apply modifies doc in place, so it works on a copy of before.
+		var doc interface{}
+		raw, err := json.Marshal(before)
+		if err == nil {
+			err = decodeJSON(raw, &doc)
+		}
+		if err != nil {
+			respondCouchError(c, "Failed to decode document", err)
+			return
+		}
+		patched, err := apply(doc)
+		if err != nil {
+			status := http.StatusUnprocessableEntity
+			var pe *patchError
+			if errors.As(err, &pe) {
+				status = pe.status
+			}
+			p := newProblem(c, status, statusCode(status), err.Error())
+			p.Rev = rev
+			writeProblem(c, p)
+			return
+		}
+		patchedDoc, ok := patched.(map[string]interface{})
+		if !ok {
+			p := newProblem(c, http.StatusUnprocessableEntity, codeUnprocessable, "Patched document must be a JSON object")
+			p.Rev = rev
+			writeProblem(c, p)
+			return
+		}
+		// A patch replacing the whole document need not repeat its ID and
+		// revision.
+		for _, k := range []string{"_id", "_rev"} {
+			if _, ok := patchedDoc[k]; !ok {
+				patchedDoc[k] = before[k]
+			}
+		}
+		if field, ok := protectedChange(before, patchedDoc); ok {
+			p := newProblem(c, http.StatusUnprocessableEntity, codeUnprocessable, "Patch must not change "+field)
+			p.Rev = rev
+			writeProblem(c, p)
+			return
+		}
+
+		newRev, err := repo.put(c.Request.Context(), "patch", docID, before, patchedDoc)
+		if kivik.HTTPStatus(err) == http.StatusConflict {
+			if wantRev == "" && attempt < patchAttempts {
+				continue
+			}
+			respondProblem(c, http.StatusConflict, codeConflict, "Document was updated concurrently")
+			return
+		}
+		if err != nil {
+			respondRepoError(c, "Failed to patch document", err, "attempt", attempt)
+			return
+		}
+
+		c.Header("ETag", `"`+newRev+`"`)
+		c.JSON(http.StatusOK, Response{Message: "Document patched successfully", Rev: newRev})
+		return
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+const (
+	problemContentType = "application/problem+json"
+	// problemTypePrefix makes a problem code the type URI of RFC 7807.
+	problemTypePrefix = "urn:student-api:problem:"
+)
+
+// Problem codes. They are part of the API: clients switch on them, so they
+// never change once published.
+const (
+	codeInvalidRequest       = "invalid_request"
+	codeUnauthorized         = "unauthorized"
+	codeForbidden            = "forbidden"
+	codeNotFound             = "not_found"
+	codeMethodNotAllowed     = "method_not_allowed"
+	codeNotAcceptable        = "not_acceptable"
+	codeConflict             = "conflict"
+	codeUniqueViolation      = "unique_violation"
+	codeRequestInProgress    = "request_in_progress"
+	codeGone                 = "gone"
+	codePreconditionFailed   = "precondition_failed"
+	codePayloadTooLarge      = "payload_too_large"
+	codeUnsupportedMedia     = "unsupported_media_type"
+	codeUnprocessable        = "unprocessable"
+	codeValidationFailed     = "validation_failed"
+	codeIdempotencyKeyReused = "idempotency_key_reused"
+	codeRateLimited          = "rate_limited"
+	codeQuotaExceeded        = "quota_exceeded"
+	codeInternal             = "internal_error"
+	codeUpstream             = "upstream_error"
+	codeUnavailable          = "unavailable"
+	codeTimeout              = "timeout"
+)
+
+// problemTitles are the short, fixed summaries of the problem codes.
+var problemTitles = map[string]string{
+	codeInvalidRequest:       "Invalid request",
+	codeUnauthorized:         "Unauthorized",
+	codeForbidden:            "Forbidden",
+	codeNotFound:             "Not found",
+	codeMethodNotAllowed:     "Method not allowed",
+	codeNotAcceptable:        "Not acceptable",
+	codeConflict:             "Conflict",
+	codeUniqueViolation:      "Unique value already used",
+	codeRequestInProgress:    "Request in progress",
+	codeGone:                 "Gone",
+	codePreconditionFailed:   "Precondition failed",
+	codePayloadTooLarge:      "Payload too large",
+	codeUnsupportedMedia:     "Unsupported media type",
+	codeUnprocessable:        "Unprocessable request",
+	codeValidationFailed:     "Schema validation failed",
+	codeIdempotencyKeyReused: "Idempotency key reused",
+	codeRateLimited:          "Rate limit exceeded",
+	codeQuotaExceeded:        "Daily quota exceeded",
+	codeInternal:             "Internal error",
+	codeUpstream:             "CouchDB error",
+	codeUnavailable:          "Service unavailable",
+	codeTimeout:              "Request timed out",
+}
+
+// Problem is the body of every error response, an RFC 7807 problem details
+// object served as application/problem+json. Code is the stable,
+// machine-readable kind of the problem; Type is the same as a URI.
+type Problem struct {
+	Type     string `json:"type" example:"urn:student-api:problem:not_found"`
+	Title    string `json:"title" example:"Not found"`
+	Status   int    `json:"status" example:"404"`
+	Detail   string `json:"detail,omitempty" example:"Document not found"`
+	Instance string `json:"instance,omitempty" example:"/document/42"`
+	Code     string `json:"code" example:"not_found" enums:"invalid_request,unauthorized,forbidden,not_found,method_not_allowed,not_acceptable,conflict,unique_violation,request_in_progress,gone,precondition_failed,payload_too_large,unsupported_media_type,unprocessable,validation_failed,idempotency_key_reused,rate_limited,quota_exceeded,internal_error,upstream_error,unavailable,timeout"`
+	// RequestID is the X-Request-ID of the request, for support.
+	RequestID string `json:"request_id,omitempty"`
+	// Rev is the current revision of the document, on conflicts and
+	// failed preconditions.
+	Rev string `json:"rev,omitempty"`
+	// Field and DocID name the unique field and the document holding its
+	// value, on unique_violation.
+	Field string `json:"field,omitempty"`
+	DocID string `json:"doc_id,omitempty"`
+	// DocumentType, SchemaVersion and Violations describe a
+	// validation_failed problem.
+	DocumentType  string            `json:"document_type,omitempty"`
+	SchemaVersion int               `json:"schema_version,omitempty"`
+	Violations    []SchemaViolation `json:"violations,omitempty"`
+}
+
+// newProblem returns the problem code with status for the request.
+func newProblem(c *gin.Context, status int, code, detail string) *Problem {
+	return &Problem{
+		Type:      problemTypePrefix + code,
+		Title:     problemTitles[code],
+		Status:    status,
+		Detail:    detail,
+		Instance:  c.Request.URL.Path,
+		Code:      code,
+		RequestID: requestID(c.Request.Context()),
+	}
+}
+
+// statusCodes are the codes of problems that have nothing more specific to
+// say than their status.
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            codeInvalidRequest,
+	http.StatusUnauthorized:          codeUnauthorized,
+	http.StatusForbidden:             codeForbidden,
+	http.StatusNotFound:              codeNotFound,
+	http.StatusMethodNotAllowed:      codeMethodNotAllowed,
+	http.StatusNotAcceptable:         codeNotAcceptable,
+	http.StatusConflict:              codeConflict,
+	http.StatusGone:                  codeGone,
+	http.StatusPreconditionFailed:    codePreconditionFailed,
+	http.StatusRequestEntityTooLarge: codePayloadTooLarge,
+	http.StatusUnsupportedMediaType:  codeUnsupportedMedia,
+	http.StatusUnprocessableEntity:   codeUnprocessable,
+	http.StatusTooManyRequests:       codeRateLimited,
+	http.StatusBadGateway:            codeUpstream,
+	http.StatusServiceUnavailable:    codeUnavailable,
+	http.StatusGatewayTimeout:        codeTimeout,
+}
+
+// statusCode returns the code of a problem with status.
+func statusCode(status int) string {
+	if code, ok := statusCodes[status]; ok {
+		return code
+	}
+	return codeInternal
+}
+
+// writeProblem sends p and stops the handler chain.
+func writeProblem(c *gin.Context, p *Problem) {
+	// gin keeps a Content-Type that is already set.
+	c.Header("Content-Type", problemContentType)
+	c.AbortWithStatusJSON(p.Status, p)
+}
+
+// respondProblem sends the problem code with status.
+func respondProblem(c *gin.Context, status int, code, detail string) {
+	writeProblem(c, newProblem(c, status, code, detail))
+}
+
+// couchProblem maps an error of a CouchDB call to the status and code of
+// the API. CouchDB refusing the API's own credentials is not the client's
+// fault, so 401 and 403 become upstream errors.
+func couchProblem(err error) (int, string) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout, codeTimeout
+	}
+	switch kivik.HTTPStatus(err) {
+	case http.StatusBadRequest:
+		return http.StatusBadRequest, codeInvalidRequest
+	case http.StatusNotFound:
+		return http.StatusNotFound, codeNotFound
+	case http.StatusConflict:
+		return http.StatusConflict, codeConflict
+	case http.StatusPreconditionFailed:
+		return http.StatusPreconditionFailed, codePreconditionFailed
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return http.StatusBadGateway, codeUpstream
+	case http.StatusRequestEntityTooLarge:
+		return http.StatusRequestEntityTooLarge, codePayloadTooLarge
+	case http.StatusServiceUnavailable:
+		return http.StatusServiceUnavailable, codeUnavailable
+	}
+	return http.StatusInternalServerError, codeInternal
+}
+
+// respondCouchError sends the problem err of a CouchDB call maps to, with
+// msg and, for client errors, CouchDB's reason as its detail. Server-side
+// failures are logged with args rather than shown to the client.
+func respondCouchError(c *gin.Context, msg string, err error, args ...any) {
+	writeProblem(c, newCouchProblem(c, msg, err, args...))
+}
+
+// newCouchProblem returns the problem respondCouchError sends, for responses
+// whose status has already been written.
+func newCouchProblem(c *gin.Context, msg string, err error, args ...any) *Problem {
+	status, code := couchProblem(err)
+	if status >= http.StatusInternalServerError {
+		logError(c, msg, err, args...)
+		return newProblem(c, status, code, msg+".")
+	}
+	return newProblem(c, status, code, msg+": "+err.Error())
+}
+
+// noRouteHandler answers requests for unknown routes.
+func noRouteHandler(c *gin.Context) {
+	respondProblem(c, http.StatusNotFound, codeNotFound, "No route matches "+c.Request.URL.Path)
+}
+
+// noMethodHandler answers requests with a method the route does not have.
+func noMethodHandler(c *gin.Context) {
+	respondProblem(c, http.StatusMethodNotAllowed, codeMethodNotAllowed, c.Request.Method+" is not allowed on "+c.Request.URL.Path)
+}
+
+// recoverProblem answers a request whose handler panicked.
+func recoverProblem(c *gin.Context, recovered any) {
+	respondProblem(c, http.StatusInternalServerError, codeInternal, "The request failed unexpectedly.")
+}
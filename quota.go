@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+const quotaDB = "quotas"
+
+var quotas = &quotaStore{
+	limit:   envInt("QUOTA_DAILY", 10000),
+	entries: make(map[string]*quotaEntry),
+}
+
+// quotaDoc is the CouchDB representation of one client's usage for one day.
+type quotaDoc struct {
+	ID    string `json:"_id"`
+	Rev   string `json:"_rev,omitempty"`
+	Key   string `json:"key"`
+	Day   string `json:"day"`
+	Count int    `json:"count"`
+}
+
+// quotaEntry tracks the stored count of a quota document and the requests
+// counted since it was last written.
+type quotaEntry struct {
+	doc     quotaDoc
+	pending int
+}
+
+// quotaStore counts requests per client per day. Counts are kept in memory
+// and flushed to the quotas database so they survive restarts and are shared
+// between instances.
+type quotaStore struct {
+	mu      sync.Mutex
+	limit   int
+	entries map[string]*quotaEntry
+}
+
+// allow counts one request for key and reports whether it is within the daily
+// quota. A limit of zero disables quotas.
+func (q *quotaStore) allow(ctx context.Context, key string, now time.Time) bool {
+	if q.limit <= 0 {
+		return true
+	}
+	id := quotaDocID(key, now)
+
+	q.mu.Lock()
+	e, ok := q.entries[id]
+	q.mu.Unlock()
+	if !ok {
+		e = &quotaEntry{doc: quotaDoc{ID: id, Key: key, Day: now.UTC().Format(time.DateOnly)}}
+		if err := q.load(ctx, &e.doc); err != nil {
+			slog.WarnContext(ctx, "Failed to load quota", "quota_id", id, "error", err)
+		}
+		q.mu.Lock()
+		if existing, ok := q.entries[id]; ok {
+			e = existing
+		} else {
+			q.entries[id] = e
+		}
+		q.mu.Unlock()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if e.doc.Count+e.pending >= q.limit {
+		return false
+	}
+	e.pending++
+	return true
+}
+
+// load reads the stored count of doc, leaving it untouched when there is none.
+func (q *quotaStore) load(ctx context.Context, doc *quotaDoc) error {
+	err := client.DB(quotaDB).Get(ctx, doc.ID).ScanDoc(doc)
+	if kivik.HTTPStatus(err) == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// flush writes pending counts to CouchDB and forgets entries of past days.
+// On a conflict with another instance the stored count is re-read and the
+// pending requests are added on top of it.
+func (q *quotaStore) flush(ctx context.Context, now time.Time) {
+	today := now.UTC().Format(time.DateOnly)
+
+	q.mu.Lock()
+	dirty := make([]*quotaEntry, 0, len(q.entries))
+	for id, e := range q.entries {
+		if e.pending > 0 {
+			dirty = append(dirty, e)
+		} else if e.doc.Day != today {
+			delete(q.entries, id)
+		}
+	}
+	q.mu.Unlock()
+
+	db := client.DB(quotaDB)
+	for _, e := range dirty {
+		q.mu.Lock()
+		doc, pending := e.doc, e.pending
+		q.mu.Unlock()
+
+		doc.Count += pending
+		rev, err := db.Put(ctx, doc.ID, doc)
+		if kivik.HTTPStatus(err) == http.StatusConflict {
+			if err = q.load(ctx, &doc); err == nil {
+				doc.Count += pending
+				rev, err = db.Put(ctx, doc.ID, doc)
+			}
+		}
+		if err != nil {
+			slog.Warn("Failed to store quota", "quota_id", doc.ID, "error", err)
+			continue
+		}
+
+		q.mu.Lock()
+		doc.Rev = rev
+		e.doc = doc
+		e.pending -= pending
+		q.mu.Unlock()
+	}
+}
+
+// flushQuotas periodically persists quota counts, and once more when ctx is
+// done so that no counts are lost on shutdown.
+func flushQuotas(ctx context.Context, interval time.Duration) {
+	every(ctx, interval, func(now time.Time) {
+		quotas.flush(ctx, now)
+	})
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	quotas.flush(ctx, time.Now())
+}
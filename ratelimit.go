@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimitGroups holds one limiter per route group. Routes that pull the
+// whole database get a much smaller budget than single document reads.
+var rateLimitGroups = map[string]*rateLimiter{
+	"documents": newRateLimiter("DOCUMENTS", 1, 5),
+	"read":      newRateLimiter("READ", 20, 40),
+	"write":     newRateLimiter("WRITE", 5, 10),
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a token bucket per client key. Buckets refill at rate tokens
+// per second up to burst.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+// newRateLimiter builds a limiter whose rate and burst can be overridden with
+// RATE_LIMIT_<name>_RPS and RATE_LIMIT_<name>_BURST.
+func newRateLimiter(name string, rps, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    float64(envInt("RATE_LIMIT_"+name+"_RPS", rps)),
+		burst:   float64(envInt("RATE_LIMIT_"+name+"_BURST", burst)),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// take removes one token from the bucket for key. It reports the tokens left
+// and, when the bucket is empty, how long until the next token is available.
+func (l *rateLimiter) take(key string, now time.Time) (remaining int, wait time.Duration, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, found := l.buckets[key]
+	if !found {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait = time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return 0, wait, false
+	}
+	b.tokens--
+	return int(b.tokens), 0, true
+}
+
+// resetIn reports how long an idle bucket with remaining tokens needs to refill.
+func (l *rateLimiter) resetIn(remaining int) time.Duration {
+	return time.Duration((l.burst - float64(remaining)) / l.rate * float64(time.Second))
+}
+
+// prune drops buckets that have been idle long enough to be full again.
+func (l *rateLimiter) prune(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// pruneRateLimiters periodically frees buckets of clients that went quiet.
+func pruneRateLimiters(ctx context.Context, interval time.Duration) {
+	every(ctx, interval, func(now time.Time) {
+		for _, l := range rateLimitGroups {
+			l.prune(now)
+		}
+	})
+}
+
+// apiKeys holds the SHA-256 sums of the API keys of API_KEYS, a comma
+// separated list. Only these keys get a bucket and a quota of their own.
+var apiKeys = func() map[string]bool {
+	keys := make(map[string]bool)
+	for _, key := range envList("API_KEYS", nil) {
+		keys[apiKeySum(key)] = true
+	}
+	return keys
+}()
+
+func apiKeySum(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// clientKey identifies the caller by API key when a known one is sent and by
+// IP otherwise, so made-up keys cannot escape the limits of their address.
+// API keys are hashed so they are never kept or stored verbatim.
+func clientKey(c *gin.Context) string {
+	return clientKeyOf(c.GetHeader("X-API-Key"), c.ClientIP())
+}
+
+// clientKeyOf is clientKey for an API key, possibly empty, and a client IP.
+func clientKeyOf(apiKey, ip string) string {
+	if apiKey != "" {
+		if sum := apiKeySum(apiKey); apiKeys[sum] {
+			return "key:" + sum[:16]
+		}
+	}
+	return "ip:" + ip
+}
+
+// rateLimit enforces the token bucket of the given route group and the daily
+// quota of the client.
+func rateLimit(group string) gin.HandlerFunc {
+	limiter := rateLimitGroups[group]
+	return func(c *gin.Context) {
+		key := clientKey(c)
+		now := time.Now()
+
+		remaining, wait, ok := limiter.take(key, now)
+		c.Header("RateLimit-Limit", strconv.Itoa(int(limiter.burst)))
+		c.Header("RateLimit-Remaining", strconv.Itoa(remaining))
+		if !ok {
+			c.Header("RateLimit-Reset", seconds(wait))
+			c.Header("Retry-After", seconds(wait))
+			respondProblem(c, http.StatusTooManyRequests, codeRateLimited, "Rate limit exceeded.")
+			return
+		}
+		c.Header("RateLimit-Reset", seconds(limiter.resetIn(remaining)))
+
+		if !quotas.allow(c.Request.Context(), key, now) {
+			c.Header("Retry-After", seconds(untilMidnight(now)))
+			respondProblem(c, http.StatusTooManyRequests, codeQuotaExceeded, "Daily quota exceeded.")
+			return
+		}
+		c.Next()
+	}
+}
+
+// seconds formats d as whole seconds, rounded up, for use in headers.
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// untilMidnight reports the time left in the current UTC day.
+func untilMidnight(now time.Time) time.Duration {
+	now = now.UTC()
+	return now.Truncate(24 * time.Hour).Add(24 * time.Hour).Sub(now)
+}
+
+// quotaDocID names the quota document of key for the UTC day of now.
+func quotaDocID(key string, now time.Time) string {
+	return strings.ReplaceAll(key, ":", "_") + ":" + now.UTC().Format(time.DateOnly)
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+// caller is who a repository operation is made for, taken from an HTTP or a
+// gRPC request. It picks the database and is recorded in the audit log.
+type caller struct {
+	tenant string
+	// db is the student database of the tenant.
+	db     string
+	actor  string
+	method string
+	route  string
+}
+
+// callerOf returns the caller of an HTTP request.
+func callerOf(c *gin.Context) caller {
+	return caller{
+		tenant: tenantOf(c),
+		db:     studentDBName(c),
+		actor:  actor(c),
+		method: c.Request.Method,
+		route:  c.FullPath(),
+	}
+}
+
+// studentRepo reads and writes the students of the caller's database, for
+// the HTTP and the gRPC API alike. Reads hide the trash; writes are checked
+// against the schema registry and the unique fields, keep the document cache
+// current and are audited.
+type studentRepo struct {
+	caller
+}
+
+// studentsOf returns the repository of an HTTP request.
+func studentsOf(c *gin.Context) studentRepo {
+	return studentRepo{callerOf(c)}
+}
+
+// errDocumentNotFound is returned for missing and trashed documents. It
+// carries CouchDB's status, so it is answered like a missing document.
+var errDocumentNotFound = &kivik.Error{Status: http.StatusNotFound, Message: "document not found"}
+
+// get returns document id, from the document cache while it is kept
+// current. cached tells whether it was.
+func (r studentRepo) get(ctx context.Context, id string) (doc map[string]interface{}, cached bool, err error) {
+	raw, gen, cached := documentCache.get(r.db, id, time.Now())
+	if !cached {
+		start := time.Now()
+		row := client.DB(r.db).Get(ctx, id)
+		err := row.ScanDoc(&raw)
+		observeCouch("Get", start, err)
+		if kivik.HTTPStatus(err) == http.StatusNotFound {
+			return nil, false, errDocumentNotFound
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		rev, _ := row.Rev()
+		documentCache.put(r.db, id, rev, raw, gen, time.Now())
+	}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, cached, err
+	}
+	if isTrashed(doc) {
+		return nil, cached, errDocumentNotFound
+	}
+	return doc, cached, nil
+}
+
+// load returns the current revision of document id from CouchDB, including
+// a trashed one, for a write to build on.
+func (r studentRepo) load(ctx context.Context, id string) (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	start := time.Now()
+	err := client.DB(r.db).Get(ctx, id).ScanDoc(&doc)
+	observeCouch("Get", start, err)
+	if kivik.HTTPStatus(err) == http.StatusNotFound {
+		return nil, errDocumentNotFound
+	}
+	return doc, err
+}
+
+// put writes after as document id, whose current revision is before, nil
+// for a new document. A document that does not match its schema is refused
+// with a *schemaError, and one with a value of a unique field that another
+// document holds with a *uniqueError. action names the write in the audit
+// log.
+func (r studentRepo) put(ctx context.Context, action, id string, before, after map[string]interface{}) (string, error) {
+	if err := checkSchema(ctx, after); err != nil {
+		return "", err
+	}
+	claim, err := claimUnique(ctx, r.db, id, before, after)
+	if err != nil {
+		return "", err
+	}
+	start := time.Now()
+	rev, err := client.DB(r.db).Put(ctx, id, after)
+	observeCouch("Put", start, err)
+	if err != nil {
+		claim.abort(ctx)
+		return "", err
+	}
+	claim.commit(ctx)
+	documentCache.invalidate(r.db, id, "")
+	oldRev, _ := before["_rev"].(string)
+	storeAudit(ctx, r.auditRecord(ctx, action, id, before, after, oldRev, rev))
+	return rev, nil
+}
+
+// create writes doc as a new document with a generated ID.
+func (r studentRepo) create(ctx context.Context, doc map[string]interface{}) (id, rev string, err error) {
+	docType, _ := documentType(doc)
+	if id, err = newDocumentID(docType); err != nil {
+		return "", "", err
+	}
+	rev, err = r.put(ctx, "insert", id, nil, doc)
+	return id, rev, err
+}
+
+// remove deletes doc, the current revision of document id: into the trash,
+// or for good with permanent or when soft delete is off. It reports whether
+// the document went to the trash.
+func (r studentRepo) remove(ctx context.Context, id string, doc map[string]interface{}, permanent bool) (trashed bool, err error) {
+	rev, _ := doc["_rev"].(string)
+	defer documentCache.invalidate(r.db, id, "")
+	if softDelete && !permanent {
+		before := maps.Clone(doc)
+		newRev, err := trashDocument(ctx, client.DB(r.db), id, doc)
+		if err != nil {
+			return false, err
+		}
+		storeAudit(ctx, r.auditRecord(ctx, "trash", id, before, doc, rev, newRev))
+		return true, nil
+	}
+
+	start := time.Now()
+	newRev, err := client.DB(r.db).Delete(ctx, id, rev)
+	observeCouch("Delete", start, err)
+	if err != nil {
+		return false, err
+	}
+	releaseUnique(ctx, r.db, id, doc)
+	storeAudit(ctx, r.auditRecord(ctx, "delete", id, doc, nil, rev, newRev))
+	return false, nil
+}
+
+// restore takes document id out of the trash, or recreates it from its last
+// revision when it was deleted for good, like put. A document that is not
+// deleted is refused with errNotDeleted, and one whose last revision is gone
+// with errNotRecoverable.
+func (r studentRepo) restore(ctx context.Context, id string) (string, error) {
+	doc, err := r.load(ctx, id)
+	var before map[string]interface{}
+	switch {
+	case err == nil:
+		if !isTrashed(doc) {
+			return "", errNotDeleted
+		}
+		before = maps.Clone(doc)
+	case errors.Is(err, errDocumentNotFound):
+		doc, err = deletedRevision(ctx, r.db, id, true)
+		if kivik.HTTPStatus(err) == http.StatusNotFound {
+			return "", errDocumentNotFound
+		}
+		if err != nil {
+			return "", err
+		}
+		// A deleted document is recreated on top of its tombstone. Its locks
+		// are gone, and another document may have taken its values since,
+		// which put claims them for.
+		delete(doc, "_rev")
+	default:
+		return "", err
+	}
+	delete(doc, trashField)
+	return r.put(ctx, "restore", id, before, doc)
+}
+
+// attach stores content as attachment filename of document id.
+func (r studentRepo) attach(ctx context.Context, id, filename, contentType string, content io.Reader) (string, error) {
+	doc, err := r.load(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	rev, _ := doc["_rev"].(string)
+
+	counted := &countingReader{r: content}
+	start := time.Now()
+	newRev, err := client.DB(r.db).PutAttachment(ctx, id, &kivik.Attachment{
+		Filename:    filename,
+		Content:     io.NopCloser(counted),
+		ContentType: contentType,
+	}, kivik.Options{"rev": rev})
+	observeCouch("PutAttachment", start, err)
+	if err != nil {
+		return "", err
+	}
+	attachmentBytes.WithLabelValues("upload").Add(float64(counted.n))
+	documentCache.invalidate(r.db, id, "")
+
+	record := r.auditRecord(ctx, "upload", id, nil, nil, rev, newRev)
+	record.Changes = []FieldChange{{Field: "_attachments." + filename, New: contentType}}
+	storeAudit(ctx, record)
+	return newRev, nil
+}
+
+// attachment returns attachment filename of document id. The caller closes
+// its content.
+func (r studentRepo) attachment(ctx context.Context, id, filename string) (*kivik.Attachment, error) {
+	start := time.Now()
+	att, err := client.DB(r.db).GetAttachment(ctx, id, filename)
+	observeCouch("GetAttachment", start, err)
+	return att, err
+}
+
+// list calls fn with every student, in ID order, until fn fails.
+func (r studentRepo) list(ctx context.Context, fn func(doc map[string]interface{}) error) error {
+	start := time.Now()
+	rows := client.DB(r.db).AllDocs(ctx, kivik.Options{"include_docs": true})
+	defer rows.Close()
+	for rows.Next() {
+		id, _ := rows.ID()
+		if strings.HasPrefix(id, "_design/") {
+			continue
+		}
+		var doc map[string]interface{}
+		if err := rows.ScanDoc(&doc); err != nil {
+			return err
+		}
+		if isTrashed(doc) {
+			continue
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+	err := rows.Err()
+	observeCouch("AllDocs", start, err)
+	return err
+}
+
+// studentChange is a change of a student from the changes feed. Doc is nil
+// for deleted and trashed students.
+type studentChange struct {
+	ID      string
+	Seq     string
+	Deleted bool
+	Doc     map[string]interface{}
+}
+
+// watch calls fn with the changes of students after since ("now" for only
+// new ones) until ctx is done or fn fails.
+func (r studentRepo) watch(ctx context.Context, since string, fn func(studentChange) error) error {
+	if since == "" {
+		since = "now"
+	}
+	changes := client.DB(r.db).Changes(ctx, kivik.Options{
+		"feed":         "continuous",
+		"since":        since,
+		"include_docs": true,
+		"heartbeat":    30000,
+	})
+	defer changes.Close()
+	for changes.Next() {
+		if strings.HasPrefix(changes.ID(), "_design/") {
+			continue
+		}
+		change := studentChange{ID: changes.ID(), Seq: changes.Seq(), Deleted: changes.Deleted()}
+		if !change.Deleted {
+			if err := changes.ScanDoc(&change.Doc); err != nil {
+				return err
+			}
+			if isTrashed(change.Doc) {
+				change.Deleted, change.Doc = true, nil
+			}
+		}
+		if err := fn(change); err != nil {
+			return err
+		}
+	}
+	if err := changes.Err(); err != nil && !errors.Is(err, context.Canceled) {
+		return fmt.Errorf("changes feed: %w", err)
+	}
+	return ctx.Err()
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// respondRepoError answers a failed repository call: 422 for a document that
+// does not match its schema, 409 for a unique value another document holds
+// and CouchDB's status otherwise.
+func respondRepoError(c *gin.Context, msg string, err error, args ...any) {
+	var se *schemaError
+	var ue *uniqueError
+	switch {
+	case errors.As(err, &se):
+		respondSchemaError(c, err)
+	case errors.As(err, &ue):
+		respondUniqueError(c, ue)
+	default:
+		respondCouchError(c, msg, err, args...)
+	}
+}
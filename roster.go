@@ -0,0 +1,471 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	kivik "github.com/go-kivik/kivik/v4"
+	"github.com/xuri/excelize/v2"
+)
+
+// rosterBatchSize is the number of rows looked up and written per request to
+// CouchDB.
+const rosterBatchSize = 200
+
+// rosterFieldTypes are the types fields are coerced to unless the mapping
+// says otherwise.
+var rosterFieldTypes = map[string]string{
+	"age": "int",
+}
+
+type RowResult struct {
+	// Row is the 1-based line or sheet row, counting the header row.
+	Row    int      `json:"row"`
+	ID     string   `json:"id,omitempty"`
+	Rev    string   `json:"rev,omitempty"`
+	Status string   `json:"status"` // created, updated, invalid or failed
+	Errors []string `json:"errors,omitempty"`
+}
+
+type RosterImportResponse struct {
+	DryRun  bool        `json:"dry_run"`
+	Rows    int         `json:"rows"`
+	Created int         `json:"created"`
+	Updated int         `json:"updated"`
+	Invalid int         `json:"invalid"`
+	Failed  int         `json:"failed"`
+	Results []RowResult `json:"results"`
+}
+
+// rosterColumn says where a spreadsheet column goes in the document.
+type rosterColumn struct {
+	Field string
+	Type  string
+}
+
+// rosterRow is a parsed and coerced row.
+type rosterRow struct {
+	result *RowResult
+	doc    map[string]interface{}
+	// before is the document the row updated, as it was.
+	before map[string]interface{}
+}
+
+// parseRosterMapping builds the column mapping for headers. mapping is a JSON
+// object from header to field, where a field may carry a type as
+// "field:type" (string, int, float or bool). Without a mapping, every header
+// is used as a field name.
+func parseRosterMapping(headers []string, mapping string) ([]*rosterColumn, error) {
+	byHeader := map[string]string{}
+	if mapping != "" {
+		if err := json.Unmarshal([]byte(mapping), &byHeader); err != nil {
+			return nil, fmt.Errorf("invalid mapping: %w", err)
+		}
+	}
+
+	columns := make([]*rosterColumn, len(headers))
+	for i, h := range headers {
+		h = strings.TrimSpace(h)
+		target, ok := byHeader[h]
+		if !ok {
+			if mapping != "" {
+				continue
+			}
+			target = strings.ToLower(strings.Join(strings.Fields(h), "_"))
+		}
+		field, typ, _ := strings.Cut(target, ":")
+		if typ == "" {
+			typ = rosterFieldTypes[field]
+		}
+		switch typ {
+		case "", "string", "int", "float", "bool":
+		default:
+			return nil, fmt.Errorf("column %q: unknown type %q", h, typ)
+		}
+		if field != "" {
+			columns[i] = &rosterColumn{Field: field, Type: typ}
+		}
+	}
+	return columns, nil
+}
+
+// coerce converts a cell to the column type.
+func (col *rosterColumn) coerce(cell string) (interface{}, error) {
+	switch col.Type {
+	case "int":
+		f, err := strconv.ParseFloat(cell, 64)
+		if err != nil || f != math.Trunc(f) {
+			return nil, fmt.Errorf("%s: %q is not a whole number", col.Field, cell)
+		}
+		return int64(f), nil
+	case "float":
+		f, err := strconv.ParseFloat(cell, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %q is not a number", col.Field, cell)
+		}
+		return f, nil
+	case "bool":
+		b, err := strconv.ParseBool(strings.ToLower(cell))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %q is not true or false", col.Field, cell)
+		}
+		return b, nil
+	}
+	return cell, nil
+}
+
+// parseRosterRows turns spreadsheet records, the first of which holds the
+// headers, into documents. Rows that fail validation are returned with their
+// errors and a nil document.
+func parseRosterRows(records [][]string, mapping, key string) ([]rosterRow, error) {
+	if len(records) == 0 {
+		return nil, fmt.Errorf("file has no header row")
+	}
+	columns, err := parseRosterMapping(records[0], mapping)
+	if err != nil {
+		return nil, err
+	}
+	var keyMapped bool
+	for _, col := range columns {
+		keyMapped = keyMapped || (col != nil && col.Field == key)
+	}
+	if !keyMapped {
+		return nil, fmt.Errorf("no column is mapped to the key field %q", key)
+	}
+
+	var rows []rosterRow
+	seen := map[string]int{}
+	for i, record := range records[1:] {
+		result := &RowResult{Row: i + 2}
+		doc := map[string]interface{}{}
+		for j, cell := range record {
+			cell = strings.TrimSpace(cell)
+			if j >= len(columns) || columns[j] == nil || cell == "" {
+				continue
+			}
+			v, err := columns[j].coerce(cell)
+			if err != nil {
+				result.Errors = append(result.Errors, err.Error())
+				continue
+			}
+			doc[columns[j].Field] = v
+		}
+		if len(doc) == 0 && len(result.Errors) == 0 {
+			// Blank line.
+			continue
+		}
+		if v, ok := doc[key]; !ok {
+			result.Errors = append(result.Errors, key+": required")
+		} else if first, dup := seen[rosterKey(v)]; dup {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: duplicate of row %d", key, first))
+		} else {
+			seen[rosterKey(v)] = result.Row
+		}
+		if len(result.Errors) > 0 {
+			result.Status = "invalid"
+			doc = nil
+		}
+		rows = append(rows, rosterRow{result: result, doc: doc})
+	}
+	return rows, nil
+}
+
+// rosterKey normalises a key value like the unique fields: strings match
+// whatever their case and surrounding space.
+func rosterKey(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return strings.ToLower(strings.TrimSpace(s))
+	}
+	return fmt.Sprint(v)
+}
+
+// upsertRoster writes the valid rows through repo, updating documents whose
+// key field matches and creating the others. Matching documents in the trash
+// are restored. With dryRun set only the lookups and the schema checks are
+// done.
+func upsertRoster(ctx context.Context, repo studentRepo, rows []rosterRow, key string, dryRun bool) error {
+	db := client.DB(repo.db)
+	if !dryRun {
+		// Mango lookups by key need an index to avoid full scans.
+		if err := db.CreateIndex(ctx, "roster-"+key, "by-"+key, map[string]interface{}{"fields": []string{key}}); err != nil {
+			return err
+		}
+	}
+
+	var valid []*rosterRow
+	for i := range rows {
+		if rows[i].doc != nil {
+			valid = append(valid, &rows[i])
+		}
+	}
+	for start := 0; start < len(valid); start += rosterBatchSize {
+		batch := valid[start:min(start+rosterBatchSize, len(valid))]
+		if err := upsertRosterBatch(ctx, repo, batch, key, dryRun); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findRosterMatches returns the documents whose key field matches a row of
+// batch, by normalised key. The lookup finds values stored as imported or in
+// lower case; for a unique key field, the holder of the value's lock is
+// found whatever its case.
+func findRosterMatches(ctx context.Context, repo studentRepo, batch []*rosterRow, key string) (map[string]map[string]interface{}, error) {
+	var values []interface{}
+	seen := map[interface{}]bool{}
+	for _, row := range batch {
+		for _, v := range []interface{}{row.doc[key], rosterKey(row.doc[key])} {
+			if !seen[v] {
+				seen[v] = true
+				values = append(values, v)
+			}
+		}
+	}
+	matches := map[string]map[string]interface{}{}
+	start := time.Now()
+	rs := client.DB(repo.db).Find(ctx, map[string]interface{}{
+		"selector": map[string]interface{}{key: map[string]interface{}{"$in": values}},
+		"limit":    len(values) * 2,
+	})
+	for rs.Next() {
+		var doc map[string]interface{}
+		if err := rs.ScanDoc(&doc); err != nil {
+			rs.Close()
+			return nil, err
+		}
+		matches[rosterKey(doc[key])] = doc
+	}
+	err := rs.Err()
+	observeCouch("Find", start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range batch {
+		k := rosterKey(row.doc[key])
+		if _, ok := matches[k]; ok {
+			continue
+		}
+		for id, lock := range uniqueLocks(row.doc) {
+			if lock.Field != key {
+				continue
+			}
+			var held uniqueLock
+			start := time.Now()
+			err := client.DB(lockDBName(repo.db)).Get(ctx, id).ScanDoc(&held)
+			observeCouch("Get", start, err)
+			if kivik.HTTPStatus(err) == http.StatusNotFound {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			owner, err := repo.load(ctx, held.Owner)
+			if errors.Is(err, errDocumentNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			// A stale lock may name a document that no longer has the value.
+			if rosterKey(owner[key]) == k {
+				matches[k] = owner
+			}
+		}
+	}
+	return matches, nil
+}
+
+func upsertRosterBatch(ctx context.Context, repo studentRepo, batch []*rosterRow, key string, dryRun bool) error {
+	existing, err := findRosterMatches(ctx, repo, batch, key)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range batch {
+		doc := row.doc
+		if current, ok := existing[rosterKey(doc[key])]; ok {
+			row.before = maps.Clone(current)
+			delete(current, trashField)
+			for k, v := range doc {
+				current[k] = v
+			}
+			doc = current
+			row.result.Status = "updated"
+			row.result.ID, _ = current["_id"].(string)
+		} else {
+			row.result.Status = "created"
+			if id, ok := doc["_id"].(string); ok {
+				row.result.ID = id
+			}
+		}
+		row.doc = doc
+		if dryRun {
+			if err := checkSchema(ctx, doc); err != nil {
+				var se *schemaError
+				if !errors.As(err, &se) {
+					return err
+				}
+				row.result.Status = "invalid"
+				row.result.Errors = append(row.result.Errors, se.Error())
+			}
+			continue
+		}
+
+		if row.result.ID == "" {
+			docType, _ := documentType(doc)
+			id, err := newDocumentID(docType)
+			if err != nil {
+				return err
+			}
+			row.result.ID = id
+		}
+		rev, err := repo.put(ctx, "import", row.result.ID, row.before, doc)
+		var se *schemaError
+		var ue *uniqueError
+		switch {
+		case errors.As(err, &se), errors.As(err, &ue):
+			row.result.Status = "invalid"
+			row.result.Errors = append(row.result.Errors, err.Error())
+		case kivik.HTTPStatus(err) == http.StatusConflict:
+			row.result.Status = "failed"
+			row.result.Errors = append(row.result.Errors, "document was updated concurrently")
+		case err != nil:
+			return err
+		default:
+			row.result.Rev = rev
+		}
+	}
+	return nil
+}
+
+// readCSV reads every record of a CSV file.
+func readCSV(r io.Reader) ([][]string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	return cr.ReadAll()
+}
+
+// readXLSX reads every row of a sheet, the first one when sheet is empty.
+func readXLSX(r io.Reader, sheet string) ([][]string, error) {
+	f, err := excelize.OpenReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if sheet == "" {
+		sheet = f.GetSheetName(0)
+	}
+	return f.GetRows(sheet)
+}
+
+// importRoster handles both roster formats. read turns the uploaded file into
+// records.
+func importRoster(c *gin.Context, read func(multipart.File) ([][]string, error)) {
+	header, err := c.FormFile("file")
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, codeInvalidRequest, "Missing file: "+err.Error())
+		return
+	}
+	f, err := header.Open()
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	defer f.Close()
+
+	records, err := read(f)
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, codeInvalidRequest, "Failed to read file: "+err.Error())
+		return
+	}
+	key := c.DefaultPostForm("key", "email")
+	rows, err := parseRosterRows(records, c.PostForm("mapping"), key)
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+
+	dryRun, _ := strconv.ParseBool(c.DefaultPostForm("dry_run", "false"))
+	if err := upsertRoster(c.Request.Context(), studentsOf(c), rows, key, dryRun); err != nil {
+		respondCouchError(c, "Failed to import roster", err)
+		return
+	}
+
+	resp := RosterImportResponse{DryRun: dryRun, Rows: len(rows), Results: make([]RowResult, len(rows))}
+	for i, row := range rows {
+		resp.Results[i] = *row.result
+		switch row.result.Status {
+		case "created":
+			resp.Created++
+		case "updated":
+			resp.Updated++
+		case "invalid":
+			resp.Invalid++
+		case "failed":
+			resp.Failed++
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// importCSVHandler godoc
+// @Summary Import a CSV roster
+// @Description Upserts one student per CSV row, matching existing documents by the key field. The first row holds the
+// @Description headers. Keys match whatever their case and surrounding space, and matching students in the trash are
+// @Description restored. mapping is a JSON object from header to field, optionally typed as "field:type" with type string,
+// @Description int, float or bool; age is an int unless mapped otherwise. Without a mapping, headers are used as field
+// @Description names. Every row is validated, including against the schema of its document type, and reported; with
+// @Description dry_run nothing is written.
+// @Tags import
+// @Accept mpfd
+// @Produce json
+// @Param file formData file true "CSV file"
+// @Param mapping formData string false "Column mapping, e.g. {\"Full Name\":\"name\",\"Age\":\"age:int\"}"
+// @Param key formData string false "Natural key field" default(email)
+// @Param dry_run formData bool false "Validate and preview only" default(false)
+// @Success 200 {object} RosterImportResponse
+// @Failure 400 {object} Problem "Invalid file or mapping"
+// @Failure 429 {object} Problem "Rate limit or daily quota exceeded."
+// @Failure 500 {object} Problem "Failed to import roster"
+// @Deprecated
+// @Router /import/csv [post]
+func importCSVHandler(c *gin.Context) {
+	importRoster(c, func(f multipart.File) ([][]string, error) { return readCSV(f) })
+}
+
+// importXLSXHandler godoc
+// @Summary Import an Excel roster
+// @Description Same as /import/csv for an XLSX workbook. The first sheet is read unless sheet is given.
+// @Tags import
+// @Accept mpfd
+// @Produce json
+// @Param file formData file true "XLSX file"
+// @Param sheet formData string false "Sheet name"
+// @Param mapping formData string false "Column mapping, e.g. {\"Full Name\":\"name\",\"Age\":\"age:int\"}"
+// @Param key formData string false "Natural key field" default(email)
+// @Param dry_run formData bool false "Validate and preview only" default(false)
+// @Success 200 {object} RosterImportResponse
+// @Failure 400 {object} Problem "Invalid file or mapping"
+// @Failure 429 {object} Problem "Rate limit or daily quota exceeded."
+// @Failure 500 {object} Problem "Failed to import roster"
+// @Deprecated
+// @Router /import/xlsx [post]
+func importXLSXHandler(c *gin.Context) {
+	sheet := c.PostForm("sheet")
+	importRoster(c, func(f multipart.File) ([][]string, error) { return readXLSX(f, sheet) })
+}
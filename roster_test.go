@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestImportRosterMatchesKeys(t *testing.T) {
+	f := newFakeCouch(t, defaultDB, lockDBName(defaultDB), auditDB, quotaDB, schemaDB)
+	ann := map[string]interface{}{"name": "Ann", "email": "Ann@Example.com"}
+	f.store(f.dbs[defaultDB], "s-ann", ann, false)
+	// Ann's email is stored as typed; only its lock finds it.
+	for id, lock := range uniqueLocks(ann) {
+		f.store(f.dbs[lockDBName(defaultDB)], id, map[string]interface{}{"field": lock.Field, "owner": "s-ann"}, false)
+	}
+	f.store(f.dbs[defaultDB], "s-bob", map[string]interface{}{"name": "Bob", "email": "bob@example.com", trashField: "2026-01-01T00:00:00Z"}, false)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerV1Routes(r)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", "roster.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte("name,email\nAnn Lee, ann@example.com\nBob,BOB@example.com\nCid,cid@example.com\n"))
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/v1/students/import/csv", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+
+	var resp RosterImportResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Created != 1 || resp.Updated != 2 {
+		t.Fatalf("created %d and updated %d, want 1 and 2: %+v", resp.Created, resp.Updated, resp.Results)
+	}
+	if got := f.doc(defaultDB, "s-ann")["name"]; got != "Ann Lee" {
+		t.Errorf("name of s-ann = %v, want Ann Lee", got)
+	}
+	if _, trashed := f.doc(defaultDB, "s-bob")[trashField]; trashed {
+		t.Error("s-bob is still in the trash")
+	}
+	var audits int
+	for _, d := range f.dbs[auditDB] {
+		if d.render(false, false)["action"] == "import" {
+			audits++
+		}
+	}
+	if audits != 3 {
+		t.Errorf("%d import audit records, want 3", audits)
+	}
+}
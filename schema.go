@@ -0,0 +1,558 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	kivik "github.com/go-kivik/kivik/v4"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+const (
+	schemaDB = "schemas"
+	// typeField names the document type, which selects the schema a document
+	// is validated against.
+	typeField = "type"
+)
+
+var (
+	// defaultDocumentType is the type of documents without a type field.
+	defaultDocumentType = envString("DEFAULT_DOCUMENT_TYPE", "student")
+	// requireSchema refuses documents of types without a schema instead of
+	// storing them unchecked.
+	requireSchema = envBool("REQUIRE_SCHEMA", false)
+)
+
+// schemaRefresh is how long an instance uses its copy of the registry.
+// Schemas stored through another instance take effect within this time.
+const schemaRefresh = 30 * time.Second
+
+var documentTypePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,63}$`)
+
+// schemaDesignDoc makes schema versions immutable: a changed schema is stored
+// as a new version.
+var schemaDesignDoc = map[string]interface{}{
+	"validate_doc_update": `function (newDoc, oldDoc) {
+  if (oldDoc && !oldDoc._deleted) {
+    throw({forbidden: "schema versions are immutable"});
+  }
+}`,
+}
+
+// schemaSetup tracks whether the schema database has its design document.
+var schemaSetup struct {
+	sync.Mutex
+	done bool
+}
+
+type SchemaVersion struct {
+	Type    string          `json:"type"`
+	Version int             `json:"version"`
+	Schema  json.RawMessage `json:"schema" swaggertype:"object"`
+	// CreatedBy is the admin user who stored the version.
+	CreatedBy string `json:"created_by"`
+	CreatedAt string `json:"created_at"`
+}
+
+// schemaDoc stores one version of the schema of a document type.
+type schemaDoc struct {
+	ID  string `json:"_id,omitempty"`
+	Rev string `json:"_rev,omitempty"`
+	SchemaVersion
+}
+
+type SchemaViolation struct {
+	// Path is the JSON pointer of the offending value, "" for the document.
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// schemaError reports a document that does not match the schema of its type.
+type schemaError struct {
+	docType    string
+	version    int
+	violations []SchemaViolation
+}
+
+func (e *schemaError) Error() string {
+	msgs := make([]string, len(e.violations))
+	for i, v := range e.violations {
+		msgs[i] = strings.TrimPrefix(v.Path+": ", ": ") + v.Message
+	}
+	if e.docType == "" {
+		return "invalid document: " + strings.Join(msgs, "; ")
+	}
+	return "document does not match the " + e.docType + " schema: " + strings.Join(msgs, "; ")
+}
+
+// schemaDocID returns the ID of a schema version. Versions are zero-padded so
+// that they sort in order.
+func schemaDocID(docType string, version int) string {
+	return fmt.Sprintf("%s:%06d", docType, version)
+}
+
+// compileSchema compiles a JSON Schema. References to other documents are
+// not followed, so a stored schema cannot make the server fetch URLs or read
+// files.
+func compileSchema(docType string, schema json.RawMessage) (*jsonschema.Schema, error) {
+	url := "schema:///" + docType + ".json"
+	compiler := jsonschema.NewCompiler()
+	compiler.LoadURL = func(s string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("schema references are not supported: %s", s)
+	}
+	if err := compiler.AddResource(url, bytes.NewReader(schema)); err != nil {
+		return nil, err
+	}
+	return compiler.Compile(url)
+}
+
+// documentSchemas is the in-memory copy of the latest schema of every type.
+// The registry is global: the schemas database is managed by the admins and
+// its schemas apply to the documents of every tenant.
+var documentSchemas = &schemaRegistry{}
+
+type compiledSchema struct {
+	version int
+	schema  *jsonschema.Schema
+}
+
+// schemaSnapshot is one load of the registry.
+type schemaSnapshot struct {
+	latest   map[string]compiledSchema
+	loadedAt time.Time
+	// gen is the generation of the registry the load started in.
+	gen uint64
+}
+
+// schemaLoad is a reload of the registry in flight. err is set before done
+// is closed.
+type schemaLoad struct {
+	done chan struct{}
+	err  error
+}
+
+// schemaRegistry serves lookups from its current snapshot without locking.
+// When the snapshot is stale a single reload runs, which concurrent lookups
+// wait for.
+type schemaRegistry struct {
+	current atomic.Pointer[schemaSnapshot]
+	// gen counts invalidations; snapshots loaded before the last one are
+	// stale.
+	gen atomic.Uint64
+
+	mu      sync.Mutex
+	loading *schemaLoad
+}
+
+// lookup returns the latest schema of docType, reloading the registry every
+// schemaRefresh.
+func (r *schemaRegistry) lookup(ctx context.Context, docType string) (compiledSchema, bool, error) {
+	for {
+		snap := r.current.Load()
+		if snap != nil && snap.gen == r.gen.Load() && time.Since(snap.loadedAt) < schemaRefresh {
+			s, ok := snap.latest[docType]
+			return s, ok, nil
+		}
+
+		r.mu.Lock()
+		load := r.loading
+		if load == nil {
+			load = &schemaLoad{done: make(chan struct{})}
+			r.loading = load
+			// The reload serves every waiting lookup, so it is not cut
+			// short when this one's request ends.
+			go r.reload(context.WithoutCancel(ctx), load)
+		}
+		r.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return compiledSchema{}, false, ctx.Err()
+		case <-load.done:
+		}
+		if load.err != nil {
+			return compiledSchema{}, false, load.err
+		}
+	}
+}
+
+// reload loads the registry into a new snapshot and ends load.
+func (r *schemaRegistry) reload(ctx context.Context, load *schemaLoad) {
+	gen := r.gen.Load()
+	latest, err := loadLatestSchemas(ctx)
+	if err == nil {
+		r.current.Store(&schemaSnapshot{latest: latest, loadedAt: time.Now(), gen: gen})
+	}
+	load.err = err
+	r.mu.Lock()
+	r.loading = nil
+	r.mu.Unlock()
+	close(load.done)
+}
+
+// invalidate makes the next lookup reload the registry.
+func (r *schemaRegistry) invalidate() {
+	r.gen.Add(1)
+}
+
+// loadLatestSchemas compiles the latest version of every schema.
+func loadLatestSchemas(ctx context.Context) (map[string]compiledSchema, error) {
+	versions, err := schemaVersions(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	latest := map[string]compiledSchema{}
+	for _, v := range versions {
+		if v.Version <= latest[v.Type].version {
+			continue
+		}
+		schema, err := compileSchema(v.Type, v.Schema)
+		if err != nil {
+			// Stored versions compiled when they were stored.
+			slog.Error("Failed to compile stored schema", "type", v.Type, "version", v.Version, "error", err)
+			continue
+		}
+		latest[v.Type] = compiledSchema{version: v.Version, schema: schema}
+	}
+	return latest, nil
+}
+
+// schemaVersions returns the stored versions of docType, of every type when
+// it is empty, ordered by type and version.
+func schemaVersions(ctx context.Context, docType string) ([]SchemaVersion, error) {
+	opts := kivik.Options{"include_docs": true}
+	if docType != "" {
+		opts["start_key"] = docType + ":"
+		opts["end_key"] = docType + ":\ufff0"
+	}
+	start := time.Now()
+	rows := client.DB(schemaDB).AllDocs(ctx, opts)
+	defer rows.Close()
+	versions := []SchemaVersion{}
+	for rows.Next() {
+		id, _ := rows.ID()
+		if strings.HasPrefix(id, "_design/") {
+			continue
+		}
+		var doc schemaDoc
+		if err := rows.ScanDoc(&doc); err != nil {
+			return nil, err
+		}
+		versions = append(versions, doc.SchemaVersion)
+	}
+	err := rows.Err()
+	observeCouch("AllDocs", start, err)
+	return versions, err
+}
+
+// documentType returns the type of doc.
+func documentType(doc map[string]interface{}) (string, error) {
+	v, ok := doc[typeField]
+	if !ok {
+		return defaultDocumentType, nil
+	}
+	docType, ok := v.(string)
+	if !ok || !documentTypePattern.MatchString(docType) {
+		return "", &schemaError{violations: []SchemaViolation{{Path: "/" + typeField, Message: "must be a document type name"}}}
+	}
+	return docType, nil
+}
+
+// checkSchema validates doc against the latest schema of its type. CouchDB's
+// own fields and the trash marker are not part of the validated document. A
+// mismatch is returned as a *schemaError.
+func checkSchema(ctx context.Context, doc map[string]interface{}) error {
+	docType, err := documentType(doc)
+	if err != nil {
+		return err
+	}
+	s, ok, err := documentSchemas.lookup(ctx, docType)
+	if err != nil {
+		return fmt.Errorf("load schemas: %w", err)
+	}
+	if !ok {
+		if requireSchema {
+			// Types are client input; only registered ones get a label.
+			schemaValidations.WithLabelValues("unregistered", "invalid").Inc()
+			return &schemaError{docType: docType, violations: []SchemaViolation{
+				{Path: "/" + typeField, Message: "no schema is registered for type " + docType},
+			}}
+		}
+		return nil
+	}
+
+	instance := make(map[string]interface{}, len(doc))
+	for k, v := range doc {
+		if !strings.HasPrefix(k, "_") && k != trashField {
+			instance[k] = v
+		}
+	}
+	err = s.schema.Validate(instance)
+	var ve *jsonschema.ValidationError
+	if errors.As(err, &ve) {
+		schemaValidations.WithLabelValues(docType, "invalid").Inc()
+		return &schemaError{docType: docType, version: s.version, violations: schemaViolations(ve)}
+	}
+	if err != nil {
+		return err
+	}
+	schemaValidations.WithLabelValues(docType, "valid").Inc()
+	return nil
+}
+
+// schemaViolations flattens a validation error to its leaf causes.
+func schemaViolations(ve *jsonschema.ValidationError) []SchemaViolation {
+	if len(ve.Causes) == 0 {
+		return []SchemaViolation{{Path: ve.InstanceLocation, Message: ve.Message}}
+	}
+	var violations []SchemaViolation
+	for _, cause := range ve.Causes {
+		violations = append(violations, schemaViolations(cause)...)
+	}
+	return violations
+}
+
+// respondSchemaError answers a failed checkSchema: 422 for a document that
+// does not match its schema, 500 when the schemas could not be loaded.
+func respondSchemaError(c *gin.Context, err error) {
+	var se *schemaError
+	if errors.As(err, &se) {
+		p := newProblem(c, http.StatusUnprocessableEntity, codeValidationFailed, "Document does not match its schema.")
+		p.DocumentType = se.docType
+		p.SchemaVersion = se.version
+		p.Violations = se.violations
+		writeProblem(c, p)
+		return
+	}
+	respondCouchError(c, "Failed to validate document", err)
+}
+
+// ensureSchemaDB installs the design document of the schema database.
+func ensureSchemaDB(ctx context.Context) error {
+	schemaSetup.Lock()
+	defer schemaSetup.Unlock()
+	if schemaSetup.done {
+		return nil
+	}
+	_, err := client.DB(schemaDB).Put(ctx, "_design/schemas", schemaDesignDoc)
+	if err != nil && kivik.HTTPStatus(err) != http.StatusConflict {
+		return err
+	}
+	schemaSetup.done = true
+	return nil
+}
+
+// registerSchemaRoutes adds the schema registry endpoints to the admin group.
+func registerSchemaRoutes(admin *gin.RouterGroup) {
+	admin.GET("/schemas", listSchemasHandler)
+	admin.GET("/schemas/:type", getSchemaHandler)
+	admin.PUT("/schemas/:type", idempotency, putSchemaHandler)
+	admin.GET("/schemas/:type/versions", listSchemaVersionsHandler)
+	admin.GET("/schemas/:type/versions/:version", getSchemaVersionHandler)
+}
+
+// listSchemasHandler godoc
+// @Summary List schemas
+// @Description Lists the latest schema version of every document type
+// @Tags schemas
+// @Produce json
+// @Security BasicAuth
+// @Success 200 {array} SchemaVersion
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Admin routes are disabled"
+// @Failure 500 {object} Problem "Failed to list schemas"
+// @Router /admin/schemas [get]
+func listSchemasHandler(c *gin.Context) {
+	versions, err := schemaVersions(c.Request.Context(), "")
+	if err != nil {
+		respondCouchError(c, "Failed to list schemas", err)
+		return
+	}
+	latest := []SchemaVersion{}
+	for _, v := range versions {
+		if n := len(latest); n > 0 && latest[n-1].Type == v.Type {
+			latest[n-1] = v
+		} else {
+			latest = append(latest, v)
+		}
+	}
+	c.JSON(http.StatusOK, latest)
+}
+
+// typeVersions returns the versions of the type parameter, answering 400, 404
+// or 500 itself when there are none.
+func typeVersions(c *gin.Context) ([]SchemaVersion, bool) {
+	docType := c.Param("type")
+	if !documentTypePattern.MatchString(docType) {
+		respondProblem(c, http.StatusBadRequest, codeInvalidRequest, "Invalid document type")
+		return nil, false
+	}
+	versions, err := schemaVersions(c.Request.Context(), docType)
+	if err != nil {
+		respondCouchError(c, "Failed to retrieve schema", err, "type", docType)
+		return nil, false
+	}
+	if len(versions) == 0 {
+		respondProblem(c, http.StatusNotFound, codeNotFound, "No schema for type "+docType)
+		return nil, false
+	}
+	return versions, true
+}
+
+// getSchemaHandler godoc
+// @Summary Get a schema
+// @Description Returns the latest schema version of a document type, which documents of the type are validated against
+// @Tags schemas
+// @Produce json
+// @Security BasicAuth
+// @Param type path string true "Document type"
+// @Success 200 {object} SchemaVersion
+// @Failure 400 {object} Problem "Invalid document type"
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Admin routes are disabled"
+// @Failure 404 {object} Problem "No schema for the type"
+// @Failure 500 {object} Problem "Failed to retrieve schema"
+// @Router /admin/schemas/{type} [get]
+func getSchemaHandler(c *gin.Context) {
+	if versions, ok := typeVersions(c); ok {
+		c.JSON(http.StatusOK, versions[len(versions)-1])
+	}
+}
+
+// listSchemaVersionsHandler godoc
+// @Summary List schema versions
+// @Description Lists every version of the schema of a document type, oldest first
+// @Tags schemas
+// @Produce json
+// @Security BasicAuth
+// @Param type path string true "Document type"
+// @Success 200 {array} SchemaVersion
+// @Failure 400 {object} Problem "Invalid document type"
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Admin routes are disabled"
+// @Failure 404 {object} Problem "No schema for the type"
+// @Failure 500 {object} Problem "Failed to retrieve schema"
+// @Router /admin/schemas/{type}/versions [get]
+func listSchemaVersionsHandler(c *gin.Context) {
+	if versions, ok := typeVersions(c); ok {
+		c.JSON(http.StatusOK, versions)
+	}
+}
+
+// getSchemaVersionHandler godoc
+// @Summary Get a schema version
+// @Description Returns one version of the schema of a document type
+// @Tags schemas
+// @Produce json
+// @Security BasicAuth
+// @Param type path string true "Document type"
+// @Param version path int true "Version"
+// @Success 200 {object} SchemaVersion
+// @Failure 400 {object} Problem "Invalid document type"
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Admin routes are disabled"
+// @Failure 404 {object} Problem "Version not found"
+// @Failure 500 {object} Problem "Failed to retrieve schema"
+// @Router /admin/schemas/{type}/versions/{version} [get]
+func getSchemaVersionHandler(c *gin.Context) {
+	versions, ok := typeVersions(c)
+	if !ok {
+		return
+	}
+	n, _ := strconv.Atoi(c.Param("version"))
+	i := sort.Search(len(versions), func(i int) bool { return versions[i].Version >= n })
+	if i == len(versions) || versions[i].Version != n {
+		respondProblem(c, http.StatusNotFound, codeNotFound, "Version not found")
+		return
+	}
+	c.JSON(http.StatusOK, versions[i])
+}
+
+// putSchemaHandler godoc
+// @Summary Store a schema
+// @Description Stores a JSON Schema as the next version of the schema of a document type. Documents whose type field,
+// @Description student when missing, names the type are validated against the latest version on insert, update, patch,
+// @Description roster import and bulk import. Schemas may not reference other documents. Versions are immutable. The
+// @Description registry is shared by every tenant.
+// @Tags schemas
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Param type path string true "Document type"
+// @Param schema body object true "JSON Schema"
+// @Success 201 {object} SchemaVersion
+// @Failure 400 {object} Problem "Invalid document type or schema"
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Admin routes are disabled"
+// @Failure 409 {object} Problem "Another version was stored concurrently"
+// @Failure 500 {object} Problem "Failed to store schema"
+// @Router /admin/schemas/{type} [put]
+func putSchemaHandler(c *gin.Context) {
+	docType := c.Param("type")
+	if !documentTypePattern.MatchString(docType) {
+		respondProblem(c, http.StatusBadRequest, codeInvalidRequest, "Invalid document type")
+		return
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, codeInvalidRequest, "Failed to read request body")
+		return
+	}
+	if !json.Valid(body) {
+		respondProblem(c, http.StatusBadRequest, codeInvalidRequest, "Schema must be JSON")
+		return
+	}
+	if _, err := compileSchema(docType, body); err != nil {
+		respondProblem(c, http.StatusBadRequest, codeInvalidRequest, "Invalid schema: "+err.Error())
+		return
+	}
+
+	ctx := c.Request.Context()
+	if err := ensureSchemaDB(ctx); err != nil {
+		respondCouchError(c, "Failed to prepare schema database", err)
+		return
+	}
+	versions, err := schemaVersions(ctx, docType)
+	if err != nil {
+		respondCouchError(c, "Failed to retrieve schema", err, "type", docType)
+		return
+	}
+	doc := schemaDoc{SchemaVersion: SchemaVersion{
+		Type:      docType,
+		Version:   len(versions) + 1,
+		Schema:    body,
+		CreatedBy: c.GetString(gin.AuthUserKey),
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}}
+	if n := len(versions); n > 0 {
+		doc.Version = versions[n-1].Version + 1
+	}
+	start := time.Now()
+	_, err = client.DB(schemaDB).Put(ctx, schemaDocID(docType, doc.Version), doc)
+	observeCouch("Put", start, err)
+	if kivik.HTTPStatus(err) == http.StatusConflict {
+		respondProblem(c, http.StatusConflict, codeConflict, "Another version of the schema was stored concurrently")
+		return
+	}
+	if err != nil {
+		respondCouchError(c, "Failed to store schema", err, "type", docType)
+		return
+	}
+	documentSchemas.invalidate()
+	slog.InfoContext(ctx, "Schema stored", "type", docType, "version", doc.Version)
+	c.JSON(http.StatusCreated, doc.SchemaVersion)
+}
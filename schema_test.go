@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSchemaRegistrySharesReloads(t *testing.T) {
+	f := newFakeCouch(t, schemaDB)
+	f.store(f.dbs[schemaDB], schemaDocID("course", 1), map[string]interface{}{
+		"type":    "course",
+		"version": 1,
+		"schema":  map[string]interface{}{"type": "object"},
+	}, false)
+	var loads atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/"+schemaDB+"/_all_docs") {
+			loads.Add(1)
+			// A slow CouchDB keeps the lookups below waiting together.
+			time.Sleep(50 * time.Millisecond)
+		}
+		f.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	couchURL = srv.URL
+	if err := connectCouchDB(); err != nil {
+		t.Fatal(err)
+	}
+	documentSchemas.invalidate()
+	t.Cleanup(documentSchemas.invalidate)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s, ok, err := documentSchemas.lookup(context.Background(), "course")
+			if err != nil || !ok || s.version != 1 {
+				t.Errorf("lookup = version %d, %t, %v; want version 1", s.version, ok, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := loads.Load(); n != 1 {
+		t.Errorf("registry loaded %d times, want once", n)
+	}
+
+	// A lookup whose request ends does not wait for the reload.
+	documentSchemas.invalidate()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := documentSchemas.lookup(ctx, "course"); err != context.Canceled {
+		t.Errorf("lookup with a canceled context = %v, want %v", err, context.Canceled)
+	}
+	// The reload it started goes on for the next lookup.
+	if _, ok, err := documentSchemas.lookup(context.Background(), "course"); err != nil || !ok {
+		t.Errorf("lookup after the canceled one = %t, %v", ok, err)
+	}
+	if n := loads.Load(); n != 2 {
+		t.Errorf("registry loaded %d times, want twice", n)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// selectorOperators are the Mango operators matchSelector understands.
+var selectorOperators = map[string]bool{
+	"$and": true, "$or": true, "$nor": true, "$not": true,
+	"$eq": true, "$ne": true, "$gt": true, "$gte": true, "$lt": true, "$lte": true,
+	"$in": true, "$nin": true, "$exists": true, "$regex": true,
+}
+
+// checkSelector reports selector operators matchSelector does not support, so
+// that they are refused when a selector is stored rather than never
+// matching.
+func checkSelector(sel interface{}) error {
+	switch s := sel.(type) {
+	case map[string]interface{}:
+		for key, v := range s {
+			if strings.HasPrefix(key, "$") && !selectorOperators[key] {
+				return fmt.Errorf("unsupported selector operator %s", key)
+			}
+			if key == "$regex" {
+				if p, ok := v.(string); !ok {
+					return fmt.Errorf("$regex needs a string")
+				} else if _, err := regexp.Compile(p); err != nil {
+					return fmt.Errorf("$regex: %w", err)
+				}
+			}
+			if err := checkSelector(v); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, v := range s {
+			if err := checkSelector(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// matchSelector evaluates a CouchDB Mango selector against doc. It supports
+// the combination operators $and, $or, $nor and $not, the condition
+// operators in selectorOperators, dotted field names and nested selectors.
+func matchSelector(doc interface{}, sel map[string]interface{}) bool {
+	for key, cond := range sel {
+		switch key {
+		case "$and", "$or", "$nor":
+			list, _ := cond.([]interface{})
+			var matched int
+			for _, item := range list {
+				if sub, ok := item.(map[string]interface{}); ok && matchSelector(doc, sub) {
+					matched++
+				}
+			}
+			ok := map[string]bool{
+				"$and": matched == len(list),
+				"$or":  matched > 0,
+				"$nor": matched == 0,
+			}[key]
+			if !ok {
+				return false
+			}
+		case "$not":
+			sub, _ := cond.(map[string]interface{})
+			if matchSelector(doc, sub) {
+				return false
+			}
+		default:
+			v, found := selectorField(doc, key)
+			if !matchCondition(v, found, cond) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// selectorField looks up a dotted field name in doc.
+func selectorField(doc interface{}, name string) (interface{}, bool) {
+	v := doc
+	for _, part := range strings.Split(name, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// matchCondition matches a field value against a condition: an object of
+// operators, a nested selector or a value the field must equal.
+func matchCondition(v interface{}, found bool, cond interface{}) bool {
+	ops, ok := cond.(map[string]interface{})
+	if !ok {
+		return found && jsonEqual(v, cond)
+	}
+	var hasOps bool
+	for op := range ops {
+		hasOps = hasOps || strings.HasPrefix(op, "$")
+	}
+	if !hasOps {
+		return found && matchSelector(v, ops)
+	}
+
+	for op, arg := range ops {
+		var ok bool
+		switch op {
+		case "$eq":
+			ok = found && jsonEqual(v, arg)
+		case "$ne":
+			ok = !found || !jsonEqual(v, arg)
+		case "$gt", "$gte", "$lt", "$lte":
+			if cmp, comparable := compareJSON(v, arg); found && comparable {
+				ok = map[string]bool{"$gt": cmp > 0, "$gte": cmp >= 0, "$lt": cmp < 0, "$lte": cmp <= 0}[op]
+			}
+		case "$in", "$nin":
+			list, _ := arg.([]interface{})
+			var in bool
+			for _, item := range list {
+				in = in || (found && jsonEqual(v, item))
+			}
+			ok = in == (op == "$in")
+		case "$exists":
+			want, _ := arg.(bool)
+			ok = found == want
+		case "$regex":
+			s, isString := v.(string)
+			pattern, _ := arg.(string)
+			re, err := regexp.Compile(pattern)
+			ok = isString && err == nil && re.MatchString(s)
+		case "$not":
+			ok = !matchCondition(v, found, arg)
+		default:
+			// A field name next to operators, which Mango does not allow.
+			ok = false
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// compareJSON orders two numbers or two strings.
+func compareJSON(a, b interface{}) (int, bool) {
+	if x, ok := jsonFloat(a); ok {
+		y, ok := jsonFloat(b)
+		switch {
+		case !ok:
+			return 0, false
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	x, okA := a.(string)
+	y, okB := b.(string)
+	if !okA || !okB {
+		return 0, false
+	}
+	return strings.Compare(x, y), true
+}
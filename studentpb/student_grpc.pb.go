@@ -0,0 +1,536 @@
+// The gRPC API of the student service. It serves the same students as the
+// HTTP API under /v1, with the same tenants, rate limits, quotas, schema
+// checks, unique fields and audit log.
+//
+// Regenerate the Go code from the repository root with:
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//	  --go-grpc_out=. --go-grpc_opt=paths=source_relative \
+//	  studentpb/student.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: studentpb/student.proto
+
+package studentpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	StudentService_Get_FullMethodName          = "/student.v1.StudentService/Get"
+	StudentService_Put_FullMethodName          = "/student.v1.StudentService/Put"
+	StudentService_Delete_FullMethodName       = "/student.v1.StudentService/Delete"
+	StudentService_Restore_FullMethodName      = "/student.v1.StudentService/Restore"
+	StudentService_List_FullMethodName         = "/student.v1.StudentService/List"
+	StudentService_WatchChanges_FullMethodName = "/student.v1.StudentService/WatchChanges"
+	StudentService_Upload_FullMethodName       = "/student.v1.StudentService/Upload"
+	StudentService_Download_FullMethodName     = "/student.v1.StudentService/Download"
+)
+
+// StudentServiceClient is the client API for StudentService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// StudentService reads and writes the students of the caller's tenant. The
+// tenant is taken from the bearer token of the authorization metadata and,
+// when TENANT_SOURCES turns them on, from x-tenant-id and the subdomain of
+// :authority; the client from x-api-key, like the HTTP headers.
+type StudentServiceClient interface {
+	// Get returns a student. Trashed students are not found.
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*Student, error)
+	// Put creates or replaces a student. A student without an ID gets a
+	// generated one.
+	Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*PutResponse, error)
+	// Delete moves a student to the trash, or deletes it for good.
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+	// Restore takes a student out of the trash, or recreates one that was
+	// deleted for good from its last revision.
+	Restore(ctx context.Context, in *RestoreRequest, opts ...grpc.CallOption) (*RestoreResponse, error)
+	// List streams every student in ID order.
+	List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (StudentService_ListClient, error)
+	// WatchChanges streams changes of students until the call is cancelled.
+	WatchChanges(ctx context.Context, in *WatchChangesRequest, opts ...grpc.CallOption) (StudentService_WatchChangesClient, error)
+	// Upload stores an attachment: the first message names it, the following
+	// ones carry its content.
+	Upload(ctx context.Context, opts ...grpc.CallOption) (StudentService_UploadClient, error)
+	// Download streams an attachment: the first message describes it, the
+	// following ones carry its content.
+	Download(ctx context.Context, in *DownloadRequest, opts ...grpc.CallOption) (StudentService_DownloadClient, error)
+}
+
+type studentServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewStudentServiceClient(cc grpc.ClientConnInterface) StudentServiceClient {
+	return &studentServiceClient{cc}
+}
+
+func (c *studentServiceClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*Student, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Student)
+	err := c.cc.Invoke(ctx, StudentService_Get_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *studentServiceClient) Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*PutResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PutResponse)
+	err := c.cc.Invoke(ctx, StudentService_Put_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *studentServiceClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, StudentService_Delete_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *studentServiceClient) Restore(ctx context.Context, in *RestoreRequest, opts ...grpc.CallOption) (*RestoreResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RestoreResponse)
+	err := c.cc.Invoke(ctx, StudentService_Restore_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *studentServiceClient) List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (StudentService_ListClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &StudentService_ServiceDesc.Streams[0], StudentService_List_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &studentServiceListClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type StudentService_ListClient interface {
+	Recv() (*Student, error)
+	grpc.ClientStream
+}
+
+type studentServiceListClient struct {
+	grpc.ClientStream
+}
+
+func (x *studentServiceListClient) Recv() (*Student, error) {
+	m := new(Student)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *studentServiceClient) WatchChanges(ctx context.Context, in *WatchChangesRequest, opts ...grpc.CallOption) (StudentService_WatchChangesClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &StudentService_ServiceDesc.Streams[1], StudentService_WatchChanges_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &studentServiceWatchChangesClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type StudentService_WatchChangesClient interface {
+	Recv() (*Change, error)
+	grpc.ClientStream
+}
+
+type studentServiceWatchChangesClient struct {
+	grpc.ClientStream
+}
+
+func (x *studentServiceWatchChangesClient) Recv() (*Change, error) {
+	m := new(Change)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *studentServiceClient) Upload(ctx context.Context, opts ...grpc.CallOption) (StudentService_UploadClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &StudentService_ServiceDesc.Streams[2], StudentService_Upload_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &studentServiceUploadClient{ClientStream: stream}
+	return x, nil
+}
+
+type StudentService_UploadClient interface {
+	Send(*UploadRequest) error
+	CloseAndRecv() (*UploadResponse, error)
+	grpc.ClientStream
+}
+
+type studentServiceUploadClient struct {
+	grpc.ClientStream
+}
+
+func (x *studentServiceUploadClient) Send(m *UploadRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *studentServiceUploadClient) CloseAndRecv() (*UploadResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(UploadResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *studentServiceClient) Download(ctx context.Context, in *DownloadRequest, opts ...grpc.CallOption) (StudentService_DownloadClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &StudentService_ServiceDesc.Streams[3], StudentService_Download_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &studentServiceDownloadClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type StudentService_DownloadClient interface {
+	Recv() (*DownloadResponse, error)
+	grpc.ClientStream
+}
+
+type studentServiceDownloadClient struct {
+	grpc.ClientStream
+}
+
+func (x *studentServiceDownloadClient) Recv() (*DownloadResponse, error) {
+	m := new(DownloadResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// StudentServiceServer is the server API for StudentService service.
+// All implementations must embed UnimplementedStudentServiceServer
+// for forward compatibility
+//
+// StudentService reads and writes the students of the caller's tenant. The
+// tenant is taken from the bearer token of the authorization metadata and,
+// when TENANT_SOURCES turns them on, from x-tenant-id and the subdomain of
+// :authority; the client from x-api-key, like the HTTP headers.
+type StudentServiceServer interface {
+	// Get returns a student. Trashed students are not found.
+	Get(context.Context, *GetRequest) (*Student, error)
+	// Put creates or replaces a student. A student without an ID gets a
+	// generated one.
+	Put(context.Context, *PutRequest) (*PutResponse, error)
+	// Delete moves a student to the trash, or deletes it for good.
+	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	// Restore takes a student out of the trash, or recreates one that was
+	// deleted for good from its last revision.
+	Restore(context.Context, *RestoreRequest) (*RestoreResponse, error)
+	// List streams every student in ID order.
+	List(*ListRequest, StudentService_ListServer) error
+	// WatchChanges streams changes of students until the call is cancelled.
+	WatchChanges(*WatchChangesRequest, StudentService_WatchChangesServer) error
+	// Upload stores an attachment: the first message names it, the following
+	// ones carry its content.
+	Upload(StudentService_UploadServer) error
+	// Download streams an attachment: the first message describes it, the
+	// following ones carry its content.
+	Download(*DownloadRequest, StudentService_DownloadServer) error
+	mustEmbedUnimplementedStudentServiceServer()
+}
+
+// UnimplementedStudentServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedStudentServiceServer struct {
+}
+
+func (UnimplementedStudentServiceServer) Get(context.Context, *GetRequest) (*Student, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedStudentServiceServer) Put(context.Context, *PutRequest) (*PutResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Put not implemented")
+}
+func (UnimplementedStudentServiceServer) Delete(context.Context, *DeleteRequest) (*DeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedStudentServiceServer) Restore(context.Context, *RestoreRequest) (*RestoreResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Restore not implemented")
+}
+func (UnimplementedStudentServiceServer) List(*ListRequest, StudentService_ListServer) error {
+	return status.Errorf(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedStudentServiceServer) WatchChanges(*WatchChangesRequest, StudentService_WatchChangesServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchChanges not implemented")
+}
+func (UnimplementedStudentServiceServer) Upload(StudentService_UploadServer) error {
+	return status.Errorf(codes.Unimplemented, "method Upload not implemented")
+}
+func (UnimplementedStudentServiceServer) Download(*DownloadRequest, StudentService_DownloadServer) error {
+	return status.Errorf(codes.Unimplemented, "method Download not implemented")
+}
+func (UnimplementedStudentServiceServer) mustEmbedUnimplementedStudentServiceServer() {}
+
+// UnsafeStudentServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to StudentServiceServer will
+// result in compilation errors.
+type UnsafeStudentServiceServer interface {
+	mustEmbedUnimplementedStudentServiceServer()
+}
+
+func RegisterStudentServiceServer(s grpc.ServiceRegistrar, srv StudentServiceServer) {
+	s.RegisterService(&StudentService_ServiceDesc, srv)
+}
+
+func _StudentService_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StudentServiceServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: StudentService_Get_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StudentServiceServer).Get(ctx, req.(*GetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StudentService_Put_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PutRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StudentServiceServer).Put(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: StudentService_Put_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StudentServiceServer).Put(ctx, req.(*PutRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StudentService_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StudentServiceServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: StudentService_Delete_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StudentServiceServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StudentService_Restore_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RestoreRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StudentServiceServer).Restore(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: StudentService_Restore_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StudentServiceServer).Restore(ctx, req.(*RestoreRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StudentService_List_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(StudentServiceServer).List(m, &studentServiceListServer{ServerStream: stream})
+}
+
+type StudentService_ListServer interface {
+	Send(*Student) error
+	grpc.ServerStream
+}
+
+type studentServiceListServer struct {
+	grpc.ServerStream
+}
+
+func (x *studentServiceListServer) Send(m *Student) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _StudentService_WatchChanges_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchChangesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(StudentServiceServer).WatchChanges(m, &studentServiceWatchChangesServer{ServerStream: stream})
+}
+
+type StudentService_WatchChangesServer interface {
+	Send(*Change) error
+	grpc.ServerStream
+}
+
+type studentServiceWatchChangesServer struct {
+	grpc.ServerStream
+}
+
+func (x *studentServiceWatchChangesServer) Send(m *Change) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _StudentService_Upload_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(StudentServiceServer).Upload(&studentServiceUploadServer{ServerStream: stream})
+}
+
+type StudentService_UploadServer interface {
+	SendAndClose(*UploadResponse) error
+	Recv() (*UploadRequest, error)
+	grpc.ServerStream
+}
+
+type studentServiceUploadServer struct {
+	grpc.ServerStream
+}
+
+func (x *studentServiceUploadServer) SendAndClose(m *UploadResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *studentServiceUploadServer) Recv() (*UploadRequest, error) {
+	m := new(UploadRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _StudentService_Download_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(DownloadRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(StudentServiceServer).Download(m, &studentServiceDownloadServer{ServerStream: stream})
+}
+
+type StudentService_DownloadServer interface {
+	Send(*DownloadResponse) error
+	grpc.ServerStream
+}
+
+type studentServiceDownloadServer struct {
+	grpc.ServerStream
+}
+
+func (x *studentServiceDownloadServer) Send(m *DownloadResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// StudentService_ServiceDesc is the grpc.ServiceDesc for StudentService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var StudentService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "student.v1.StudentService",
+	HandlerType: (*StudentServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Get",
+			Handler:    _StudentService_Get_Handler,
+		},
+		{
+			MethodName: "Put",
+			Handler:    _StudentService_Put_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _StudentService_Delete_Handler,
+		},
+		{
+			MethodName: "Restore",
+			Handler:    _StudentService_Restore_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "List",
+			Handler:       _StudentService_List_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchChanges",
+			Handler:       _StudentService_WatchChanges_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Upload",
+			Handler:       _StudentService_Upload_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Download",
+			Handler:       _StudentService_Download_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "studentpb/student.proto",
+}
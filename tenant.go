@@ -0,0 +1,516 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+// defaultDB is the student database of requests that name no tenant.
+const defaultDB = "student"
+
+// Tenant databases are named tenant_<id>_student.
+const (
+	tenantDBPrefix = "tenant_"
+	tenantDBSuffix = "_student"
+)
+
+// Gin context keys set by tenantScope.
+const (
+	tenantKey   = "tenant"
+	tenantDBKey = "tenant_db"
+)
+
+var (
+	// tenantSources are where tenants are resolved from: a claim of the
+	// bearer token, the subdomain of tenantDomain and the tenantHeader. Only
+	// the token is verified, so the subdomain and the header have to be
+	// turned on explicitly.
+	tenantSources = envList("TENANT_SOURCES", []string{"token"})
+	// tenantDomain is the domain whose subdomains name tenants, e.g.
+	// schools.example.com for greenfield.schools.example.com.
+	tenantDomain = strings.ToLower(envString("TENANT_DOMAIN", ""))
+	tenantHeader = envString("TENANT_HEADER", "X-Tenant-ID")
+	// tenantTokenSecret verifies HS256 bearer tokens carrying tenantClaim.
+	// Tokens are ignored without it.
+	tenantTokenSecret = envString("TENANT_TOKEN_SECRET", "")
+	tenantClaim       = envString("TENANT_CLAIM", "tenant")
+	// requireTenant refuses requests that name no tenant instead of serving
+	// them from defaultDB.
+	requireTenant = envBool("REQUIRE_TENANT", false)
+	// allowedTenants restricts the tenants to a fixed list. Their databases,
+	// and those of tenants named by a verified token, are created on first
+	// use; other tenants need an existing database.
+	allowedTenants = envList("TENANTS", nil)
+)
+
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+var errInvalidToken = errors.New("invalid bearer token")
+
+// errUnknownTenant is returned for a tenant without a database that may not
+// be created on demand.
+var errUnknownTenant = errors.New("unknown tenant")
+
+// tenantError is a tenant resolution failure and the status it is answered
+// with.
+type tenantError struct {
+	status int
+	msg    string
+}
+
+func (e *tenantError) Error() string { return e.msg }
+
+// tenantDBName returns the student database of tenant id.
+func tenantDBName(id string) string {
+	if id == "" {
+		return defaultDB
+	}
+	return tenantDBPrefix + id + tenantDBSuffix
+}
+
+// tenantOfDB returns the tenant whose student database db is, "" for
+// defaultDB.
+func tenantOfDB(db string) string {
+	id, _ := strings.CutPrefix(db, tenantDBPrefix)
+	return strings.TrimSuffix(id, tenantDBSuffix)
+}
+
+// isTenantDB reports whether db is the student database of a tenant.
+func isTenantDB(db string) bool {
+	return strings.HasPrefix(db, tenantDBPrefix) && strings.HasSuffix(db, tenantDBSuffix) &&
+		len(db) > len(tenantDBPrefix)+len(tenantDBSuffix)
+}
+
+// tenantOf returns the tenant of the request, "" when it uses defaultDB.
+func tenantOf(c *gin.Context) string {
+	return c.GetString(tenantKey)
+}
+
+// studentDBName returns the student database of the request's tenant.
+func studentDBName(c *gin.Context) string {
+	if db := c.GetString(tenantDBKey); db != "" {
+		return db
+	}
+	return defaultDB
+}
+
+// studentDB returns the student database of the request's tenant.
+func studentDB(c *gin.Context) *kivik.DB {
+	return client.DB(studentDBName(c))
+}
+
+// studentGroup returns a group of routes under path that work on the students
+// of the request's tenant. Requests are rate limited by the limit group
+// before the tenant is looked up, so that rejected ones cost no CouchDB round
+// trip, and writes can then be retried safely with an Idempotency-Key.
+func studentGroup(r gin.IRouter, path, limit string) *gin.RouterGroup {
+	return r.Group(path, rateLimit(limit), tenantScope, idempotency)
+}
+
+// tenantScope resolves the tenant of the request and makes sure its database
+// exists. Handlers reach the database through studentDB.
+func tenantScope(c *gin.Context) {
+	id, db, err := scopeTenant(c.Request.Context(), c.Request.Host, c.GetHeader)
+	enterTenant(c, id, db, err)
+}
+
+// adminTenantScope is tenantScope for the admin routes, which authenticate
+// with basic auth and so carry no bearer token. The tenant is named by the
+// tenant query parameter instead, defaultDB being used without it. With
+// create, a missing tenant database is created.
+func adminTenantScope(create bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := strings.ToLower(strings.TrimSpace(c.Query("tenant")))
+		var db string
+		err := checkTenant(id)
+		if err == nil && id != "" {
+			db, err = studentDBs.prepare(c.Request.Context(), id, create)
+			if errors.Is(err, errUnknownTenant) {
+				err = &tenantError{http.StatusNotFound, "Unknown tenant."}
+			}
+		}
+		enterTenant(c, id, db, err)
+	}
+}
+
+// enterTenant continues a request in tenant id with student database db, or
+// answers it with err, the failure to resolve or prepare them.
+func enterTenant(c *gin.Context, id, db string, err error) {
+	var te *tenantError
+	if errors.As(err, &te) {
+		respondProblem(c, te.status, statusCode(te.status), te.msg)
+		return
+	}
+	if err != nil {
+		logError(c, "Failed to prepare tenant database", err, "tenant", id)
+		respondProblem(c, http.StatusServiceUnavailable, codeUnavailable, "Tenant database is not available.")
+		return
+	}
+	if id != "" {
+		c.Set(tenantKey, id)
+		c.Set(tenantDBKey, db)
+	}
+	c.Next()
+}
+
+// scopeTenant resolves the tenant of a request to host with the given
+// headers and prepares its database. It returns "" and defaultDB for
+// requests without a tenant. A request that names no valid tenant fails with
+// a *tenantError.
+func scopeTenant(ctx context.Context, host string, header func(name string) string) (id, db string, err error) {
+	id, verified, err := resolveTenant(host, header)
+	if err != nil || id == "" {
+		return "", defaultDB, err
+	}
+	create := verified || slices.Contains(allowedTenants, id)
+	db, err = studentDBs.prepare(ctx, id, create)
+	if errors.Is(err, errUnknownTenant) {
+		return "", defaultDB, &tenantError{http.StatusNotFound, "Unknown tenant."}
+	}
+	return id, db, err
+}
+
+// resolveTenant returns the tenant named by a request to host with the given
+// headers, and whether a verified token names it. Requests whose sources
+// name different tenants, such as a header naming another tenant than the
+// token, are refused.
+func resolveTenant(host string, header func(name string) string) (id string, verified bool, err error) {
+	var source string
+	for _, s := range tenantSources {
+		var candidate string
+		switch s {
+		case "token":
+			claim, err := tokenTenant(header("Authorization"), time.Now())
+			if err != nil {
+				return "", false, &tenantError{http.StatusUnauthorized, "Bearer token rejected: " + err.Error()}
+			}
+			candidate = claim
+			verified = claim != ""
+		case "subdomain":
+			candidate = subdomainTenant(host)
+		case "header":
+			candidate = strings.TrimSpace(header(tenantHeader))
+		}
+		candidate = strings.ToLower(candidate)
+		switch {
+		case candidate == "":
+		case id == "":
+			id, source = candidate, s
+		case candidate != id:
+			return "", false, &tenantError{http.StatusForbidden, "The " + source + " and the " + s + " name different tenants."}
+		}
+	}
+
+	if err := checkTenant(id); err != nil || id == "" {
+		return "", false, err
+	}
+	return id, verified, nil
+}
+
+// checkTenant fails with a *tenantError unless id, "" for none, is a tenant
+// requests may name.
+func checkTenant(id string) error {
+	switch {
+	case id == "" && requireTenant:
+		return &tenantError{http.StatusBadRequest, "The request names no tenant."}
+	case id == "":
+		return nil
+	case !tenantIDPattern.MatchString(id):
+		return &tenantError{http.StatusBadRequest, "Invalid tenant ID."}
+	case len(allowedTenants) > 0 && !slices.Contains(allowedTenants, id):
+		return &tenantError{http.StatusNotFound, "Unknown tenant."}
+	}
+	return nil
+}
+
+// subdomainTenant returns the subdomain of tenantDomain host names, if any.
+func subdomainTenant(host string) string {
+	if tenantDomain == "" {
+		return ""
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	sub, ok := strings.CutSuffix(strings.ToLower(host), "."+tenantDomain)
+	if !ok || strings.Contains(sub, ".") {
+		return ""
+	}
+	return sub
+}
+
+// tokenTenant returns the tenantClaim of an HS256 bearer token signed with
+// tenantTokenSecret, or "" when authorization carries no bearer token.
+func tokenTenant(authorization string, now time.Time) (string, error) {
+	claims, err := tokenClaims(authorization, now)
+	id, _ := claims[tenantClaim].(string)
+	return id, err
+}
+
+// tokenSubject returns the subject of the verified bearer token of c, or ""
+// without one.
+func tokenSubject(c *gin.Context) string {
+	claims, err := tokenClaims(c.GetHeader("Authorization"), time.Now())
+	if err != nil {
+		return ""
+	}
+	sub, _ := claims["sub"].(string)
+	return sub
+}
+
+// tokenClaims verifies an HS256 bearer token signed with tenantTokenSecret
+// and returns its claims, or nil when authorization carries no bearer token.
+func tokenClaims(authorization string, now time.Time) (map[string]interface{}, error) {
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || tenantTokenSecret == "" {
+		return nil, nil
+	}
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return nil, errInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeTokenPart(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return nil, errInvalidToken
+	}
+	mac := hmac.New(sha256.New, []byte(tenantTokenSecret))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, errInvalidToken
+	}
+	var claims map[string]interface{}
+	if err := decodeTokenPart(parts[1], &claims); err != nil {
+		return nil, errInvalidToken
+	}
+	if exp, ok := claims["exp"].(float64); ok && now.Unix() >= int64(exp) {
+		return nil, fmt.Errorf("bearer token expired")
+	}
+	return claims, nil
+}
+
+func decodeTokenPart(part string, dest interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dest)
+}
+
+// studentDBs tracks the tenant databases this instance has prepared.
+var studentDBs = &dbRegistry{ready: map[string]bool{}, unknown: map[string]time.Time{}}
+
+// unknownTenantTTL is how long a tenant database found missing is remembered
+// as such, so that requests naming unknown tenants do not each ask CouchDB.
+const unknownTenantTTL = 30 * time.Second
+
+// dbRegistry prepares tenant databases on first use and tells the change
+// followers about them.
+type dbRegistry struct {
+	mu    sync.Mutex
+	ready map[string]bool
+	// unknown holds when databases found missing may be looked up again.
+	unknown     map[string]time.Time
+	subscribers []chan<- string
+}
+
+// prepare creates the database of tenant id unless this instance already
+// did, and returns its name. Without create, only an existing database is
+// prepared, and a missing one fails with errUnknownTenant for
+// unknownTenantTTL. Concurrent first requests may both prepare it, which is
+// harmless.
+func (r *dbRegistry) prepare(ctx context.Context, id string, create bool) (string, error) {
+	db := tenantDBName(id)
+	now := time.Now()
+	r.mu.Lock()
+	ready := r.ready[db]
+	unknown := now.Before(r.unknown[db])
+	r.mu.Unlock()
+	if ready {
+		return db, nil
+	}
+	if !create {
+		if unknown {
+			return "", errUnknownTenant
+		}
+		exists, err := client.DBExists(ctx, db)
+		observeCouch("DBExists", now, err)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			r.mu.Lock()
+			for name, until := range r.unknown {
+				if !now.Before(until) {
+					delete(r.unknown, name)
+				}
+			}
+			r.unknown[db] = now.Add(unknownTenantTTL)
+			r.mu.Unlock()
+			return "", errUnknownTenant
+		}
+	}
+
+	for _, name := range []string{db, lockDBName(db)} {
+		err := ensureDatabase(ctx, name)
+		if err != nil && kivik.HTTPStatus(err) != http.StatusPreconditionFailed {
+			return "", err
+		}
+		if err := setTenantSecurity(ctx, id, name); err != nil {
+			return "", err
+		}
+	}
+	copyDesignDocs(ctx, db)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ready[db] = true
+	delete(r.unknown, db)
+	for _, ch := range r.subscribers {
+		select {
+		case ch <- db:
+		default:
+			// The follower picks the database up on its next scan.
+		}
+	}
+	return db, nil
+}
+
+func (r *dbRegistry) subscribe(ch chan<- string) (unsubscribe func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subscribers = append(r.subscribers, ch)
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.subscribers = slices.DeleteFunc(r.subscribers, func(s chan<- string) bool { return s == ch })
+	}
+}
+
+// tenantRole is the CouchDB role of the users of tenant id.
+func tenantRole(id string) string {
+	return "tenant_" + id
+}
+
+// setTenantSecurity limits the members of db, a database of tenant id, to
+// users with the tenant's role, so that CouchDB users of one school cannot
+// read another's database. Server admins, such as the API itself, keep access.
+func setTenantSecurity(ctx context.Context, id, db string) error {
+	security := map[string]interface{}{
+		"admins":  map[string]interface{}{"names": []string{}, "roles": []string{"_admin"}},
+		"members": map[string]interface{}{"names": []string{}, "roles": []string{tenantRole(id)}},
+	}
+	start := time.Now()
+	err := couchDo(ctx, couchHTTP, http.MethodPut, couchEndpoint(db+"/_security"), security, nil)
+	observeCouch("PutSecurity", start, err)
+	return err
+}
+
+// copyDesignDocs copies the requiredDesignDocs of defaultDB into db. The
+// routes they back fail on db until it has them, so a failure is only
+// logged.
+func copyDesignDocs(ctx context.Context, db string) {
+	for _, id := range requiredDesignDocs {
+		var doc map[string]interface{}
+		start := time.Now()
+		err := client.DB(defaultDB).Get(ctx, id).ScanDoc(&doc)
+		observeCouch("Get", start, err)
+		if err == nil {
+			delete(doc, "_rev")
+			start = time.Now()
+			_, err = client.DB(db).Put(ctx, id, doc)
+			observeCouch("Put", start, err)
+		}
+		if err != nil && kivik.HTTPStatus(err) != http.StatusConflict {
+			slog.WarnContext(ctx, "Failed to copy design document", "db", db, "ddoc", id, "error", err)
+		}
+	}
+}
+
+// studentDatabases lists defaultDB and every tenant database.
+func studentDatabases(ctx context.Context) ([]string, error) {
+	start := time.Now()
+	all, err := client.AllDBs(ctx)
+	observeCouch("AllDBs", start, err)
+	if err != nil {
+		return nil, err
+	}
+	dbs := []string{defaultDB}
+	for _, db := range all {
+		if isTenantDB(db) {
+			dbs = append(dbs, db)
+		}
+	}
+	return dbs, nil
+}
+
+// studentDBScan is how often followStudentDBs looks for databases other
+// instances created.
+const studentDBScan = time.Minute
+
+// followStudentDBs runs fn for every student database, including those
+// created while it runs, until ctx is done. It returns once every fn has
+// returned.
+func followStudentDBs(ctx context.Context, fn func(ctx context.Context, db string)) {
+	added := make(chan string, 16)
+	defer studentDBs.subscribe(added)()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	running := map[string]bool{}
+	run := func(db string) {
+		if running[db] {
+			return
+		}
+		running[db] = true
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(ctx, db)
+		}()
+	}
+	scan := func(time.Time) {
+		dbs, err := studentDatabases(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Warn("Failed to list student databases", "error", err)
+			}
+			return
+		}
+		for _, db := range dbs {
+			run(db)
+		}
+	}
+
+	run(defaultDB)
+	scan(time.Now())
+	ticker := time.NewTicker(studentDBScan)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case db := <-added:
+			run(db)
+		case now := <-ticker.C:
+			scan(now)
+		}
+	}
+}
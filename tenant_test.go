@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAdminRoutesUseTenantParameter(t *testing.T) {
+	f := newFakeCouch(t, defaultDB, "tenant_acme_student", auditDB)
+	f.store(f.dbs[defaultDB], "s-default", map[string]interface{}{"name": "Default"}, false)
+	f.store(f.dbs["tenant_acme_student"], "s-acme", map[string]interface{}{"name": "Acme"}, false)
+	f.store(f.dbs[auditDB], "a1", map[string]interface{}{"time": "2026-01-01T00:00:00.000Z", "doc_id": "s-default", "action": "insert"}, false)
+	f.store(f.dbs[auditDB], "a2", map[string]interface{}{"time": "2026-01-01T00:00:00.000Z", "doc_id": "s-acme", "action": "insert", "tenant": "acme"}, false)
+
+	defer func(accounts gin.Accounts) { adminAccounts = accounts }(adminAccounts)
+	adminAccounts = gin.Accounts{"admin": "secret"}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerLegacyRoutes(r)
+	registerV1Routes(r)
+	registerAdminRoutes(r)
+	get := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.SetBasicAuth("admin", "secret")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/admin/export?tenant=acme")
+	if w.Code != http.StatusOK {
+		t.Fatalf("export: status = %d, body %s", w.Code, w.Body)
+	}
+	if !strings.Contains(w.Body.String(), `"s-acme"`) || strings.Contains(w.Body.String(), `"s-default"`) {
+		t.Errorf("export of acme = %s, want only its students", w.Body)
+	}
+	if got := w.Header().Get("Content-Disposition"); got != "attachment; filename=tenant_acme_student.ndjson" {
+		t.Errorf("Content-Disposition = %q, want the name of acme's database", got)
+	}
+
+	for _, route := range []string{"/audit", "/v1/audit"} {
+		w = get(route + "?tenant=acme")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, body %s", route, w.Code, w.Body)
+		}
+		var resp AuditResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Records) != 1 || resp.Records[0].DocID != "s-acme" {
+			t.Errorf("%s of acme = %+v, want the record of s-acme", route, resp.Records)
+		}
+	}
+
+	for target, want := range map[string]int{
+		"/admin/export?tenant=../x":   http.StatusBadRequest,
+		"/admin/export?tenant=_users": http.StatusBadRequest,
+		"/admin/export?tenant=ghost":  http.StatusNotFound,
+		"/v1/audit?tenant=ghost":      http.StatusNotFound,
+	} {
+		if w := get(target); w.Code != want {
+			t.Errorf("%s: status = %d, want %d", target, w.Code, want)
+		}
+	}
+}
+
+func TestPrepareRemembersUnknownTenants(t *testing.T) {
+	f := newFakeCouch(t)
+	r := &dbRegistry{ready: map[string]bool{}, unknown: map[string]time.Time{}}
+	ctx := context.Background()
+	if _, err := r.prepare(ctx, "acme", false); !errors.Is(err, errUnknownTenant) {
+		t.Fatalf("prepare of a missing tenant = %v, want errUnknownTenant", err)
+	}
+
+	// The database appearing is only noticed once the TTL has passed.
+	f.mu.Lock()
+	f.dbs["tenant_acme_student"] = map[string]*fakeDoc{}
+	f.mu.Unlock()
+	if _, err := r.prepare(ctx, "acme", false); !errors.Is(err, errUnknownTenant) {
+		t.Errorf("prepare within the TTL = %v, want errUnknownTenant", err)
+	}
+	r.unknown["tenant_acme_student"] = time.Now()
+	if db, err := r.prepare(ctx, "acme", false); err != nil || db != "tenant_acme_student" {
+		t.Errorf("prepare after the TTL = %q, %v", db, err)
+	}
+}
+
+// signToken returns a bearer token of claims signed with secret, using alg
+// as the header's algorithm.
+func signToken(t *testing.T, alg, secret string, claims map[string]interface{}) string {
+	t.Helper()
+	part := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	unsigned := part(map[string]string{"alg": alg, "typ": "JWT"}) + "." + part(claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return "Bearer " + unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestResolveTenant(t *testing.T) {
+	defer func(sources []string, secret string) {
+		tenantSources, tenantTokenSecret = sources, secret
+	}(tenantSources, tenantTokenSecret)
+	tenantSources = []string{"token", "header"}
+	tenantTokenSecret = "secret"
+	// unsigned drops the signature of token, as alg none asks for.
+	unsigned := func(token string) string { return token[:strings.LastIndex(token, ".")+1] }
+	future := time.Now().Add(time.Hour).Unix()
+	past := time.Now().Add(-time.Hour).Unix()
+
+	tests := []struct {
+		name          string
+		authorization string
+		header        string
+		want          string
+		status        int // of the *tenantError, 0 for none
+	}{
+		{
+			name:          "valid token",
+			authorization: signToken(t, "HS256", "secret", map[string]interface{}{"tenant": "acme", "exp": future}),
+			want:          "acme",
+		},
+		{
+			name:          "bad signature",
+			authorization: signToken(t, "HS256", "other", map[string]interface{}{"tenant": "acme"}),
+			status:        http.StatusUnauthorized,
+		},
+		{
+			name:          "alg mismatch",
+			authorization: signToken(t, "HS512", "secret", map[string]interface{}{"tenant": "acme"}),
+			status:        http.StatusUnauthorized,
+		},
+		{
+			name:          "alg none",
+			authorization: unsigned(signToken(t, "none", "secret", map[string]interface{}{"tenant": "acme"})),
+			status:        http.StatusUnauthorized,
+		},
+		{
+			name:          "expired token",
+			authorization: signToken(t, "HS256", "secret", map[string]interface{}{"tenant": "acme", "exp": past}),
+			status:        http.StatusUnauthorized,
+		},
+		{
+			name:          "header agreeing with the token",
+			authorization: signToken(t, "HS256", "secret", map[string]interface{}{"tenant": "acme"}),
+			header:        "ACME",
+			want:          "acme",
+		},
+		{
+			name:          "header conflicting with the token",
+			authorization: signToken(t, "HS256", "secret", map[string]interface{}{"tenant": "acme"}),
+			header:        "globex",
+			status:        http.StatusForbidden,
+		},
+		{
+			name:   "header naming a CouchDB system database",
+			header: "_users",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "header naming a path",
+			header: "../x",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:          "token naming a CouchDB system database",
+			authorization: signToken(t, "HS256", "secret", map[string]interface{}{"tenant": "_users"}),
+			status:        http.StatusBadRequest,
+		},
+		{
+			name: "no tenant",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := func(name string) string {
+				switch name {
+				case "Authorization":
+					return tt.authorization
+				case tenantHeader:
+					return tt.header
+				}
+				return ""
+			}
+			id, _, err := resolveTenant("api.example.com", header)
+			var te *tenantError
+			switch {
+			case tt.status == 0 && err != nil:
+				t.Fatalf("resolveTenant failed: %v", err)
+			case tt.status != 0 && (!errors.As(err, &te) || te.status != tt.status):
+				t.Fatalf("resolveTenant = %q, %v; want a tenant error with status %d", id, err, tt.status)
+			case id != tt.want:
+				t.Errorf("resolveTenant = %q, want %q", id, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestCouchDBSpanIsChildOfRequestSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var traceparent string
+	couch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.Header().Set("ETag", `"1-a"`)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"_id":"s1","_rev":"1-a","name":"Ada"}`))
+	}))
+	defer couch.Close()
+	couchURL = couch.URL
+	if err := connectCouchDB(); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(otelgin.Middleware(serviceName))
+	r.GET("/document/:id", getDocumentByIDHandler)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/document/s1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+
+	var server, couchSpan sdktrace.ReadOnlySpan
+	for _, s := range recorder.Ended() {
+		switch s.SpanKind() {
+		case trace.SpanKindServer:
+			server = s
+		case trace.SpanKindClient:
+			couchSpan = s
+		}
+	}
+	if server == nil || server.Name() != "/document/:id" {
+		t.Fatalf("no gin server span for /document/:id in %v", recorder.Ended())
+	}
+	if couchSpan == nil || couchSpan.Name() != "CouchDB GET" {
+		t.Fatalf("no CouchDB client span in %v", recorder.Ended())
+	}
+	if couchSpan.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Errorf("CouchDB span parent = %s, want the server span %s", couchSpan.Parent().SpanID(), server.SpanContext().SpanID())
+	}
+
+	want := "00-" + couchSpan.SpanContext().TraceID().String() + "-" + couchSpan.SpanContext().SpanID().String() + "-01"
+	if traceparent != want {
+		t.Errorf("traceparent = %q, want %q", traceparent, want)
+	}
+}
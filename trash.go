@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+// trashField marks a soft-deleted document with the time it was deleted.
+const trashField = "deleted_at"
+
+var (
+	// softDelete makes DELETE /document/:docID move documents to the trash
+	// instead of deleting them. It is off unless SOFT_DELETE is set, so DELETE
+	// keeps deleting for good.
+	softDelete = envBool("SOFT_DELETE", false)
+	// trashRetention is how long documents stay in the trash before they are
+	// purged.
+	trashRetention = time.Duration(envInt("TRASH_RETENTION_DAYS", 30)) * 24 * time.Hour
+)
+
+// errNotRecoverable is returned when a deleted document's last revision has
+// been removed by compaction.
+var errNotRecoverable = errors.New("the last revision of the document is no longer available")
+
+// errNotDeleted is returned when restoring a document that is not deleted.
+// It carries CouchDB's conflict status.
+var errNotDeleted = &kivik.Error{Status: http.StatusConflict, Message: "document is not deleted"}
+
+type TrashItem struct {
+	ID        string                 `json:"id"`
+	Rev       string                 `json:"rev"`
+	DeletedAt string                 `json:"deleted_at"`
+	Doc       map[string]interface{} `json:"doc"`
+}
+
+type TrashResponse struct {
+	Items []TrashItem `json:"items"`
+	// Bookmark fetches the next page when passed back.
+	Bookmark string `json:"bookmark,omitempty"`
+}
+
+// isTrashed reports whether doc has been soft-deleted.
+func isTrashed(doc map[string]interface{}) bool {
+	_, ok := doc[trashField]
+	return ok
+}
+
+// dropTrashedRows removes soft-deleted documents from an _all_docs result
+// fetched with include_docs.
+func dropTrashedRows(result map[string]interface{}) {
+	rows, ok := result["rows"].([]interface{})
+	if !ok {
+		return
+	}
+	kept := rows[:0]
+	for _, row := range rows {
+		if r, ok := row.(map[string]interface{}); ok {
+			if doc, ok := r["doc"].(map[string]interface{}); ok && isTrashed(doc) {
+				continue
+			}
+		}
+		kept = append(kept, row)
+	}
+	result["rows"] = kept
+}
+
+// ensureTrashIndex creates the Mango index trash queries use.
+func ensureTrashIndex(ctx context.Context, db *kivik.DB) error {
+	return db.CreateIndex(ctx, "trash", "by-deleted-at", map[string]interface{}{"fields": []string{trashField}})
+}
+
+// findTrash returns soft-deleted documents, most recently deleted first.
+// With before set, only documents deleted before then are returned.
+func findTrash(ctx context.Context, db *kivik.DB, before time.Time, limit int, bookmark string) ([]map[string]interface{}, string, error) {
+	if err := ensureTrashIndex(ctx, db); err != nil {
+		return nil, "", err
+	}
+	cond := map[string]interface{}{"$gt": nil}
+	if !before.IsZero() {
+		cond = map[string]interface{}{"$gt": nil, "$lt": before.UTC().Format(time.RFC3339)}
+	}
+	query := map[string]interface{}{
+		"selector":  map[string]interface{}{trashField: cond},
+		"sort":      []interface{}{map[string]string{trashField: "desc"}},
+		"limit":     limit,
+		"conflicts": true,
+	}
+	if bookmark != "" {
+		query["bookmark"] = bookmark
+	}
+
+	start := time.Now()
+	rs := db.Find(ctx, query)
+	defer rs.Close()
+	var docs []map[string]interface{}
+	for rs.Next() {
+		var doc map[string]interface{}
+		if err := rs.ScanDoc(&doc); err != nil {
+			return nil, "", err
+		}
+		docs = append(docs, doc)
+	}
+	err := rs.Err()
+	observeCouch("Find", start, err)
+	if err != nil {
+		return nil, "", err
+	}
+	meta, err := rs.Metadata()
+	if err != nil {
+		return nil, "", err
+	}
+	return docs, meta.Bookmark, nil
+}
+
+// trashDocument soft-deletes doc, which must carry its _rev.
+func trashDocument(ctx context.Context, db *kivik.DB, docID string, doc map[string]interface{}) (string, error) {
+	doc[trashField] = time.Now().UTC().Format(time.RFC3339)
+	start := time.Now()
+	rev, err := db.Put(ctx, docID, doc)
+	observeCouch("Put", start, err)
+	return rev, err
+}
+
+// deletedRevision returns the body of the revision before the tombstone of a
+// deleted document of dbName, with attachments inlined when inline is set.
+func deletedRevision(ctx context.Context, dbName, docID string, inline bool) (map[string]interface{}, error) {
+	var leaves []struct {
+		OK *struct {
+			Deleted   bool `json:"_deleted"`
+			Revisions struct {
+				Start int      `json:"start"`
+				IDs   []string `json:"ids"`
+			} `json:"_revisions"`
+		} `json:"ok"`
+	}
+	docPath := dbName + "/" + url.PathEscape(docID)
+	start := time.Now()
+	err := couchDo(ctx, couchHTTP, http.MethodGet, couchEndpoint(docPath+"?revs=true&open_revs=all"), nil, &leaves)
+	observeCouch("Get", start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	var prev string
+	for _, leaf := range leaves {
+		if leaf.OK == nil || !leaf.OK.Deleted {
+			continue
+		}
+		if r := leaf.OK.Revisions; len(r.IDs) > 1 {
+			prev = fmt.Sprintf("%d-%s", r.Start-1, r.IDs[1])
+			break
+		}
+	}
+	if prev == "" {
+		return nil, &kivik.Error{Status: http.StatusNotFound, Message: "no deleted revision"}
+	}
+
+	var doc map[string]interface{}
+	start = time.Now()
+	query := "?rev=" + url.QueryEscape(prev)
+	if inline {
+		query += "&attachments=true"
+	}
+	err = couchDo(ctx, couchHTTP, http.MethodGet, couchEndpoint(docPath+query), nil, &doc)
+	observeCouch("Get", start, err)
+	if kivik.HTTPStatus(err) == http.StatusNotFound {
+		return nil, errNotRecoverable
+	}
+	return doc, err
+}
+
+// purgeTrash purges the documents of dbName that have been in the trash for
+// longer than trashRetention. Purged documents cannot be restored.
+func purgeTrash(ctx context.Context, dbName string, now time.Time) {
+	db := client.DB(dbName)
+	docs, _, err := findTrash(ctx, db, now.Add(-trashRetention), 500, "")
+	if err != nil {
+		slog.Warn("Failed to find expired trash", "db", dbName, "error", err)
+		return
+	}
+	if len(docs) == 0 {
+		return
+	}
+	revs := make(map[string][]string, len(docs))
+	for _, doc := range docs {
+		id, _ := doc["_id"].(string)
+		rev, _ := doc["_rev"].(string)
+		revs[id] = append(revs[id], rev)
+		// A conflicting revision would otherwise become the document.
+		if conflicts, ok := doc["_conflicts"].([]interface{}); ok {
+			for _, c := range conflicts {
+				if r, ok := c.(string); ok {
+					revs[id] = append(revs[id], r)
+				}
+			}
+		}
+	}
+	start := time.Now()
+	result, err := db.Purge(ctx, revs)
+	observeCouch("Purge", start, err)
+	if err != nil {
+		slog.Warn("Failed to purge trash", "db", dbName, "error", err)
+		return
+	}
+	for _, doc := range docs {
+		id, _ := doc["_id"].(string)
+		if _, ok := result.Purged[id]; ok {
+			releaseUnique(ctx, dbName, id, doc)
+		}
+	}
+	slog.Info("Purged expired trash", "db", dbName, "docs", len(result.Purged))
+}
+
+// purgeTrashPeriodically purges the expired trash of every student database
+// every interval.
+func purgeTrashPeriodically(ctx context.Context, interval time.Duration) {
+	every(ctx, interval, func(now time.Time) {
+		dbs, err := studentDatabases(ctx)
+		if err != nil {
+			slog.Warn("Failed to list student databases", "error", err)
+			return
+		}
+		for _, db := range dbs {
+			purgeTrash(ctx, db, now)
+		}
+	})
+}
+
+// listTrashHandler godoc
+// @Summary List the trash
+// @Description Lists soft-deleted documents, most recently deleted first. Documents are purged once they have been in
+// @Description the trash for the retention period.
+// @Tags trash
+// @Produce json
+// @Param limit query int false "Maximum number of documents" default(100)
+// @Param bookmark query string false "Bookmark of the previous page"
+// @Success 200 {object} TrashResponse
+// @Failure 400 {object} Problem "Invalid limit"
+// @Failure 429 {object} Problem "Rate limit or daily quota exceeded."
+// @Failure 500 {object} Problem "Failed to list trash"
+// @Deprecated
+// @Router /trash [get]
+func listTrashHandler(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 {
+		respondProblem(c, http.StatusBadRequest, codeInvalidRequest, "limit must be a positive number")
+		return
+	}
+	docs, bookmark, err := findTrash(c.Request.Context(), studentDB(c), time.Time{}, limit, c.Query("bookmark"))
+	if err != nil {
+		respondCouchError(c, "Failed to list trash", err)
+		return
+	}
+	resp := TrashResponse{Items: make([]TrashItem, len(docs))}
+	if len(docs) == limit {
+		resp.Bookmark = bookmark
+	}
+	for i, doc := range docs {
+		item := TrashItem{Doc: doc}
+		item.ID, _ = doc["_id"].(string)
+		item.Rev, _ = doc["_rev"].(string)
+		item.DeletedAt, _ = doc[trashField].(string)
+		resp.Items[i] = item
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// restoreTrashHandler godoc
+// @Summary Restore a deleted document
+// @Description Takes a document out of the trash. A document that was deleted permanently is recreated from its last
+// @Description revision, including attachments, as long as compaction has not removed it. Like an update, the document
+// @Description must match its schema and keep its unique values.
+// @Tags trash
+// @Produce json
+// @Param id path string true "Document ID"
+// @Success 200 {object} Response "Document restored"
+// @Failure 404 {object} Problem "Document not found"
+// @Failure 409 {object} Problem "Document is not deleted, or a unique value is already used"
+// @Failure 410 {object} Problem "Last revision no longer available"
+// @Failure 422 {object} Problem "Document does not match its schema"
+// @Failure 429 {object} Problem "Rate limit or daily quota exceeded."
+// @Failure 500 {object} Problem "Failed to restore document"
+// @Deprecated
+// @Router /trash/{id}/restore [post]
+func restoreTrashHandler(c *gin.Context) {
+	rev, err := studentsOf(c).restore(c.Request.Context(), c.Param("id"))
+	switch {
+	case errors.Is(err, errNotDeleted):
+		respondProblem(c, http.StatusConflict, codeConflict, "Document is not deleted")
+		return
+	case errors.Is(err, errDocumentNotFound):
+		respondProblem(c, http.StatusNotFound, codeNotFound, "Document not found")
+		return
+	case errors.Is(err, errNotRecoverable):
+		respondProblem(c, http.StatusGone, codeGone, "Document cannot be restored: "+err.Error())
+		return
+	case err != nil:
+		respondRepoError(c, "Failed to restore document", err)
+		return
+	}
+	c.JSON(http.StatusOK, Response{Message: "Document restored", Rev: rev})
+}
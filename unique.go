@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+// uniqueFields are the fields whose values no two documents of a type may
+// share, as "type.field" entries.
+var uniqueFields = func() map[string][]string {
+	fields := map[string][]string{}
+	for _, entry := range envList("UNIQUE_FIELDS", []string{"student.email"}) {
+		docType, field, ok := strings.Cut(entry, ".")
+		if !ok || field == "" {
+			slog.Warn("Ignoring invalid unique field", "entry", entry)
+			continue
+		}
+		fields[docType] = append(fields[docType], field)
+	}
+	return fields
+}()
+
+// uniqueLock is a companion document that reserves a value of a unique field
+// for its owner. Lock documents live in their own database next to each
+// student database, so they stay out of listings, exports and feeds. Creating
+// one fails with a conflict on every node of the cluster while another
+// document holds the value.
+type uniqueLock struct {
+	ID        string `json:"_id"`
+	Rev       string `json:"_rev,omitempty"`
+	DocType   string `json:"doc_type"`
+	Field     string `json:"field"`
+	Owner     string `json:"owner"`
+	CreatedAt string `json:"created_at"`
+}
+
+// uniqueLockGrace is how long a lock is held even though its owner does not
+// have the value, which is the case while the owner's write is under way.
+const uniqueLockGrace = time.Minute
+
+// uniqueError reports a value of a unique field that another document holds.
+type uniqueError struct {
+	field string
+	owner string
+}
+
+func (e *uniqueError) Error() string {
+	return e.field + " is already used by document " + e.owner
+}
+
+// lockDBName returns the database holding the unique locks of db.
+func lockDBName(db string) string {
+	return db + "_locks"
+}
+
+// uniqueLocks returns the locks doc needs, by lock ID.
+func uniqueLocks(doc map[string]interface{}) map[string]uniqueLock {
+	if doc == nil {
+		return nil
+	}
+	docType, err := documentType(doc)
+	if err != nil {
+		return nil
+	}
+	locks := map[string]uniqueLock{}
+	for _, field := range uniqueFields[docType] {
+		v, ok := selectorField(doc, field)
+		if !ok || v == nil || v == "" {
+			continue
+		}
+		if s, ok := v.(string); ok {
+			v = strings.ToLower(strings.TrimSpace(s))
+		}
+		b, _ := json.Marshal(v)
+		sum := sha256.Sum256(b)
+		id := docType + "." + field + ":" + hex.EncodeToString(sum[:16])
+		locks[id] = uniqueLock{ID: id, DocType: docType, Field: field}
+	}
+	return locks
+}
+
+// uniqueClaim is the set of locks a write of one document takes and gives up.
+type uniqueClaim struct {
+	db    *kivik.DB
+	owner string
+	// taken are the locks claimed for the write, released if it fails.
+	taken []uniqueLock
+	// obsolete are the locks of the previous version that the write no
+	// longer needs, released once it succeeded.
+	obsolete []string
+}
+
+// claimUnique takes the locks the new version after of document docID of db
+// needs. before is the current version, nil for a new document; its locks
+// are already held. A value held by another document fails with a
+// *uniqueError.
+func claimUnique(ctx context.Context, db, docID string, before, after map[string]interface{}) (*uniqueClaim, error) {
+	claim := &uniqueClaim{db: client.DB(lockDBName(db)), owner: docID}
+	held, wanted := uniqueLocks(before), uniqueLocks(after)
+	for id := range held {
+		if _, ok := wanted[id]; !ok {
+			claim.obsolete = append(claim.obsolete, id)
+		}
+	}
+	for id, lock := range wanted {
+		if _, ok := held[id]; ok {
+			continue
+		}
+		lock.Owner = docID
+		lock.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+		if err := claim.take(ctx, client.DB(db), lock); err != nil {
+			claim.abort(ctx)
+			return nil, err
+		}
+	}
+	return claim, nil
+}
+
+// take creates lock, or takes it over when its owner is gone or no longer
+// has the value, as happens when a write failed after claiming it or a
+// document was purged.
+func (u *uniqueClaim) take(ctx context.Context, students *kivik.DB, lock uniqueLock) error {
+	start := time.Now()
+	rev, err := u.db.Put(ctx, lock.ID, lock)
+	observeCouch("Put", start, err)
+	if kivik.HTTPStatus(err) != http.StatusConflict {
+		if err == nil {
+			lock.Rev = rev
+			u.taken = append(u.taken, lock)
+		}
+		return err
+	}
+
+	var current uniqueLock
+	start = time.Now()
+	err = u.db.Get(ctx, lock.ID).ScanDoc(&current)
+	observeCouch("Get", start, err)
+	if err != nil {
+		return err
+	}
+	if current.Owner == lock.Owner {
+		return nil
+	}
+	if created, err := time.Parse(time.RFC3339, current.CreatedAt); err == nil && time.Since(created) < uniqueLockGrace {
+		return &uniqueError{field: lock.Field, owner: current.Owner}
+	}
+	var owner map[string]interface{}
+	start = time.Now()
+	err = students.Get(ctx, current.Owner).ScanDoc(&owner)
+	observeCouch("Get", start, err)
+	if err != nil && kivik.HTTPStatus(err) != http.StatusNotFound {
+		return err
+	}
+	if _, holds := uniqueLocks(owner)[lock.ID]; holds {
+		return &uniqueError{field: lock.Field, owner: current.Owner}
+	}
+
+	lock.Rev = current.Rev
+	start = time.Now()
+	rev, err = u.db.Put(ctx, lock.ID, lock)
+	observeCouch("Put", start, err)
+	if kivik.HTTPStatus(err) == http.StatusConflict {
+		// Someone else took it over first.
+		return &uniqueError{field: lock.Field, owner: current.Owner}
+	}
+	if err != nil {
+		return err
+	}
+	lock.Rev = rev
+	// Released on failure by deleting, which frees it for the stale owner
+	// too; the stale owner does not hold the value anymore.
+	u.taken = append(u.taken, lock)
+	return nil
+}
+
+// commit releases the locks the written version no longer needs.
+func (u *uniqueClaim) commit(ctx context.Context) {
+	for _, id := range u.obsolete {
+		u.release(ctx, id, "")
+	}
+}
+
+// abort releases the locks taken for a write that failed.
+func (u *uniqueClaim) abort(ctx context.Context) {
+	for _, lock := range u.taken {
+		u.release(ctx, lock.ID, lock.Rev)
+	}
+}
+
+// release deletes a lock of the claim's owner. A failure leaves a stale lock,
+// which the next claim of the value takes over.
+func (u *uniqueClaim) release(ctx context.Context, id, rev string) {
+	ctx = context.WithoutCancel(ctx)
+	if rev == "" {
+		var lock uniqueLock
+		err := u.db.Get(ctx, id).ScanDoc(&lock)
+		if err != nil || lock.Owner != u.owner {
+			return
+		}
+		rev = lock.Rev
+	}
+	start := time.Now()
+	_, err := u.db.Delete(ctx, id, rev)
+	observeCouch("Delete", start, err)
+	if err != nil && kivik.HTTPStatus(err) != http.StatusNotFound {
+		slog.WarnContext(ctx, "Failed to release unique lock", "lock", id, "owner", u.owner, "error", err)
+	}
+}
+
+// releaseUnique releases every lock of doc, which has been deleted.
+func releaseUnique(ctx context.Context, db, docID string, doc map[string]interface{}) {
+	claim := &uniqueClaim{db: client.DB(lockDBName(db)), owner: docID}
+	for id := range uniqueLocks(doc) {
+		claim.obsolete = append(claim.obsolete, id)
+	}
+	claim.commit(ctx)
+}
+
+// respondUniqueError answers 409 for a unique value another document holds.
+func respondUniqueError(c *gin.Context, ue *uniqueError) {
+	p := newProblem(c, http.StatusConflict, codeUniqueViolation, fmt.Sprintf("The value of %s is already used.", ue.field))
+	p.Field = ue.field
+	p.DocID = ue.owner
+	writeProblem(c, p)
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// legacyDeprecatedAt is when the unversioned routes were deprecated in
+	// favour of /v1, from LEGACY_DEPRECATED_AT.
+	legacyDeprecatedAt = envDate("LEGACY_DEPRECATED_AT", "2026-10-18")
+	// legacySunset is when the unversioned routes are removed, from
+	// LEGACY_SUNSET.
+	legacySunset = envDate("LEGACY_SUNSET", "2027-06-30")
+)
+
+// envDate returns the date (YYYY-MM-DD) in the environment variable key, or
+// def when it is unset or invalid.
+func envDate(key, def string) time.Time {
+	value := envString(key, def)
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		slog.Warn("Ignoring invalid date", "key", key, "value", value)
+		t, _ = time.Parse(time.DateOnly, def)
+	}
+	return t
+}
+
+// registerV1Routes mounts the versioned, resource-oriented API. Its handlers
+// are those of the legacy routes, which stay until legacySunset.
+func registerV1Routes(r *gin.Engine) {
+	write := studentGroup(r, "/v1", "write")
+	read := studentGroup(r, "/v1", "read")
+	documents := studentGroup(r, "/v1", "documents")
+	documents.GET("/students", listStudentsHandler)
+	write.POST("/students", createStudentHandler)
+	read.GET("/students/:id", getStudentHandler)
+	write.PUT("/students/:id", invalidateCache, updateStudentHandler)
+	write.PATCH("/students/:id", invalidateCache, patchStudentHandler)
+	write.DELETE("/students/:id", invalidateCache, deleteStudentHandler)
+	write.POST("/students/:id/restore", invalidateCache, restoreStudentHandler)
+	write.POST("/students/:id/attachments", invalidateCache, uploadStudentAttachmentHandler)
+	read.GET("/students/:id/attachments/:name", getStudentAttachmentHandler)
+	write.POST("/students/import/csv", importStudentsCSVHandler)
+	write.POST("/students/import/xlsx", importStudentsXLSXHandler)
+	documents.GET("/changes", studentChangesHandler)
+	read.GET("/trash", listStudentTrashHandler)
+	r.GET("/v1/audit", adminAuth(), rateLimit("read"), adminTenantScope(false), studentAuditHandler)
+}
+
+// documentPath returns the path of document id in the API the request used.
+func documentPath(c *gin.Context, id string) string {
+	if strings.HasPrefix(c.FullPath(), "/v1/") {
+		return "/v1/students/" + url.PathEscape(id)
+	}
+	return "/document/" + url.PathEscape(id)
+}
+
+// deprecated marks a legacy route with the Deprecation and Sunset headers and
+// a link to successor, its /v1 route, and logs its use so that the clients
+// still on it can be found. successor may name the route's parameters, as
+// in "/v1/students/:docID"; without them, as with the document ID of
+// /upload, which is a form field, the link is left out.
+func deprecated(successor string) gin.HandlerFunc {
+	deprecation := "@" + strconv.FormatInt(legacyDeprecatedAt.Unix(), 10)
+	sunset := legacySunset.UTC().Format(http.TimeFormat)
+	return func(c *gin.Context) {
+		link := successor
+		for _, p := range c.Params {
+			link = strings.Replace(link, ":"+p.Key, url.PathEscape(p.Value), 1)
+		}
+		c.Header("Deprecation", deprecation)
+		c.Header("Sunset", sunset)
+		if !strings.Contains(link, ":") {
+			c.Header("Link", "<"+link+`>; rel="successor-version"`)
+		}
+		legacyRequests.WithLabelValues(c.FullPath()).Inc()
+		slog.InfoContext(c.Request.Context(), "Legacy route used",
+			"method", c.Request.Method, "route", c.FullPath(), "successor", successor,
+			"client", clientKey(c), "user_agent", c.Request.UserAgent())
+		c.Next()
+	}
+}
+
+// listStudentsHandler godoc
+// @Summary List students
+// @Description Lists the students, as CouchDB's _all_docs result or, with format or Accept, as streamed flattened
+// @Description records (flat, ndjson, csv or xlsx).
+// @Tags students
+// @Produce json,application/x-ndjson,text/csv,application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+// @Param format query string false "json, flat, ndjson, csv or xlsx; overrides Accept"
+// @Param columns query string false "Comma separated flattened fields to output, e.g. _id,name,address.city"
+// @Success 200 {array} map[string]interface{}
+// @Failure 400 {object} Problem "Unknown format"
+// @Failure 406 {object} Problem "None of the accepted media types is supported"
+// @Failure 429 {object} Problem "Rate limit or daily quota exceeded."
+// @Failure 500 {object} Problem "Failed to retrieve students"
+// @Router /v1/students [get]
+func listStudentsHandler(c *gin.Context) { getAllDocumentsHandler(c) }
+
+// createStudentHandler godoc
+// @Summary Create a student
+// @Description Creates a student with a server-generated ID and answers with its Location. The ID is a UUIDv7, which
+// @Description sorts by creation time, prefixed with the prefix configured for the document type, e.g. stu_0190b5a2-….
+// @Description The student is validated against the schema of its type, and values of unique fields, such as the
+// @Description email, are reserved across the cluster.
+// @Tags students
+// @Accept json
+// @Produce json
+// @Param student body map[string]interface{} true "Student without _id"
+// @Success 201 {object} CreatedResponse
+// @Header 201 {string} Location "Path of the new student"
+// @Failure 400 {object} Problem "Invalid student"
+// @Failure 409 {object} Problem "A unique value is already used"
+// @Failure 422 {object} Problem "Student does not match its schema"
+// @Failure 429 {object} Problem "Rate limit or daily quota exceeded."
+// @Failure 500 {object} Problem "Failed to create student"
+// @Router /v1/students [post]
+func createStudentHandler(c *gin.Context) { createDocumentHandler(c) }
+
+// getStudentHandler godoc
+// @Summary Get a student
+// @Tags students
+// @Produce json
+// @Param id path string true "Student ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} Problem "Student not found"
+// @Failure 429 {object} Problem "Rate limit or daily quota exceeded."
+// @Failure 500 {object} Problem "Failed to retrieve student"
+// @Router /v1/students/{id} [get]
+func getStudentHandler(c *gin.Context) { getDocumentByIDHandler(c) }
+
+// updateStudentHandler godoc
+// @Summary Update a student
+// @Description Sets the given fields of a student, keeping the others.
+// @Tags students
+// @Accept json
+// @Produce json
+// @Param id path string true "Student ID"
+// @Param student body map[string]interface{} true "Fields to set"
+// @Success 200 {object} Response
+// @Failure 400 {object} Problem "Invalid request"
+// @Failure 404 {object} Problem "Student not found"
+// @Failure 409 {object} Problem "A unique value is already used"
+// @Failure 422 {object} Problem "Student does not match its schema"
+// @Failure 429 {object} Problem "Rate limit or daily quota exceeded."
+// @Failure 500 {object} Problem "Failed to update student"
+// @Router /v1/students/{id} [put]
+func updateStudentHandler(c *gin.Context) { updateDocumentHandler(c) }
+
+// patchStudentHandler godoc
+// @Summary Patch a student
+// @Description Applies a JSON Patch (RFC 6902) or JSON Merge Patch (RFC 7396), optionally to the revision in If-Match.
+// @Description Members starting with an underscore and deleted_at cannot be patched.
+// @Tags students
+// @Accept application/json-patch+json,application/merge-patch+json
+// @Produce json
+// @Param id path string true "Student ID"
+// @Param If-Match header string false "Revision the patch applies to"
+// @Param patch body []PatchOperation true "JSON Patch operations, or a merge patch object"
+// @Success 200 {object} Response
+// @Failure 400 {object} Problem "Invalid patch"
+// @Failure 404 {object} Problem "Student not found"
+// @Failure 409 {object} Problem "Test operation failed, student updated concurrently, or a unique value is already used"
+// @Failure 412 {object} Problem "Revision does not match"
+// @Failure 415 {object} Problem "Unsupported patch format"
+// @Failure 422 {object} Problem "Patch does not apply, changes a protected member, or the result does not match its schema"
+// @Failure 429 {object} Problem "Rate limit or daily quota exceeded."
+// @Failure 500 {object} Problem "Failed to patch student"
+// @Router /v1/students/{id} [patch]
+func patchStudentHandler(c *gin.Context) { patchDocumentHandler(c) }
+
+// deleteStudentHandler godoc
+// @Summary Delete a student
+// @Description Deletes a student. With SOFT_DELETE turned on, the student is moved to the trash instead, or deleted
+// @Description right away with permanent.
+// @Tags students
+// @Produce json
+// @Param id path string true "Student ID"
+// @Param permanent query bool false "Delete without going through the trash" default(false)
+// @Success 200 {object} DeleteResponse
+// @Failure 404 {object} Problem "Student not found"
+// @Failure 429 {object} Problem "Rate limit or daily quota exceeded."
+// @Failure 500 {object} Problem "Failed to delete student"
+// @Router /v1/students/{id} [delete]
+func deleteStudentHandler(c *gin.Context) { deleteDocumentHandler(c) }
+
+// restoreStudentHandler godoc
+// @Summary Restore a deleted student
+// @Tags students
+// @Produce json
+// @Param id path string true "Student ID"
+// @Success 200 {object} Response
+// @Failure 404 {object} Problem "Student not found"
+// @Failure 409 {object} Problem "Student is not deleted, or a unique value is already used"
+// @Failure 410 {object} Problem "Last revision no longer available"
+// @Failure 422 {object} Problem "Student does not match its schema"
+// @Failure 429 {object} Problem "Rate limit or daily quota exceeded."
+// @Failure 500 {object} Problem "Failed to restore student"
+// @Router /v1/students/{id}/restore [post]
+func restoreStudentHandler(c *gin.Context) { restoreTrashHandler(c) }
+
+// uploadStudentAttachmentHandler godoc
+// @Summary Attach a file to a student
+// @Tags students
+// @Accept mpfd
+// @Produce json
+// @Param id path string true "Student ID"
+// @Param file formData file true "File to attach"
+// @Success 200 {object} Response
+// @Failure 400 {object} Problem "Missing file"
+// @Failure 404 {object} Problem "Student not found"
+// @Failure 429 {object} Problem "Rate limit or daily quota exceeded."
+// @Failure 500 {object} Problem "Failed to upload file"
+// @Router /v1/students/{id}/attachments [post]
+func uploadStudentAttachmentHandler(c *gin.Context) { uploadFileHandler(c) }
+
+// getStudentAttachmentHandler godoc
+// @Summary Download an attachment of a student
+// @Tags students
+// @Produce octet-stream
+// @Param id path string true "Student ID"
+// @Param name path string true "Attachment name"
+// @Success 200 {file} file
+// @Failure 404 {object} Problem "File not found"
+// @Failure 429 {object} Problem "Rate limit or daily quota exceeded."
+// @Failure 500 {object} Problem "Failed to retrieve file"
+// @Router /v1/students/{id}/attachments/{name} [get]
+func getStudentAttachmentHandler(c *gin.Context) { getFileHandler(c) }
+
+// importStudentsCSVHandler godoc
+// @Summary Import students from CSV
+// @Tags students
+// @Accept mpfd
+// @Produce json
+// @Param file formData file true "CSV file"
+// @Param mapping formData string false "Column mapping, e.g. {\"Full Name\":\"name\",\"Age\":\"age:int\"}"
+// @Param key formData string false "Natural key field" default(email)
+// @Param dry_run formData bool false "Validate and preview only" default(false)
+// @Success 200 {object} RosterImportResponse
+// @Failure 400 {object} Problem "Invalid file or mapping"
+// @Failure 429 {object} Problem "Rate limit or daily quota exceeded."
+// @Failure 500 {object} Problem "Failed to import roster"
+// @Router /v1/students/import/csv [post]
+func importStudentsCSVHandler(c *gin.Context) { importCSVHandler(c) }
+
+// importStudentsXLSXHandler godoc
+// @Summary Import students from Excel
+// @Tags students
+// @Accept mpfd
+// @Produce json
+// @Param file formData file true "XLSX file"
+// @Param sheet formData string false "Sheet name"
+// @Param mapping formData string false "Column mapping, e.g. {\"Full Name\":\"name\",\"Age\":\"age:int\"}"
+// @Param key formData string false "Natural key field" default(email)
+// @Param dry_run formData bool false "Validate and preview only" default(false)
+// @Success 200 {object} RosterImportResponse
+// @Failure 400 {object} Problem "Invalid file or mapping"
+// @Failure 429 {object} Problem "Rate limit or daily quota exceeded."
+// @Failure 500 {object} Problem "Failed to import roster"
+// @Router /v1/students/import/xlsx [post]
+func importStudentsXLSXHandler(c *gin.Context) { importXLSXHandler(c) }
+
+// studentChangesHandler godoc
+// @Summary Changes of students by address and age
+// @Tags students
+// @Produce json
+// @Param address query string true "Address"
+// @Param age query int true "Age"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} Problem "Address and age are required"
+// @Failure 429 {object} Problem "Rate limit or daily quota exceeded."
+// @Failure 500 {object} Problem "Failed to retrieve changes"
+// @Router /v1/changes [get]
+func studentChangesHandler(c *gin.Context) { filterDocuments(c) }
+
+// listStudentTrashHandler godoc
+// @Summary List deleted students
+// @Tags students
+// @Produce json
+// @Param limit query int false "Maximum number of students" default(100)
+// @Param bookmark query string false "Bookmark of the previous page"
+// @Success 200 {object} TrashResponse
+// @Failure 400 {object} Problem "Invalid limit"
+// @Failure 429 {object} Problem "Rate limit or daily quota exceeded."
+// @Failure 500 {object} Problem "Failed to list trash"
+// @Router /v1/trash [get]
+func listStudentTrashHandler(c *gin.Context) { listTrashHandler(c) }
+
+// studentAuditHandler godoc
+// @Summary Query the audit log of students
+// @Tags students
+// @Produce json
+// @Security BasicAuth
+// @Param tenant query string false "Tenant whose records are listed; those of the default database without it"
+// @Param doc_id query string false "Student ID"
+// @Param actor query string false "Actor, e.g. user:admin, key:… or ip:…"
+// @Param since query string false "Only records at or after this time"
+// @Param until query string false "Only records before this time"
+// @Param limit query int false "Maximum number of records" default(100)
+// @Param bookmark query string false "Bookmark of the previous page"
+// @Success 200 {object} AuditResponse
+// @Failure 400 {object} Problem "Invalid filter"
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Admin routes are disabled"
+// @Failure 429 {object} Problem "Rate limit or daily quota exceeded."
+// @Failure 500 {object} Problem "Failed to query audit log"
+// @Router /v1/audit [get]
+func studentAuditHandler(c *gin.Context) { auditHandler(c) }
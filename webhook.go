@@ -0,0 +1,707 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+const (
+	webhookDB           = "webhooks"
+	webhookDeadLetterDB = "webhook_dead_letters"
+	// webhookCheckpointID is the _local document of webhookDB holding the
+	// last seq of defaultDB whose deliveries are done; tenant databases have
+	// the database name appended. _local documents are not replicated, so
+	// each cluster keeps its own.
+	webhookCheckpointID = "_local/webhook-checkpoint"
+)
+
+var (
+	// webhookMaxAttempts is how often a delivery is tried before it is
+	// dead-lettered, at least once.
+	webhookMaxAttempts = max(1, envInt("WEBHOOK_MAX_ATTEMPTS", 5))
+	webhookHTTP        = &http.Client{Timeout: time.Duration(envInt("WEBHOOK_TIMEOUT_SECONDS", 10)) * time.Second}
+	// webhookQueueSize is how many deliveries may wait for a subscription.
+	// Changes beyond it are dead-lettered right away, so a slow endpoint
+	// does not hold up the others.
+	webhookQueueSize = max(1, envInt("WEBHOOK_QUEUE_SIZE", 1000))
+)
+
+// webhookRefresh is how long the worker uses its copy of the subscriptions.
+const webhookRefresh = 30 * time.Second
+
+type WebhookRequest struct {
+	URL string `json:"url" binding:"required"`
+	// Selector is a Mango selector documents must match; empty matches all.
+	Selector map[string]interface{} `json:"selector"`
+	// Secret signs the deliveries. It cannot be read back.
+	Secret string `json:"secret" binding:"required"`
+}
+
+type Webhook struct {
+	ID  string `json:"id"`
+	Rev string `json:"rev"`
+	// Tenant is the tenant whose changes are delivered, empty for the
+	// default student database.
+	Tenant    string                 `json:"tenant,omitempty"`
+	URL       string                 `json:"url"`
+	Selector  map[string]interface{} `json:"selector,omitempty"`
+	CreatedAt string                 `json:"created_at"`
+}
+
+type webhookDoc struct {
+	ID        string                 `json:"_id,omitempty"`
+	Rev       string                 `json:"_rev,omitempty"`
+	Tenant    string                 `json:"tenant,omitempty"`
+	URL       string                 `json:"url"`
+	Selector  map[string]interface{} `json:"selector,omitempty"`
+	Secret    string                 `json:"secret"`
+	CreatedAt string                 `json:"created_at"`
+}
+
+func (d webhookDoc) webhook() Webhook {
+	return Webhook{ID: d.ID, Rev: d.Rev, Tenant: d.Tenant, URL: d.URL, Selector: d.Selector, CreatedAt: d.CreatedAt}
+}
+
+// WebhookPayload is the body POSTed for a change.
+type WebhookPayload struct {
+	// ID identifies the delivery and stays the same across retries.
+	ID           string `json:"id"`
+	Subscription string `json:"subscription"`
+	Tenant       string `json:"tenant,omitempty"`
+	Seq          string `json:"seq"`
+	DocID        string `json:"doc_id"`
+	// Deleted tells whether the document was deleted or moved to the trash.
+	// Doc is then the tombstone or the trashed document.
+	Deleted bool            `json:"deleted"`
+	Doc     json.RawMessage `json:"doc" swaggertype:"object"`
+}
+
+type DeadLetter struct {
+	ID           string         `json:"_id,omitempty"`
+	Subscription string         `json:"subscription"`
+	URL          string         `json:"url"`
+	Payload      WebhookPayload `json:"payload"`
+	Attempts     int            `json:"attempts"`
+	LastStatus   int            `json:"last_status,omitempty"`
+	LastError    string         `json:"last_error"`
+	FailedAt     string         `json:"failed_at"`
+}
+
+type webhookCheckpoint struct {
+	Rev     string `json:"_rev,omitempty"`
+	LastSeq string `json:"last_seq"`
+}
+
+// webhookWorker follows the changes feed of a student database and delivers
+// every change to the subscriptions of its tenant whose selector it matches.
+// Each subscription has a queue of its own, delivered in order, so a slow
+// endpoint only delays its own deliveries.
+type webhookWorker struct {
+	db       string
+	subs     []webhookDoc
+	loadedAt time.Time
+	queues   map[string]*webhookQueue
+	running  sync.WaitGroup
+	// seq is where the feed resumes.
+	seq string
+
+	mu sync.Mutex
+	// pending are the dispatched changes, in feed order, from the oldest one
+	// with a delivery still to make on.
+	pending []*pendingChange
+	// done is the last seq up to which every delivery is made.
+	done string
+
+	saveMu     sync.Mutex
+	checkpoint webhookCheckpoint
+}
+
+// pendingChange counts the deliveries of a change still to be made.
+type pendingChange struct {
+	seq  string
+	left int
+}
+
+// webhookQueue holds the deliveries of one subscription.
+type webhookQueue struct {
+	sub webhookDoc
+	ch  chan webhookDelivery
+}
+
+type webhookDelivery struct {
+	payload WebhookPayload
+	change  *pendingChange
+}
+
+// runWebhooks runs a webhook worker for every student database until ctx is
+// done.
+func runWebhooks(ctx context.Context) {
+	followStudentDBs(ctx, runWebhookWorker)
+}
+
+// runWebhookWorker delivers the changes of db until ctx is done, restarting
+// the changes feed with backoff when it fails.
+func runWebhookWorker(ctx context.Context, db string) {
+	w := &webhookWorker{db: db, queues: map[string]*webhookQueue{}}
+	defer w.stop(ctx)
+	go every(ctx, 5*time.Second, func(time.Time) { w.saveCheckpoint(ctx) })
+
+	delay := time.Second
+	for ctx.Err() == nil {
+		progressed, err := w.follow(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if progressed {
+			delay = time.Second
+		}
+		slog.Warn("Webhook changes feed stopped", "db", db, "error", err, "retry_in", delay.String())
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, time.Minute)
+	}
+}
+
+// follow reads the changes feed from where it stopped, or the checkpoint, on
+// until it fails, queueing the deliveries of every change.
+func (w *webhookWorker) follow(ctx context.Context) (progressed bool, err error) {
+	if w.seq == "" {
+		if err := w.loadCheckpoint(ctx); err != nil {
+			return false, err
+		}
+	}
+
+	changes := client.DB(w.db).Changes(ctx, kivik.Options{
+		"feed":         "continuous",
+		"since":        w.seq,
+		"include_docs": true,
+		"heartbeat":    30000,
+	})
+	defer changes.Close()
+	for changes.Next() {
+		if err := w.dispatchChange(ctx, changes); err != nil {
+			return progressed, err
+		}
+		progressed = true
+		w.seq = changes.Seq()
+	}
+	if err := changes.Err(); err != nil {
+		return progressed, err
+	}
+	return progressed, fmt.Errorf("changes feed closed")
+}
+
+// checkpointID returns the _local document holding the checkpoint of w.db.
+func (w *webhookWorker) checkpointID() string {
+	if w.db == defaultDB {
+		return webhookCheckpointID
+	}
+	return webhookCheckpointID + "-" + w.db
+}
+
+// loadCheckpoint resumes from the stored checkpoint. Without one, only
+// changes made from now on are delivered.
+func (w *webhookWorker) loadCheckpoint(ctx context.Context) error {
+	w.saveMu.Lock()
+	defer w.saveMu.Unlock()
+	err := client.DB(webhookDB).Get(ctx, w.checkpointID()).ScanDoc(&w.checkpoint)
+	switch {
+	case kivik.HTTPStatus(err) == http.StatusNotFound:
+		w.seq = "now"
+	case err != nil:
+		return err
+	default:
+		w.seq = w.checkpoint.LastSeq
+	}
+	w.mu.Lock()
+	w.done = w.seq
+	w.mu.Unlock()
+	slog.Info("Webhook worker starting", "db", w.db, "since", w.seq)
+	return nil
+}
+
+// saveCheckpoint stores the seq up to which every delivery is made, unless
+// it is already stored. A failure only means some changes may be delivered
+// again after a restart.
+func (w *webhookWorker) saveCheckpoint(ctx context.Context) {
+	w.saveMu.Lock()
+	defer w.saveMu.Unlock()
+	w.mu.Lock()
+	seq := w.done
+	w.mu.Unlock()
+	if seq == "" || seq == "now" || seq == w.checkpoint.LastSeq {
+		return
+	}
+	cp := webhookCheckpoint{Rev: w.checkpoint.Rev, LastSeq: seq}
+	rev, err := client.DB(webhookDB).Put(context.WithoutCancel(ctx), w.checkpointID(), cp)
+	if err != nil {
+		slog.Warn("Failed to save webhook checkpoint", "db", w.db, "seq", seq, "error", err)
+		return
+	}
+	cp.Rev = rev
+	w.checkpoint = cp
+}
+
+// track records a dispatched change with n deliveries to make.
+func (w *webhookWorker) track(seq string, n int) *pendingChange {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	change := &pendingChange{seq: seq, left: n}
+	w.pending = append(w.pending, change)
+	w.advance()
+	return change
+}
+
+// finish records a delivery of change as made or dead-lettered.
+func (w *webhookWorker) finish(change *pendingChange) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	change.left--
+	w.advance()
+}
+
+// advance moves done past the changes whose deliveries, and those of every
+// earlier change, are made. w.mu is held.
+func (w *webhookWorker) advance() {
+	for len(w.pending) > 0 && w.pending[0].left == 0 {
+		w.done = w.pending[0].seq
+		w.pending = w.pending[1:]
+	}
+}
+
+// queue returns the delivery queue of sub, starting it if needed.
+func (w *webhookWorker) queue(ctx context.Context, sub webhookDoc) *webhookQueue {
+	if q, ok := w.queues[sub.ID]; ok {
+		return q
+	}
+	q := &webhookQueue{sub: sub, ch: make(chan webhookDelivery, webhookQueueSize)}
+	w.queues[sub.ID] = q
+	w.running.Add(1)
+	go func() {
+		defer w.running.Done()
+		for d := range q.ch {
+			if ctx.Err() != nil {
+				// Left for after a restart, which resumes from the checkpoint.
+				continue
+			}
+			deliverWebhook(ctx, q.sub, d.payload)
+			if ctx.Err() == nil {
+				w.finish(d.change)
+			}
+		}
+	}()
+	return q
+}
+
+// stop ends the delivery queues once they are drained or ctx is done, and
+// saves the checkpoint.
+func (w *webhookWorker) stop(ctx context.Context) {
+	for id, q := range w.queues {
+		close(q.ch)
+		delete(w.queues, id)
+	}
+	w.running.Wait()
+	w.saveCheckpoint(ctx)
+}
+
+// subscriptions returns the stored subscriptions of the tenant of w.db,
+// reloading them every webhookRefresh.
+func (w *webhookWorker) subscriptions(ctx context.Context) ([]webhookDoc, error) {
+	if w.subs != nil && time.Since(w.loadedAt) < webhookRefresh {
+		return w.subs, nil
+	}
+	start := time.Now()
+	rows := client.DB(webhookDB).AllDocs(ctx, kivik.Options{"include_docs": true})
+	defer rows.Close()
+	subs := []webhookDoc{}
+	for rows.Next() {
+		var doc webhookDoc
+		if err := rows.ScanDoc(&doc); err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(doc.ID, "_design/") && doc.Tenant == tenantOfDB(w.db) {
+			subs = append(subs, doc)
+		}
+	}
+	err := rows.Err()
+	observeCouch("AllDocs", start, err)
+	if err != nil {
+		return nil, err
+	}
+	w.subs, w.loadedAt = subs, time.Now()
+	// The queues of removed subscriptions deliver what they hold and end.
+	for id, q := range w.queues {
+		if !slices.ContainsFunc(subs, func(sub webhookDoc) bool { return sub.ID == id }) {
+			close(q.ch)
+			delete(w.queues, id)
+		}
+	}
+	return subs, nil
+}
+
+// dispatchChange queues the current change for every matching subscription.
+// A full queue dead-letters the delivery at once.
+func (w *webhookWorker) dispatchChange(ctx context.Context, changes *kivik.Changes) error {
+	id := changes.ID()
+	if strings.HasPrefix(id, "_design/") {
+		w.track(changes.Seq(), 0)
+		return nil
+	}
+	subs, err := w.subscriptions(ctx)
+	if err != nil {
+		return err
+	}
+	var raw json.RawMessage
+	if err := changes.ScanDoc(&raw); err != nil {
+		return fmt.Errorf("read %s: %w", id, err)
+	}
+	var doc interface{}
+	if err := decodeJSON(raw, &doc); err != nil {
+		return fmt.Errorf("read %s: %w", id, err)
+	}
+
+	// Deletions are matched against the last revision before them: the
+	// trashed document itself, or the revision before the tombstone. When
+	// CouchDB no longer has that one, they go to every subscription.
+	deleted, last := changes.Deleted(), doc
+	if m, ok := doc.(map[string]interface{}); ok && isTrashed(m) {
+		deleted = true
+	}
+	if changes.Deleted() {
+		prev, err := deletedRevision(ctx, w.db, id, false)
+		switch {
+		case err == nil:
+			last = prev
+		case kivik.HTTPStatus(err) == http.StatusNotFound || errors.Is(err, errNotRecoverable):
+			last = nil
+		default:
+			return fmt.Errorf("read %s: %w", id, err)
+		}
+	}
+
+	var matched []webhookDoc
+	for _, sub := range subs {
+		if last == nil || matchSelector(last, sub.Selector) {
+			matched = append(matched, sub)
+		}
+	}
+	change := w.track(changes.Seq(), len(matched))
+	for _, sub := range matched {
+		payload := WebhookPayload{
+			ID:           sub.ID + "/" + changes.Seq(),
+			Subscription: sub.ID,
+			Tenant:       sub.Tenant,
+			Seq:          changes.Seq(),
+			DocID:        id,
+			Deleted:      deleted,
+			Doc:          raw,
+		}
+		select {
+		case w.queue(ctx, sub).ch <- webhookDelivery{payload: payload, change: change}:
+		default:
+			webhookDeliveries.WithLabelValues("dead_lettered").Inc()
+			deadLetter(ctx, sub, payload, 0, 0, "delivery queue full")
+			w.finish(change)
+		}
+	}
+	return ctx.Err()
+}
+
+// deliverWebhook POSTs payload to the subscription, retrying with
+// exponential backoff, and dead-letters it when every attempt fails.
+func deliverWebhook(ctx context.Context, sub webhookDoc, payload WebhookPayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("Failed to encode webhook payload", "subscription", sub.ID, "error", err)
+		return
+	}
+
+	var status int
+	backoff := time.Second
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		if attempt > 1 {
+			webhookDeliveries.WithLabelValues("retried").Inc()
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff + rand.N(backoff/2)):
+			}
+			backoff = min(2*backoff, time.Minute)
+		}
+		status, err = postWebhook(ctx, sub, payload.ID, body)
+		if err == nil {
+			webhookDeliveries.WithLabelValues("delivered").Inc()
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Warn("Webhook delivery failed", "subscription", sub.ID, "delivery", payload.ID, "attempt", attempt, "error", err)
+	}
+
+	webhookDeliveries.WithLabelValues("dead_lettered").Inc()
+	deadLetter(ctx, sub, payload, webhookMaxAttempts, status, err.Error())
+}
+
+// deadLetter stores a delivery that could not be made.
+func deadLetter(ctx context.Context, sub webhookDoc, payload WebhookPayload, attempts, status int, lastError string) {
+	letter := DeadLetter{
+		Subscription: sub.ID,
+		URL:          sub.URL,
+		Payload:      payload,
+		Attempts:     attempts,
+		LastStatus:   status,
+		LastError:    lastError,
+		FailedAt:     time.Now().UTC().Format(time.RFC3339),
+	}
+	start := time.Now()
+	_, _, err := client.DB(webhookDeadLetterDB).CreateDoc(context.WithoutCancel(ctx), letter)
+	observeCouch("Put", start, err)
+	if err != nil {
+		slog.Error("Failed to dead-letter webhook delivery", "subscription", sub.ID, "delivery", payload.ID, "error", err)
+	}
+}
+
+// postWebhook sends one delivery attempt. The X-Webhook-Signature header is
+// the hex HMAC-SHA256, keyed with the subscription secret, of the
+// X-Webhook-Timestamp value, a dot and the body.
+func postWebhook(ctx context.Context, sub webhookDoc, deliveryID string, body []byte) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(sub.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", serviceName)
+	req.Header.Set("X-Webhook-ID", deliveryID)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	resp, err := webhookHTTP.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// registerWebhookRoutes adds the subscription endpoints to the admin group.
+func registerWebhookRoutes(admin *gin.RouterGroup) {
+	admin.POST("/webhooks", adminTenantScope(false), idempotency, createWebhookHandler)
+	admin.GET("/webhooks", listWebhooksHandler)
+	admin.GET("/webhooks/:id", getWebhookHandler)
+	admin.DELETE("/webhooks/:id", idempotency, deleteWebhookHandler)
+	admin.GET("/webhooks/:id/dead-letters", webhookDeadLettersHandler)
+}
+
+// createWebhookHandler godoc
+// @Summary Subscribe a webhook
+// @Description Subscribes a URL to changes of the student database of the tenant named by the tenant parameter. Every
+// @Description change of a document matching the selector is POSTed as a WebhookPayload; deletions and moves to the
+// @Description trash, flagged as deleted, match when the last revision before them did. The X-Webhook-Signature header is
+// @Description sha256= followed by the hex HMAC-SHA256, keyed with the secret, of the X-Webhook-Timestamp header, a
+// @Description dot and the body. Failed deliveries are retried with exponential backoff and then dead-lettered.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Param tenant query string false "Tenant whose database is used; the default database without it"
+// @Param subscription body WebhookRequest true "Subscription"
+// @Success 201 {object} Webhook
+// @Failure 400 {object} Problem "Invalid request"
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Admin routes are disabled"
+// @Failure 500 {object} Problem "Failed to create subscription"
+// @Router /admin/webhooks [post]
+func createWebhookHandler(c *gin.Context) {
+	var req WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondProblem(c, http.StatusBadRequest, codeInvalidRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		respondProblem(c, http.StatusBadRequest, codeInvalidRequest, "url must be an absolute http or https URL")
+		return
+	}
+	if err := checkSelector(req.Selector); err != nil {
+		respondProblem(c, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+
+	doc := webhookDoc{
+		Tenant:    tenantOf(c),
+		URL:       req.URL,
+		Selector:  req.Selector,
+		Secret:    req.Secret,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	var err error
+	doc.ID, doc.Rev, err = client.DB(webhookDB).CreateDoc(c.Request.Context(), doc)
+	if err != nil {
+		respondCouchError(c, "Failed to create webhook", err)
+		return
+	}
+	c.JSON(http.StatusCreated, doc.webhook())
+}
+
+// listWebhooksHandler godoc
+// @Summary List webhooks
+// @Description Lists the webhook subscriptions, without their secrets
+// @Tags webhooks
+// @Produce json
+// @Security BasicAuth
+// @Success 200 {array} Webhook
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Admin routes are disabled"
+// @Failure 500 {object} Problem "Failed to list webhooks"
+// @Router /admin/webhooks [get]
+func listWebhooksHandler(c *gin.Context) {
+	rows := client.DB(webhookDB).AllDocs(c.Request.Context(), kivik.Options{"include_docs": true})
+	defer rows.Close()
+	hooks := []Webhook{}
+	for rows.Next() {
+		var doc webhookDoc
+		if err := rows.ScanDoc(&doc); err != nil {
+			logError(c, "Failed to read webhook", err)
+			continue
+		}
+		if !strings.HasPrefix(doc.ID, "_design/") {
+			hooks = append(hooks, doc.webhook())
+		}
+	}
+	if err := rows.Err(); err != nil {
+		respondCouchError(c, "Failed to list webhooks", err)
+		return
+	}
+	c.JSON(http.StatusOK, hooks)
+}
+
+// loadWebhook fetches a subscription, answering 404 or 500 itself when that
+// fails.
+func loadWebhook(c *gin.Context) (webhookDoc, bool) {
+	var doc webhookDoc
+	err := client.DB(webhookDB).Get(c.Request.Context(), c.Param("id")).ScanDoc(&doc)
+	if kivik.HTTPStatus(err) == http.StatusNotFound {
+		respondProblem(c, http.StatusNotFound, codeNotFound, "Webhook not found")
+		return doc, false
+	}
+	if err != nil {
+		respondCouchError(c, "Failed to retrieve webhook", err)
+		return doc, false
+	}
+	return doc, true
+}
+
+// getWebhookHandler godoc
+// @Summary Get a webhook
+// @Description Returns one webhook subscription, without its secret
+// @Tags webhooks
+// @Produce json
+// @Security BasicAuth
+// @Param id path string true "Subscription ID"
+// @Success 200 {object} Webhook
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Admin routes are disabled"
+// @Failure 404 {object} Problem "Webhook not found"
+// @Failure 500 {object} Problem "Failed to retrieve webhook"
+// @Router /admin/webhooks/{id} [get]
+func getWebhookHandler(c *gin.Context) {
+	if doc, ok := loadWebhook(c); ok {
+		c.JSON(http.StatusOK, doc.webhook())
+	}
+}
+
+// deleteWebhookHandler godoc
+// @Summary Delete a webhook
+// @Description Removes a webhook subscription. The worker stops delivering to it within 30 seconds.
+// @Tags webhooks
+// @Produce json
+// @Security BasicAuth
+// @Param id path string true "Subscription ID"
+// @Success 200 {object} Response
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Admin routes are disabled"
+// @Failure 404 {object} Problem "Webhook not found"
+// @Failure 500 {object} Problem "Failed to delete webhook"
+// @Router /admin/webhooks/{id} [delete]
+func deleteWebhookHandler(c *gin.Context) {
+	doc, ok := loadWebhook(c)
+	if !ok {
+		return
+	}
+	rev, err := client.DB(webhookDB).Delete(c.Request.Context(), doc.ID, doc.Rev)
+	if err != nil {
+		respondCouchError(c, "Failed to delete webhook", err)
+		return
+	}
+	c.JSON(http.StatusOK, Response{Message: "Webhook deleted", Rev: rev})
+}
+
+// webhookDeadLettersHandler godoc
+// @Summary List dead-lettered deliveries
+// @Description Lists the deliveries to a webhook that failed every attempt
+// @Tags webhooks
+// @Produce json
+// @Security BasicAuth
+// @Param id path string true "Subscription ID"
+// @Param limit query int false "Maximum number of deliveries" default(100)
+// @Success 200 {array} DeadLetter
+// @Failure 400 {object} Problem "Invalid limit"
+// @Failure 401 {object} Problem "Unauthorized"
+// @Failure 403 {object} Problem "Admin routes are disabled"
+// @Failure 500 {object} Problem "Failed to list dead letters"
+// @Router /admin/webhooks/{id}/dead-letters [get]
+func webhookDeadLettersHandler(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 {
+		respondProblem(c, http.StatusBadRequest, codeInvalidRequest, "limit must be a positive number")
+		return
+	}
+	rs := client.DB(webhookDeadLetterDB).Find(c.Request.Context(), map[string]interface{}{
+		"selector": map[string]interface{}{"subscription": c.Param("id")},
+		"limit":    limit,
+	})
+	defer rs.Close()
+	letters := []DeadLetter{}
+	for rs.Next() {
+		var letter DeadLetter
+		if err := rs.ScanDoc(&letter); err != nil {
+			logError(c, "Failed to read dead letter", err)
+			continue
+		}
+		letters = append(letters, letter)
+	}
+	if err := rs.Err(); err != nil {
+		respondCouchError(c, "Failed to list dead letters", err)
+		return
+	}
+	c.JSON(http.StatusOK, letters)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+func TestWebhookDeliversDeletions(t *testing.T) {
+	f := newFakeCouch(t, defaultDB, webhookDB)
+	docs := f.dbs[defaultDB]
+	for _, id := range []string{"s-deleted", "s-trashed", "s-other"} {
+		grade := 5
+		if id == "s-other" {
+			grade = 4
+		}
+		f.store(docs, id, map[string]interface{}{"name": id, "grade": grade}, false)
+	}
+	f.store(docs, "s-deleted", map[string]interface{}{"_deleted": true}, false)
+	f.store(docs, "s-other", map[string]interface{}{"_deleted": true}, false)
+	f.store(docs, "s-trashed", map[string]interface{}{"name": "s-trashed", "grade": 5, trashField: "2026-01-01T00:00:00Z"}, false)
+
+	var mu sync.Mutex
+	got := map[string]WebhookPayload{}
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p WebhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		got[p.DocID] = p
+		mu.Unlock()
+	}))
+	defer endpoint.Close()
+
+	ctx := context.Background()
+	w := &webhookWorker{db: defaultDB, queues: map[string]*webhookQueue{}, loadedAt: time.Now()}
+	w.subs = []webhookDoc{{ID: "grade-5", URL: endpoint.URL, Selector: map[string]interface{}{"grade": float64(5)}, Secret: "secret"}}
+	changes := client.DB(defaultDB).Changes(ctx, kivik.Options{"include_docs": true})
+	for changes.Next() {
+		if err := w.dispatchChange(ctx, changes); err != nil {
+			t.Fatal(err)
+		}
+	}
+	changes.Close()
+	w.stop(ctx)
+
+	var ids []string
+	for id, p := range got {
+		ids = append(ids, id)
+		if !p.Deleted {
+			t.Errorf("payload of %s is not flagged as deleted", id)
+		}
+	}
+	sort.Strings(ids)
+	if len(ids) != 2 || ids[0] != "s-deleted" || ids[1] != "s-trashed" {
+		t.Errorf("delivered %v, want s-deleted and s-trashed", ids)
+	}
+}